@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"aq-server/internal/audit"
+	"aq-server/internal/auth"
+	"aq-server/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyRequest names a new API key
+type APIKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// APIKeyResponse represents an API key in responses. The key itself is only set
+// when it's created; afterwards the prefix tells keys apart.
+type APIKeyResponse struct {
+	ID                 string     `json:"id"`
+	Name               string     `json:"name"`
+	Key                string     `json:"key,omitempty"`
+	Prefix             string     `json:"prefix"`
+	Active             bool       `json:"active"`
+	RateLimitPerMinute int        `json:"rate_limit_per_minute"`
+	CreatedAt          time.Time  `json:"created_at"`
+	LastUsedAt         *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty"`
+}
+
+func newAPIKeyResponse(key *database.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:                 key.ID,
+		Name:               key.Name,
+		Prefix:             key.Prefix,
+		Active:             key.IsActive,
+		RateLimitPerMinute: key.RateLimitPerMinute,
+		CreatedAt:          key.CreatedAt,
+		LastUsedAt:         key.LastUsedAt,
+		RevokedAt:          key.RevokedAt,
+	}
+}
+
+// APIKeysHandler lists a company's API keys and creates new ones. A company can have
+// several, e.g. one per backend, and rotates a key by creating a new one and
+// revoking the old one.
+func APIKeysHandler(auditor *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listAPIKeys(w, r)
+		case http.MethodPost:
+			createAPIKey(w, r, auditor)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	company, ok := apiKeyCompany(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := database.ListAPIKeys(company.ID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "database error: " + err.Error(),
+		})
+		return
+	}
+
+	responses := make([]APIKeyResponse, len(keys))
+	for i := range keys {
+		responses[i] = newAPIKeyResponse(&keys[i])
+	}
+
+	respondJSON(w, http.StatusOK, responses)
+}
+
+func createAPIKey(w http.ResponseWriter, r *http.Request, auditor *audit.Recorder) {
+	var req APIKeyRequest
+
+	// Parse request body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "invalid request body: " + err.Error(),
+		})
+		return
+	}
+
+	// Validate request
+	if req.Name == "" || len(req.Name) > 100 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "name is required and at most 100 characters",
+		})
+		return
+	}
+
+	company, ok := apiKeyCompany(w, r)
+	if !ok {
+		return
+	}
+
+	apiKey, err := auth.GenerateAPIKey()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	key := database.NewAPIKey(company.ID, req.Name, apiKey)
+	key.ID = uuid.NewString()
+	if err := database.CreateAPIKey(key); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "failed to store api key: " + err.Error(),
+		})
+		return
+	}
+
+	recordAudit(auditor, r, audit.EventAPIKeyCreated, "api_key", key.ID, map[string]any{
+		"name":   key.Name,
+		"prefix": key.Prefix,
+	})
+
+	response := newAPIKeyResponse(key)
+	response.Key = apiKey
+
+	respondJSON(w, http.StatusCreated, response)
+}
+
+// RevokeAPIKeyHandler revokes a company's API key: DELETE /api/v1/api-keys/{id}.
+// The last active key can't be revoked, so a company can't lock itself out.
+func RevokeAPIKeyHandler(auditor *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		keyID := strings.TrimPrefix(r.URL.Path, "/api/v1/api-keys/")
+		if _, err := uuid.Parse(keyID); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "invalid api key id",
+			})
+			return
+		}
+
+		company, ok := apiKeyCompany(w, r)
+		if !ok {
+			return
+		}
+
+		revoked, err := database.RevokeAPIKey(company.ID, keyID)
+		if errors.Is(err, database.ErrLastAPIKey) {
+			respondJSON(w, http.StatusConflict, map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "database error: " + err.Error(),
+			})
+			return
+		}
+		if !revoked {
+			respondJSON(w, http.StatusNotFound, map[string]string{
+				"error": "active api key not found",
+			})
+			return
+		}
+
+		recordAudit(auditor, r, audit.EventAPIKeyRevoked, "api_key", keyID, nil)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"aq-server/internal/audit"
+	"aq-server/internal/auth"
+	"aq-server/internal/database"
+)
+
+// Audit log entries are listed in pages of defaultAuditLimit, or up to maxAuditLimit
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// auditActor identifies the caller of a request by its API key or room token
+func auditActor(r *http.Request) (string, string) {
+	if key, ok := r.Context().Value(APIKeyKey).(*database.APIKey); ok {
+		return audit.ActorAPIKey, key.ID
+	}
+	if claims, ok := r.Context().Value(ClaimsKey).(*auth.Claims); ok {
+		return audit.ActorToken, claims.TokenID
+	}
+	return audit.ActorAnonymous, ""
+}
+
+// recordAudit records an event caused by a request of the caller's company
+func recordAudit(auditor *audit.Recorder, r *http.Request, eventType, resourceType, resourceID string, details map[string]any) {
+	companyID, _ := r.Context().Value(CompanyIDKey).(string)
+	actorType, actorID := auditActor(r)
+	if details == nil {
+		details = make(map[string]any)
+	}
+	details["address"] = auditor.ClientAddress(r)
+
+	auditor.Record(audit.Entry{
+		CompanyID:    companyID,
+		EventType:    eventType,
+		ActorType:    actorType,
+		ActorID:      actorID,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Details:      details,
+	})
+}
+
+// AuditLogResponse represents an audit log entry in responses
+type AuditLogResponse struct {
+	ID           string         `json:"id"`
+	EventType    string         `json:"event_type"`
+	ActorType    string         `json:"actor_type"`
+	ActorID      string         `json:"actor_id"`
+	ResourceType string         `json:"resource_type"`
+	ResourceID   string         `json:"resource_id"`
+	Action       string         `json:"action"`
+	Status       string         `json:"status"`
+	Details      map[string]any `json:"details"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+// AuditLogsResponse is a page of audit log entries
+type AuditLogsResponse struct {
+	Entries []AuditLogResponse `json:"entries"`
+	Total   int64              `json:"total"`
+	Limit   int                `json:"limit"`
+	Offset  int                `json:"offset"`
+}
+
+// AuditLogsHandler lists the audit log of the caller's company, most recent first.
+// The query filters by event_type, actor_type, actor_id, resource_type, resource_id,
+// status and a time range (from and to, RFC 3339), and pages with limit and offset.
+func AuditLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	company, ok := apiKeyCompany(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := parseAuditLogFilter(r.URL.Query())
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	filter.CompanyID = company.ID
+
+	entries, total, err := database.ListAuditLogs(filter)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "database error: " + err.Error(),
+		})
+		return
+	}
+
+	response := AuditLogsResponse{
+		Entries: make([]AuditLogResponse, len(entries)),
+		Total:   total,
+		Limit:   filter.Limit,
+		Offset:  filter.Offset,
+	}
+	for i, entry := range entries {
+		response.Entries[i] = AuditLogResponse{
+			ID:           entry.ID,
+			EventType:    entry.EventType,
+			ActorType:    entry.ActorType,
+			ActorID:      entry.ActorID,
+			ResourceType: entry.ResourceType,
+			ResourceID:   entry.ResourceID,
+			Action:       entry.Action,
+			Status:       entry.Status,
+			Details:      auditDetails(entry.Details),
+			CreatedAt:    entry.CreatedAt,
+		}
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// auditDetails decodes the details of an entry, empty if they can't be read
+func auditDetails(raw []byte) map[string]any {
+	details := make(map[string]any)
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, &details)
+	}
+	return details
+}
+
+// parseAuditLogFilter reads the filter of an audit log query
+func parseAuditLogFilter(query url.Values) (database.AuditLogFilter, error) {
+	filter := database.AuditLogFilter{
+		EventType:    query.Get("event_type"),
+		ActorType:    query.Get("actor_type"),
+		ActorID:      query.Get("actor_id"),
+		ResourceType: query.Get("resource_type"),
+		ResourceID:   query.Get("resource_id"),
+		Status:       query.Get("status"),
+	}
+
+	var err error
+	if filter.From, filter.To, err = parseTimeRange(query); err != nil {
+		return filter, err
+	}
+	if filter.Limit, filter.Offset, err = parsePage(query, defaultAuditLimit, maxAuditLimit); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseAuditLogFilter(t *testing.T) {
+	filter, err := parseAuditLogFilter(url.Values{})
+	if err != nil {
+		t.Fatalf("Expected the default filter, got %v", err)
+	}
+	if filter.Limit != defaultAuditLimit || filter.Offset != 0 || filter.From != nil || filter.To != nil {
+		t.Errorf("Expected the first page of everything, got %+v", filter)
+	}
+
+	filter, err = parseAuditLogFilter(url.Values{
+		"event_type": {"room.deleted"},
+		"status":     {"failure"},
+		"from":       {"2026-10-01T00:00:00Z"},
+		"limit":      {"10"},
+		"offset":     {"20"},
+	})
+	if err != nil {
+		t.Fatalf("Expected a valid filter, got %v", err)
+	}
+	if filter.EventType != "room.deleted" || filter.Status != "failure" || filter.Limit != 10 || filter.Offset != 20 {
+		t.Errorf("Expected the query's filter, got %+v", filter)
+	}
+	if filter.From == nil || !filter.From.Equal(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected entries from October 1st, got %v", filter.From)
+	}
+
+	invalid := []url.Values{
+		{"from": {"2026-10-01"}},
+		{"limit": {"0"}},
+		{"limit": {"1001"}},
+		{"offset": {"-1"}},
+	}
+	for _, query := range invalid {
+		if _, err := parseAuditLogFilter(query); err == nil {
+			t.Errorf("Expected %v to be rejected", query)
+		}
+	}
+}
@@ -1,70 +0,0 @@
-package api
-
-import (
-	"crypto/sha256"
-	"encoding/hex"
-	"fmt"
-	"time"
-
-	"github.com/golang-jwt/jwt/v5"
-)
-
-// TokenClaims represents JWT token claims
-type TokenClaims struct {
-	CompanyID string `json:"company_id"`
-	RoomID    string `json:"room_id"`
-	UserName  string `json:"user_name"`
-	jwt.RegisteredClaims
-}
-
-// GenerateToken creates a new JWT token for room access
-func GenerateToken(companyID, roomID, userName, secretKey string, duration int) (string, time.Time, error) {
-	expiresAt := time.Now().Add(time.Duration(duration) * time.Second)
-
-	claims := TokenClaims{
-		CompanyID: companyID,
-		RoomID:    roomID,
-		UserName:  userName,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expiresAt),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(secretKey))
-	if err != nil {
-		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
-	}
-
-	return tokenString, expiresAt, nil
-}
-
-// ValidateToken validates a JWT token and returns the claims
-func ValidateToken(tokenString, secretKey string) (*TokenClaims, error) {
-	claims := &TokenClaims{}
-
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(secretKey), nil
-	})
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %w", err)
-	}
-
-	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
-	}
-
-	return claims, nil
-}
-
-// HashToken creates a SHA256 hash of a token for storage
-func HashToken(token string) string {
-	hash := sha256.Sum256([]byte(token))
-	return hex.EncodeToString(hash[:])
-}
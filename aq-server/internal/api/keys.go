@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"aq-server/internal/audit"
+	"aq-server/internal/auth"
+	"aq-server/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// SigningKeyRequest adds a signing key to a company. With a public key the company
+// keeps its private key to itself; without one the server generates a key pair and
+// returns the private key once.
+type SigningKeyRequest struct {
+	Algorithm string `json:"algorithm"`  // RS256 or EdDSA, defaults to RS256
+	PublicKey string `json:"public_key"` // PEM encoded
+}
+
+// SigningKeyResponse represents a signing key in responses. The private key is only
+// set when the server generated it.
+type SigningKeyResponse struct {
+	ID         string     `json:"kid"`
+	Algorithm  string     `json:"algorithm"`
+	PublicKey  string     `json:"public_key"`
+	PrivateKey string     `json:"private_key,omitempty"`
+	Active     bool       `json:"active"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RetiredAt  *time.Time `json:"retired_at,omitempty"`
+}
+
+func newSigningKeyResponse(key *database.SigningKey) SigningKeyResponse {
+	return SigningKeyResponse{
+		ID:        key.ID,
+		Algorithm: key.Algorithm,
+		PublicKey: key.PublicKey,
+		Active:    key.Active,
+		CreatedAt: key.CreatedAt,
+		RetiredAt: key.RetiredAt,
+	}
+}
+
+// SigningKeysHandler lists a company's signing keys and adds new ones. Tokens signed
+// with any active key validate, so keys are rotated by adding a new key, moving the
+// backend over to it and then retiring the old one.
+func SigningKeysHandler(auditor *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listSigningKeys(w, r)
+		case http.MethodPost:
+			createSigningKey(w, r, auditor)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listSigningKeys(w http.ResponseWriter, r *http.Request) {
+	company, ok := apiKeyCompany(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := database.ListSigningKeys(company.ID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "database error: " + err.Error(),
+		})
+		return
+	}
+
+	responses := make([]SigningKeyResponse, len(keys))
+	for i := range keys {
+		responses[i] = newSigningKeyResponse(&keys[i])
+	}
+
+	respondJSON(w, http.StatusOK, responses)
+}
+
+func createSigningKey(w http.ResponseWriter, r *http.Request, auditor *audit.Recorder) {
+	var req SigningKeyRequest
+
+	// Parse request body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "invalid request body: " + err.Error(),
+		})
+		return
+	}
+
+	if req.Algorithm == "" {
+		req.Algorithm = auth.AlgorithmRS256
+	}
+
+	var privateKey string
+	if req.PublicKey == "" {
+		var err error
+		req.PublicKey, privateKey, err = auth.GenerateKeyPair(req.Algorithm)
+		if errors.Is(err, auth.ErrUnsupportedAlgorithm) {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "failed to generate key: " + err.Error(),
+			})
+			return
+		}
+	} else if _, err := auth.ParsePublicKey(req.Algorithm, req.PublicKey); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "invalid public_key: " + err.Error(),
+		})
+		return
+	}
+
+	company, ok := apiKeyCompany(w, r)
+	if !ok {
+		return
+	}
+
+	key := &database.SigningKey{
+		ID:        uuid.NewString(),
+		CompanyID: company.ID,
+		Algorithm: req.Algorithm,
+		PublicKey: req.PublicKey,
+		Active:    true,
+	}
+	if err := database.CreateSigningKey(key); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "failed to store key: " + err.Error(),
+		})
+		return
+	}
+
+	recordAudit(auditor, r, audit.EventSigningKeyCreated, "signing_key", key.ID, map[string]any{
+		"algorithm": key.Algorithm,
+	})
+
+	response := newSigningKeyResponse(key)
+	response.PrivateKey = privateKey
+
+	respondJSON(w, http.StatusCreated, response)
+}
+
+// RetireSigningKeyHandler retires a company's signing key: DELETE /api/v1/keys/{kid}.
+// Tokens signed with it stop validating, participants that already joined with them
+// stay connected until their tokens are revoked.
+func RetireSigningKeyHandler(auditor *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		keyID := strings.TrimPrefix(r.URL.Path, "/api/v1/keys/")
+		if keyID == "" || strings.Contains(keyID, "/") {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "invalid path",
+			})
+			return
+		}
+
+		company, ok := apiKeyCompany(w, r)
+		if !ok {
+			return
+		}
+
+		retired, err := database.RetireSigningKey(company.ID, keyID)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "database error: " + err.Error(),
+			})
+			return
+		}
+		if !retired {
+			respondJSON(w, http.StatusNotFound, map[string]string{
+				"error": "active key not found",
+			})
+			return
+		}
+
+		recordAudit(auditor, r, audit.EventSigningKeyRetired, "signing_key", keyID, nil)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// JWKSHandler publishes the public keys of all active signing keys as a JSON Web Key
+// Set, so anyone can verify room tokens without asking the server
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys, err := database.ListActiveSigningKeys()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "database error: " + err.Error(),
+		})
+		return
+	}
+
+	jwks := auth.JWKS{Keys: make([]auth.JWK, 0, len(keys))}
+	for i := range keys {
+		jwk, err := auth.NewJWK(&keys[i])
+		if err != nil {
+			// Keys are validated when they're added, skip anything unreadable
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondJSON(w, http.StatusOK, jwks)
+}
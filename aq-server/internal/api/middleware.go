@@ -6,6 +6,10 @@ import (
 	"net/http"
 	"strings"
 
+	"aq-server/internal/audit"
+	"aq-server/internal/auth"
+	"aq-server/internal/database"
+
 	"github.com/pion/logging"
 )
 
@@ -18,8 +22,8 @@ const (
 	APIKeyKey    ContextKey = "api_key"
 )
 
-// AuthMiddleware validates JWT token in Authorization header
-func AuthMiddleware(secretKey string, logger logging.LeveledLogger) func(http.Handler) http.Handler {
+// AuthMiddleware validates room tokens in Authorization header
+func AuthMiddleware(validator *auth.Validator, auditor *audit.Recorder, logger logging.LeveledLogger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Get authorization header
@@ -42,8 +46,9 @@ func AuthMiddleware(secretKey string, logger logging.LeveledLogger) func(http.Ha
 			}
 
 			// Validate token
-			claims, err := ValidateToken(token, secretKey)
+			claims, err := validator.Validate(token)
 			if err != nil {
+				auditor.AuthFailure(r, "", audit.ActorToken, err.Error())
 				respondJSON(w, http.StatusUnauthorized, map[string]string{
 					"error": "invalid or expired token: " + err.Error(),
 				})
@@ -60,7 +65,7 @@ func AuthMiddleware(secretKey string, logger logging.LeveledLogger) func(http.Ha
 }
 
 // APIKeyMiddleware validates API key in Authorization header
-func APIKeyMiddleware(logger logging.LeveledLogger) func(http.Handler) http.Handler {
+func APIKeyMiddleware(auditor *audit.Recorder, logger logging.LeveledLogger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Get authorization header
@@ -82,13 +87,44 @@ func APIKeyMiddleware(logger logging.LeveledLogger) func(http.Handler) http.Hand
 				return
 			}
 
+			key, ok := authenticateAPIKey(w, r, auditor, apiKey)
+			if !ok {
+				return
+			}
+
 			// Store API key in context
-			ctx := context.WithValue(r.Context(), APIKeyKey, apiKey)
+			ctx := context.WithValue(r.Context(), APIKeyKey, key)
+			ctx = context.WithValue(ctx, CompanyIDKey, key.CompanyID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// authenticateAPIKey looks up an active API key of an active company, responding
+// with an error and recording the failure if there is none
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, auditor *audit.Recorder, apiKey string) (*database.APIKey, bool) {
+	key, err := database.GetAPIKey(apiKey)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "database error: " + err.Error(),
+		})
+		return nil, false
+	}
+	if key == nil || key.Company == nil || !key.Company.IsActive {
+		companyID, reason := "", "unknown or revoked api key"
+		if key != nil {
+			companyID, reason = key.CompanyID, "inactive company"
+		}
+		auditor.AuthFailure(r, companyID, audit.ActorAPIKey, reason)
+		respondJSON(w, http.StatusUnauthorized, map[string]string{
+			"error": "invalid api key",
+		})
+		return nil, false
+	}
+
+	return key, true
+}
+
 // respondJSON writes a JSON response
 func respondJSON(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
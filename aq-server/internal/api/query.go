@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// parseTimeRange reads the from and to of a query, RFC 3339 times that are nil if
+// missing
+func parseTimeRange(query url.Values) (*time.Time, *time.Time, error) {
+	var from, to *time.Time
+	for name, t := range map[string]**time.Time{"from": &from, "to": &to} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s, expected an RFC 3339 time", name)
+		}
+		*t = &parsed
+	}
+	if from != nil && to != nil && to.Before(*from) {
+		return nil, nil, fmt.Errorf("to must not be before from")
+	}
+
+	return from, to, nil
+}
+
+// parsePage reads the limit and offset of a paged query
+func parsePage(query url.Values, defaultLimit, maxLimit int) (int, int, error) {
+	limit, offset := defaultLimit, 0
+
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+		limit = parsed
+	}
+	if value := query.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("invalid offset")
+		}
+		offset = parsed
+	}
+
+	return limit, offset, nil
+}
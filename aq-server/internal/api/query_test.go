@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseTimeRange(t *testing.T) {
+	from, to, err := parseTimeRange(url.Values{"from": {"2026-10-01T00:00:00Z"}})
+	if err != nil {
+		t.Fatalf("Expected the range to parse, got %v", err)
+	}
+	if from == nil || !from.Equal(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)) || to != nil {
+		t.Errorf("Expected an open range from October 1st, got %v to %v", from, to)
+	}
+
+	for _, query := range []url.Values{
+		{"from": {"yesterday"}},
+		{"from": {"2026-10-02T00:00:00Z"}, "to": {"2026-10-01T00:00:00Z"}},
+	} {
+		if _, _, err := parseTimeRange(query); err == nil {
+			t.Errorf("Expected %v to be rejected", query)
+		}
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	if limit, offset, err := parsePage(url.Values{}, 50, 200); err != nil || limit != 50 || offset != 0 {
+		t.Errorf("Expected the default page, got %d, %d and %v", limit, offset, err)
+	}
+	if limit, offset, err := parsePage(url.Values{"limit": {"200"}, "offset": {"400"}}, 50, 200); err != nil || limit != 200 || offset != 400 {
+		t.Errorf("Expected limit 200 and offset 400, got %d, %d and %v", limit, offset, err)
+	}
+
+	for _, query := range []url.Values{{"limit": {"0"}}, {"limit": {"201"}}, {"offset": {"-1"}}} {
+		if _, _, err := parsePage(query, 50, 200); err == nil {
+			t.Errorf("Expected %v to be rejected", query)
+		}
+	}
+}
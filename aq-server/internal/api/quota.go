@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+
+	"aq-server/internal/quota"
+)
+
+// QuotaLimits are the limits of a company's tier in responses. Zero means unlimited.
+type QuotaLimits struct {
+	quota.Limits
+	MaxSessionSeconds int `json:"max_session_seconds"`
+}
+
+// QuotaResponse reports a company's limits and what it uses of them right now
+type QuotaResponse struct {
+	Tier   string      `json:"tier"`
+	Limits QuotaLimits `json:"limits"`
+	Usage  quota.Usage `json:"usage"`
+}
+
+// QuotaHandler reports the limits of the company's tier and its live participants,
+// rooms and publishers
+func QuotaHandler(participants Participants) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		company, ok := apiKeyCompany(w, r)
+		if !ok {
+			return
+		}
+
+		limits := quota.ForTier(company.Tier)
+
+		respondJSON(w, http.StatusOK, QuotaResponse{
+			Tier: company.Tier,
+			Limits: QuotaLimits{
+				Limits:            limits,
+				MaxSessionSeconds: int(limits.MaxSessionLength.Seconds()),
+			},
+			Usage: participants.Usage(company.ID),
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"aq-server/internal/auth"
+	"aq-server/internal/database"
+	"aq-server/internal/ratelimit"
+)
+
+// withRateLimit is a middleware that limits the requests of the authenticated API
+// key or room token in a budget, responding with 429 once it's used up. Every
+// response tells the caller how many requests it has left.
+func withRateLimit(limiter *ratelimit.Limiter, budget string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limiter == nil {
+			next(w, r)
+			return
+		}
+
+		caller, ok := rateLimitCaller(r)
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		result := limiter.Allow(budget, caller)
+		if result.Limit > 0 {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+		}
+
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			respondJSON(w, http.StatusTooManyRequests, map[string]string{
+				"error": "rate limit exceeded",
+			})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// rateLimitCaller returns the API key or room token the auth middleware stored in
+// the request context
+func rateLimitCaller(r *http.Request) (ratelimit.Caller, bool) {
+	if key, ok := r.Context().Value(APIKeyKey).(*database.APIKey); ok {
+		return ratelimit.Caller{CompanyID: key.CompanyID, CredentialType: ratelimit.CredentialAPIKey, CredentialID: key.ID, PerMinute: key.RateLimitPerMinute}, true
+	}
+	if claims, ok := r.Context().Value(ClaimsKey).(*auth.Claims); ok {
+		return ratelimit.Caller{CompanyID: claims.CompanyID, CredentialType: ratelimit.CredentialToken, CredentialID: claims.TokenID}, true
+	}
+
+	return ratelimit.Caller{}, false
+}
+
+// seconds rounds a duration up to whole seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"aq-server/internal/auth"
+	"aq-server/internal/database"
+	"aq-server/internal/ratelimit"
+)
+
+func TestWithRateLimit(t *testing.T) {
+	limiter := ratelimit.NewLimiter(0, map[string]int{ratelimit.BudgetTokens: 2})
+	handler := withRateLimit(limiter, ratelimit.BudgetTokens, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	key := &database.APIKey{ID: "key-1", CompanyID: "acme", RateLimitPerMinute: 60}
+	request := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/tokens", nil)
+		r = r.WithContext(context.WithValue(r.Context(), APIKeyKey, key))
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		w := request()
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected request %d to pass, got %d", i+1, w.Code)
+		}
+		if limit := w.Header().Get("X-RateLimit-Limit"); limit != "2" {
+			t.Errorf("Expected the company limit 2, got %s", limit)
+		}
+		if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != []string{"1", "0"}[i] {
+			t.Errorf("Expected %d remaining, got %s", 1-i, remaining)
+		}
+	}
+
+	w := request()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "30" {
+		t.Errorf("Expected to retry after 30 seconds, got %s", retryAfter)
+	}
+	if reset := w.Header().Get("X-RateLimit-Reset"); reset != "60" {
+		t.Errorf("Expected a reset in 60 seconds, got %s", reset)
+	}
+}
+
+func TestRateLimitCaller(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/rooms", nil)
+	if _, ok := rateLimitCaller(r); ok {
+		t.Error("Expected no caller without credentials")
+	}
+
+	claims := &auth.Claims{CompanyID: "acme", TokenID: "token-1"}
+	r = r.WithContext(context.WithValue(r.Context(), ClaimsKey, claims))
+
+	caller, ok := rateLimitCaller(r)
+	if !ok {
+		t.Fatal("Expected the room token to be the caller")
+	}
+	if expected := (ratelimit.Caller{CompanyID: "acme", CredentialType: ratelimit.CredentialToken, CredentialID: "token-1"}); caller != expected {
+		t.Errorf("Expected %+v, got %+v", expected, caller)
+	}
+}
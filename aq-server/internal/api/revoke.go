@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"aq-server/internal/audit"
+	"aq-server/internal/database"
+	"aq-server/internal/quota"
+
+	"github.com/google/uuid"
+)
+
+// Participants disconnects live participants and reports what a company uses of the
+// server, implemented by the signaling handler
+type Participants interface {
+	DisconnectTokens(tokenIDs []string) int
+	Usage(companyID string) quota.Usage
+}
+
+// RevokeRequest selects the tokens to revoke: one token by ID, or every token of a
+// user, of a room, or of a user in a room
+type RevokeRequest struct {
+	TokenID  string `json:"token_id"`
+	RoomID   string `json:"room_id"`
+	UserName string `json:"user_name"`
+}
+
+// RevokeResponse lists the revoked tokens and how many participants were disconnected
+type RevokeResponse struct {
+	Revoked      []string `json:"revoked"`
+	Disconnected int      `json:"disconnected"`
+}
+
+// RevokeTokensHandler revokes a company's tokens and disconnects the participants
+// that joined with them
+func RevokeTokensHandler(participants Participants, auditor *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req RevokeRequest
+
+		// Parse request body
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "invalid request body: " + err.Error(),
+			})
+			return
+		}
+
+		// Validate request
+		if req.TokenID == "" && req.RoomID == "" && req.UserName == "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "token_id, room_id or user_name is required",
+			})
+			return
+		}
+		if req.TokenID != "" {
+			if _, err := uuid.Parse(req.TokenID); err != nil {
+				respondJSON(w, http.StatusBadRequest, map[string]string{
+					"error": "invalid token_id",
+				})
+				return
+			}
+		}
+
+		company, ok := apiKeyCompany(w, r)
+		if !ok {
+			return
+		}
+
+		revoked, err := database.RevokeTokens(company.ID, database.TokenFilter{
+			ID:       req.TokenID,
+			RoomID:   req.RoomID,
+			UserName: req.UserName,
+		})
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "failed to revoke tokens: " + err.Error(),
+			})
+			return
+		}
+
+		disconnected := participants.DisconnectTokens(revoked)
+		recordAudit(auditor, r, audit.EventTokensRevoked, "token", req.TokenID, map[string]any{
+			"room_id":      req.RoomID,
+			"user_name":    req.UserName,
+			"revoked":      revoked,
+			"disconnected": disconnected,
+		})
+
+		respondJSON(w, http.StatusOK, RevokeResponse{
+			Revoked:      revoked,
+			Disconnected: disconnected,
+		})
+	}
+}
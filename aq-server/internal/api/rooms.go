@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"aq-server/internal/audit"
 	"aq-server/internal/database"
 	"github.com/google/uuid"
 )
@@ -74,70 +75,74 @@ func ListRoomsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // CreateRoomHandler creates a new room
-func CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func CreateRoomHandler(auditor *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
-	companyID := r.Context().Value(CompanyIDKey)
-	if companyID == nil {
-		respondJSON(w, http.StatusUnauthorized, map[string]string{
-			"error": "company id not found",
-		})
-		return
-	}
+		companyID := r.Context().Value(CompanyIDKey)
+		if companyID == nil {
+			respondJSON(w, http.StatusUnauthorized, map[string]string{
+				"error": "company id not found",
+			})
+			return
+		}
 
-	var req RoomRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "invalid request body: " + err.Error(),
-		})
-		return
-	}
+		var req RoomRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "invalid request body: " + err.Error(),
+			})
+			return
+		}
 
-	// Validate request
-	if req.RoomID == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "room_id is required",
-		})
-		return
-	}
+		// Validate request
+		if req.RoomID == "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "room_id is required",
+			})
+			return
+		}
 
-	// Create room
-	room := &database.Room{
-		ID:              uuid.New().String(),
-		CompanyID:       companyID.(string),
-		RoomID:          req.RoomID,
-		Name:            req.Name,
-		Description:     req.Description,
-		MaxParticipants: req.MaxParticipants,
-	}
+		// Create room
+		room := &database.Room{
+			ID:              uuid.New().String(),
+			CompanyID:       companyID.(string),
+			RoomID:          req.RoomID,
+			Name:            req.Name,
+			Description:     req.Description,
+			MaxParticipants: req.MaxParticipants,
+		}
 
-	if room.MaxParticipants == 0 {
-		room.MaxParticipants = 100 // Default max participants
-	}
+		if room.MaxParticipants == 0 {
+			room.MaxParticipants = 100 // Default max participants
+		}
 
-	result := database.DB.Create(room)
-	if result.Error != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": "failed to create room: " + result.Error.Error(),
+		result := database.DB.Create(room)
+		if result.Error != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "failed to create room: " + result.Error.Error(),
+			})
+			return
+		}
+
+		recordAudit(auditor, r, audit.EventRoomCreated, "room", room.ID, roomAuditDetails(room))
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(RoomResponse{
+			ID:              room.ID,
+			CompanyID:       room.CompanyID,
+			RoomID:          room.RoomID,
+			Name:            room.Name,
+			Description:     room.Description,
+			MaxParticipants: room.MaxParticipants,
+			CreatedAt:       room.CreatedAt,
+			UpdatedAt:       room.UpdatedAt,
 		})
-		return
 	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(RoomResponse{
-		ID:              room.ID,
-		CompanyID:       room.CompanyID,
-		RoomID:          room.RoomID,
-		Name:            room.Name,
-		Description:     room.Description,
-		MaxParticipants: room.MaxParticipants,
-		CreatedAt:       room.CreatedAt,
-		UpdatedAt:       room.UpdatedAt,
-	})
 }
 
 // GetRoomHandler gets a specific room
@@ -194,136 +199,153 @@ func GetRoomHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // UpdateRoomHandler updates a room
-func UpdateRoomHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func UpdateRoomHandler(auditor *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
-	companyID := r.Context().Value(CompanyIDKey)
-	if companyID == nil {
-		respondJSON(w, http.StatusUnauthorized, map[string]string{
-			"error": "company id not found",
-		})
-		return
-	}
+		companyID := r.Context().Value(CompanyIDKey)
+		if companyID == nil {
+			respondJSON(w, http.StatusUnauthorized, map[string]string{
+				"error": "company id not found",
+			})
+			return
+		}
 
-	// Extract room ID from path
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 5 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "invalid path",
-		})
-		return
-	}
-	roomID := parts[4]
+		// Extract room ID from path
+		parts := strings.Split(r.URL.Path, "/")
+		if len(parts) < 5 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "invalid path",
+			})
+			return
+		}
+		roomID := parts[4]
 
-	var req RoomRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "invalid request body: " + err.Error(),
-		})
-		return
-	}
+		var req RoomRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "invalid request body: " + err.Error(),
+			})
+			return
+		}
 
-	// Get room
-	var room database.Room
-	result := database.DB.Where("id = ? AND company_id = ?", roomID, companyID.(string)).First(&room)
-	if result.Error != nil {
-		if result.Error.Error() == "record not found" {
-			respondJSON(w, http.StatusNotFound, map[string]string{
-				"error": "room not found",
+		// Get room
+		var room database.Room
+		result := database.DB.Where("id = ? AND company_id = ?", roomID, companyID.(string)).First(&room)
+		if result.Error != nil {
+			if result.Error.Error() == "record not found" {
+				respondJSON(w, http.StatusNotFound, map[string]string{
+					"error": "room not found",
+				})
+				return
+			}
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "database error: " + result.Error.Error(),
 			})
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": "database error: " + result.Error.Error(),
-		})
-		return
-	}
 
-	// Update fields
-	if req.Name != "" {
-		room.Name = req.Name
-	}
-	if req.Description != "" {
-		room.Description = req.Description
-	}
-	if req.MaxParticipants > 0 {
-		room.MaxParticipants = req.MaxParticipants
-	}
+		// Update fields
+		if req.Name != "" {
+			room.Name = req.Name
+		}
+		if req.Description != "" {
+			room.Description = req.Description
+		}
+		if req.MaxParticipants > 0 {
+			room.MaxParticipants = req.MaxParticipants
+		}
 
-	// Save
-	result = database.DB.Save(&room)
-	if result.Error != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": "failed to update room: " + result.Error.Error(),
+		// Save
+		result = database.DB.Save(&room)
+		if result.Error != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "failed to update room: " + result.Error.Error(),
+			})
+			return
+		}
+
+		recordAudit(auditor, r, audit.EventRoomUpdated, "room", room.ID, roomAuditDetails(&room))
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(RoomResponse{
+			ID:              room.ID,
+			CompanyID:       room.CompanyID,
+			RoomID:          room.RoomID,
+			Name:            room.Name,
+			Description:     room.Description,
+			MaxParticipants: room.MaxParticipants,
+			CreatedAt:       room.CreatedAt,
+			UpdatedAt:       room.UpdatedAt,
 		})
-		return
 	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	json.NewEncoder(w).Encode(RoomResponse{
-		ID:              room.ID,
-		CompanyID:       room.CompanyID,
-		RoomID:          room.RoomID,
-		Name:            room.Name,
-		Description:     room.Description,
-		MaxParticipants: room.MaxParticipants,
-		CreatedAt:       room.CreatedAt,
-		UpdatedAt:       room.UpdatedAt,
-	})
 }
 
 // DeleteRoomHandler deletes a room
-func DeleteRoomHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func DeleteRoomHandler(auditor *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
-	companyID := r.Context().Value(CompanyIDKey)
-	if companyID == nil {
-		respondJSON(w, http.StatusUnauthorized, map[string]string{
-			"error": "company id not found",
-		})
-		return
-	}
+		companyID := r.Context().Value(CompanyIDKey)
+		if companyID == nil {
+			respondJSON(w, http.StatusUnauthorized, map[string]string{
+				"error": "company id not found",
+			})
+			return
+		}
 
-	// Extract room ID from path
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 5 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "invalid path",
-		})
-		return
-	}
-	roomID := parts[4]
+		// Extract room ID from path
+		parts := strings.Split(r.URL.Path, "/")
+		if len(parts) < 5 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "invalid path",
+			})
+			return
+		}
+		roomID := parts[4]
+
+		// Check room exists and belongs to company
+		var room database.Room
+		result := database.DB.Where("id = ? AND company_id = ?", roomID, companyID.(string)).First(&room)
+		if result.Error != nil {
+			if result.Error.Error() == "record not found" {
+				respondJSON(w, http.StatusNotFound, map[string]string{
+					"error": "room not found",
+				})
+				return
+			}
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "database error: " + result.Error.Error(),
+			})
+			return
+		}
 
-	// Check room exists and belongs to company
-	var room database.Room
-	result := database.DB.Where("id = ? AND company_id = ?", roomID, companyID.(string)).First(&room)
-	if result.Error != nil {
-		if result.Error.Error() == "record not found" {
-			respondJSON(w, http.StatusNotFound, map[string]string{
-				"error": "room not found",
+		// Delete
+		result = database.DB.Delete(&room)
+		if result.Error != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "failed to delete room: " + result.Error.Error(),
 			})
 			return
 		}
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": "database error: " + result.Error.Error(),
-		})
-		return
-	}
 
-	// Delete
-	result = database.DB.Delete(&room)
-	if result.Error != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": "failed to delete room: " + result.Error.Error(),
-		})
-		return
+		recordAudit(auditor, r, audit.EventRoomDeleted, "room", room.ID, roomAuditDetails(&room))
+
+		w.WriteHeader(http.StatusNoContent)
 	}
+}
 
-	w.WriteHeader(http.StatusNoContent)
+// roomAuditDetails describes a room in the audit log
+func roomAuditDetails(room *database.Room) map[string]any {
+	return map[string]any{
+		"room_id":          room.RoomID,
+		"name":             room.Name,
+		"max_participants": room.MaxParticipants,
+	}
 }
@@ -5,11 +5,19 @@ import (
 	"net/http"
 	"strings"
 
+	"aq-server/internal/audit"
+	"aq-server/internal/auth"
 	"aq-server/internal/database"
+	"aq-server/internal/ratelimit"
 )
 
-// SetupRoutes configures all API routes
-func SetupRoutes(mux *http.ServeMux) error {
+// testAPIKey is the API key of the test company
+const testAPIKey = "pk_test_company"
+
+// SetupRoutes configures all API routes. Revoking tokens disconnects participants,
+// webhooks send test events, the limiter limits requests, if there is one, and the
+// auditor records changes and refused credentials, if there is one.
+func SetupRoutes(mux *http.ServeMux, participants Participants, webhooks Webhooks, limiter *ratelimit.Limiter, auditor *audit.Recorder) error {
 	// Get test company for API key validation
 	testCompany, err := database.GetCompanyByID("test-company")
 	if err != nil {
@@ -20,7 +28,6 @@ func SetupRoutes(mux *http.ServeMux) error {
 		testCompany = &database.Company{
 			ID:        "test-company",
 			Name:      "Test Company",
-			APIKey:    "pk_test_company",
 			SecretKey: "sk_test_company_secret",
 			Tier:      "free",
 			IsActive:  true,
@@ -30,15 +37,55 @@ func SetupRoutes(mux *http.ServeMux) error {
 		}
 	}
 
-	// Wrap handlers with middleware
-	mux.HandleFunc("/api/v1/tokens", withAPIKeyAuth(GenerateTokenHandler))
+	// The demo page mints tokens with the test company's API key
+	testKey, err := database.GetAPIKey(testAPIKey)
+	if err != nil {
+		return err
+	}
+	if testKey == nil {
+		if err := database.CreateAPIKey(database.NewAPIKey(testCompany.ID, "Test API key", testAPIKey)); err != nil {
+			return err
+		}
+	}
+
+	// Room tokens with the admin permission manage the rooms of the company that
+	// issued them
+	validator := auth.NewValidator()
+
+	// Wrap handlers with middleware. Minting tokens and managing rooms have their own
+	// rate limit budgets.
+	tokens := func(next http.HandlerFunc) http.HandlerFunc {
+		return withAPIKeyAuth(auditor, withRateLimit(limiter, ratelimit.BudgetTokens, next))
+	}
+	management := func(next http.HandlerFunc) http.HandlerFunc {
+		return withAPIKeyAuth(auditor, withRateLimit(limiter, ratelimit.BudgetAPI, next))
+	}
+	rooms := func(next http.HandlerFunc) http.HandlerFunc {
+		return withAuth(validator, auditor, withRateLimit(limiter, ratelimit.BudgetRooms, next))
+	}
+
+	mux.HandleFunc("/api/v1/tokens", tokens(GenerateTokenHandler(auditor)))
+	mux.HandleFunc("/api/v1/tokens/revoke", tokens(RevokeTokensHandler(participants, auditor)))
+	mux.HandleFunc("/api/v1/keys", management(SigningKeysHandler(auditor)))
+	mux.HandleFunc("/api/v1/keys/", management(RetireSigningKeyHandler(auditor)))
+	mux.HandleFunc("/api/v1/api-keys", management(APIKeysHandler(auditor)))
+	mux.HandleFunc("/api/v1/api-keys/", management(RevokeAPIKeyHandler(auditor)))
+	mux.HandleFunc("/api/v1/quota", management(QuotaHandler(participants)))
+	mux.HandleFunc("/api/v1/sessions", management(SessionsHandler))
+	mux.HandleFunc("/api/v1/usage", management(UsageHandler))
+	mux.HandleFunc("/api/v1/audit-logs", management(AuditLogsHandler))
+	mux.HandleFunc("/api/v1/webhooks", management(WebhooksHandler(auditor)))
+	mux.HandleFunc("/api/v1/webhooks/", management(WebhookHandler(webhooks, auditor)))
+	mux.HandleFunc("/.well-known/jwks.json", JWKSHandler)
+
+	createRoom, updateRoom, deleteRoom := CreateRoomHandler(auditor), UpdateRoomHandler(auditor), DeleteRoomHandler(auditor)
 
 	mux.HandleFunc("/api/v1/rooms", func(w http.ResponseWriter, r *http.Request) {
-		withAuth(testCompany.SecretKey, func(w http.ResponseWriter, r *http.Request) {
+		rooms(func(w http.ResponseWriter, r *http.Request) {
 			if r.Method == http.MethodGet {
 				ListRoomsHandler(w, r)
 			} else if r.Method == http.MethodPost {
-				CreateRoomHandler(w, r)
+				createRoom(w, r)
 			} else {
 				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			}
@@ -46,13 +93,13 @@ func SetupRoutes(mux *http.ServeMux) error {
 	})
 
 	mux.HandleFunc("/api/v1/rooms/", func(w http.ResponseWriter, r *http.Request) {
-		withAuth(testCompany.SecretKey, func(w http.ResponseWriter, r *http.Request) {
+		rooms(func(w http.ResponseWriter, r *http.Request) {
 			if r.Method == http.MethodGet {
 				GetRoomHandler(w, r)
 			} else if r.Method == http.MethodPut {
-				UpdateRoomHandler(w, r)
+				updateRoom(w, r)
 			} else if r.Method == http.MethodDelete {
-				DeleteRoomHandler(w, r)
+				deleteRoom(w, r)
 			} else {
 				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			}
@@ -63,7 +110,7 @@ func SetupRoutes(mux *http.ServeMux) error {
 }
 
 // withAPIKeyAuth is a middleware that validates API key
-func withAPIKeyAuth(next http.HandlerFunc) http.HandlerFunc {
+func withAPIKeyAuth(auditor *audit.Recorder, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -81,16 +128,20 @@ func withAPIKeyAuth(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		apiKey := authHeader[len(bearerSchema):]
+		key, ok := authenticateAPIKey(w, r, auditor, authHeader[len(bearerSchema):])
+		if !ok {
+			return
+		}
 
 		// Store API key in context
-		ctx := context.WithValue(r.Context(), APIKeyKey, apiKey)
+		ctx := context.WithValue(r.Context(), APIKeyKey, key)
+		ctx = context.WithValue(ctx, CompanyIDKey, key.CompanyID)
 		next(w, r.WithContext(ctx))
 	}
 }
 
-// withAuth is a middleware that validates JWT token
-func withAuth(secretKey string, next http.HandlerFunc) http.HandlerFunc {
+// withAuth is a middleware that validates room tokens with the admin permission
+func withAuth(validator *auth.Validator, auditor *audit.Recorder, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -110,14 +161,23 @@ func withAuth(secretKey string, next http.HandlerFunc) http.HandlerFunc {
 
 		token := authHeader[len(bearerSchema):]
 
-		claims, err := ValidateToken(token, secretKey)
+		claims, err := validator.Validate(token)
 		if err != nil {
+			auditor.AuthFailure(r, "", audit.ActorToken, err.Error())
 			respondJSON(w, http.StatusUnauthorized, map[string]string{
 				"error": "invalid or expired token: " + err.Error(),
 			})
 			return
 		}
 
+		if !claims.Permissions.Admin {
+			auditor.AuthFailure(r, claims.CompanyID, audit.ActorToken, "missing admin permission")
+			respondJSON(w, http.StatusForbidden, map[string]string{
+				"error": "token does not have the admin permission",
+			})
+			return
+		}
+
 		// Store claims in context
 		ctx := context.WithValue(r.Context(), ClaimsKey, claims)
 		ctx = context.WithValue(ctx, CompanyIDKey, claims.CompanyID)
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"aq-server/internal/database"
+)
+
+// Sessions are listed in pages of defaultSessionLimit, or up to maxSessionLimit
+const (
+	defaultSessionLimit = 100
+	maxSessionLimit     = 1000
+)
+
+// SessionResponse represents a participant's stay in a room in responses. The
+// duration of an open session is how long it has lasted so far.
+type SessionResponse struct {
+	ID              string     `json:"id"`
+	RoomID          string     `json:"room_id"`
+	UserName        string     `json:"user_name"`
+	TokenID         *string    `json:"token_id,omitempty"`
+	ConnectedAt     time.Time  `json:"connected_at"`
+	DisconnectedAt  *time.Time `json:"disconnected_at"`
+	DurationSeconds int        `json:"duration_seconds"`
+	PeerAddress     string     `json:"peer_address"`
+	UserAgent       string     `json:"user_agent"`
+}
+
+func newSessionResponse(session *database.Session) SessionResponse {
+	duration := session.DurationSeconds
+	if session.DisconnectedAt == nil {
+		duration = int(time.Since(session.ConnectedAt).Seconds())
+	}
+
+	return SessionResponse{
+		ID:              session.ID,
+		RoomID:          session.RoomID,
+		UserName:        session.UserName,
+		TokenID:         session.TokenID,
+		ConnectedAt:     session.ConnectedAt,
+		DisconnectedAt:  session.DisconnectedAt,
+		DurationSeconds: duration,
+		PeerAddress:     session.PeerAddress,
+		UserAgent:       session.UserAgent,
+	}
+}
+
+// SessionsHandler lists a company's sessions, most recent first. The query selects
+// them by room_id, user_name, a time range they were open in (from and to, RFC 3339)
+// and active=true for open sessions only, and pages with limit and offset.
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	company, ok := apiKeyCompany(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := parseSessionFilter(r.URL.Query())
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	filter.CompanyID = company.ID
+
+	sessions, err := database.ListSessions(filter)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "database error: " + err.Error(),
+		})
+		return
+	}
+
+	responses := make([]SessionResponse, len(sessions))
+	for i := range sessions {
+		responses[i] = newSessionResponse(&sessions[i])
+	}
+
+	respondJSON(w, http.StatusOK, responses)
+}
+
+// parseSessionFilter reads the filter of a sessions query
+func parseSessionFilter(query url.Values) (database.SessionFilter, error) {
+	filter := database.SessionFilter{
+		RoomID:   query.Get("room_id"),
+		UserName: query.Get("user_name"),
+		Active:   query.Get("active") == "true",
+	}
+
+	var err error
+	if filter.From, filter.To, err = parseTimeRange(query); err != nil {
+		return filter, err
+	}
+	if filter.Limit, filter.Offset, err = parsePage(query, defaultSessionLimit, maxSessionLimit); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
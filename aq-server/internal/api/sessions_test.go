@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseSessionFilter(t *testing.T) {
+	filter, err := parseSessionFilter(url.Values{
+		"room_id":   {"lobby"},
+		"user_name": {"alice"},
+		"from":      {"2026-10-01T00:00:00Z"},
+		"to":        {"2026-10-02T00:00:00Z"},
+		"active":    {"true"},
+		"limit":     {"10"},
+		"offset":    {"20"},
+	})
+	if err != nil {
+		t.Fatalf("Expected the filter to parse, got %v", err)
+	}
+
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	if filter.RoomID != "lobby" || filter.UserName != "alice" || !filter.Active {
+		t.Errorf("Expected active sessions of alice in lobby, got %+v", filter)
+	}
+	if filter.From == nil || !filter.From.Equal(from) || filter.To == nil || !filter.To.Equal(from.Add(24*time.Hour)) {
+		t.Errorf("Expected the range of October 1st, got %v to %v", filter.From, filter.To)
+	}
+	if filter.Limit != 10 || filter.Offset != 20 {
+		t.Errorf("Expected limit 10 and offset 20, got %d and %d", filter.Limit, filter.Offset)
+	}
+
+	if filter, _ := parseSessionFilter(url.Values{}); filter.Limit != defaultSessionLimit || filter.From != nil {
+		t.Errorf("Expected the default limit without a range, got %+v", filter)
+	}
+
+	invalid := []url.Values{
+		{"from": {"yesterday"}},
+		{"from": {"2026-10-02T00:00:00Z"}, "to": {"2026-10-01T00:00:00Z"}},
+		{"limit": {"0"}},
+		{"limit": {"5000"}},
+		{"offset": {"-1"}},
+	}
+	for _, query := range invalid {
+		if _, err := parseSessionFilter(query); err == nil {
+			t.Errorf("Expected %v to be rejected", query)
+		}
+	}
+}
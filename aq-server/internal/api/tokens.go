@@ -5,7 +5,12 @@ import (
 	"net/http"
 	"time"
 
+	"aq-server/internal/audit"
+	"aq-server/internal/auth"
 	"aq-server/internal/database"
+	"aq-server/internal/types"
+
+	"github.com/google/uuid"
 )
 
 // TokenRequest represents a token generation request
@@ -13,111 +18,178 @@ type TokenRequest struct {
 	RoomID   string `json:"room_id" validate:"required"`
 	UserName string `json:"user_name" validate:"required"`
 	Duration int    `json:"duration" validate:"required,min=60,max=86400"` // 1 min to 24 hours
+	UserType string `json:"user_type"`
+
+	AutoSubscribe *bool               `json:"auto_subscribe"`
+	Permissions   *PermissionsRequest `json:"permissions"`
+	SingleUse     bool                `json:"single_use"` // The token can join only once
+}
+
+// PermissionsRequest sets what a token allows. Publish, subscribe and chat default
+// to true; admin defaults to true for hosts only.
+type PermissionsRequest struct {
+	Publish   *bool `json:"publish"`
+	Subscribe *bool `json:"subscribe"`
+	Chat      *bool `json:"chat"`
+	Admin     *bool `json:"admin"`
+}
+
+// permissions resolves the permissions of a token for a user type
+func (p *PermissionsRequest) permissions(userType string) types.Permissions {
+	permissions := types.DefaultPermissions()
+	permissions.Admin = userType == "host"
+	if p == nil {
+		return permissions
+	}
+
+	if p.Publish != nil {
+		permissions.Publish = *p.Publish
+	}
+	if p.Subscribe != nil {
+		permissions.Subscribe = *p.Subscribe
+	}
+	if p.Chat != nil {
+		permissions.Chat = *p.Chat
+	}
+	if p.Admin != nil {
+		permissions.Admin = *p.Admin
+	}
+
+	return permissions
 }
 
 // TokenResponse represents a token generation response
 type TokenResponse struct {
+	ID        string    `json:"id"`
 	Token     string    `json:"token"`
 	ExpiresAt time.Time `json:"expires_at"`
 	RoomID    string    `json:"room_id"`
 	UserName  string    `json:"user_name"`
+	SingleUse bool      `json:"single_use"`
+
+	Permissions types.Permissions `json:"permissions"`
 }
 
 // GenerateTokenHandler generates a JWT token for room access
-func GenerateTokenHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func GenerateTokenHandler(auditor *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
-	var req TokenRequest
+		var req TokenRequest
 
-	// Parse request body
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "invalid request body: " + err.Error(),
-		})
-		return
-	}
+		// Parse request body
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "invalid request body: " + err.Error(),
+			})
+			return
+		}
 
-	// Validate request
-	if req.RoomID == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "room_id is required",
-		})
-		return
-	}
-	if req.UserName == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "user_name is required",
-		})
-		return
-	}
-	if req.Duration < 60 || req.Duration > 86400 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "duration must be between 60 and 86400 seconds",
-		})
-		return
-	}
+		// Validate request
+		if req.RoomID == "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "room_id is required",
+			})
+			return
+		}
+		if req.UserName == "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "user_name is required",
+			})
+			return
+		}
+		if req.Duration < 60 || req.Duration > 86400 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "duration must be between 60 and 86400 seconds",
+			})
+			return
+		}
 
-	// Get API key from context (set by middleware)
-	apiKey := r.Context().Value(APIKeyKey)
-	if apiKey == nil {
-		respondJSON(w, http.StatusUnauthorized, map[string]string{
-			"error": "api key not found",
-		})
-		return
-	}
+		company, ok := apiKeyCompany(w, r)
+		if !ok {
+			return
+		}
 
-	// Get company by API key
-	company, err := database.GetCompanyByAPIKey(apiKey.(string))
-	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": "database error: " + err.Error(),
-		})
-		return
-	}
-	if company == nil {
-		respondJSON(w, http.StatusUnauthorized, map[string]string{
-			"error": "invalid api key",
-		})
-		return
-	}
+		// Generate JWT token
+		permissions := req.Permissions.permissions(req.UserType)
+		token, expiresAt, err := auth.GenerateToken(auth.Claims{
+			CompanyID:     company.ID,
+			RoomID:        req.RoomID,
+			UserName:      req.UserName,
+			UserType:      req.UserType,
+			AutoSubscribe: req.AutoSubscribe,
+			Permissions:   permissions,
+		}, company.SecretKey, req.Duration)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "failed to generate token: " + err.Error(),
+			})
+			return
+		}
+
+		// Hash token for storage
+		tokenHash := auth.HashToken(token)
+
+		// Store token in database
+		dbToken := &database.Token{
+			ID:        uuid.NewString(),
+			CompanyID: company.ID,
+			TokenHash: tokenHash,
+			RoomID:    req.RoomID,
+			UserName:  req.UserName,
+			ExpiresAt: expiresAt,
+			SingleUse: req.SingleUse,
+		}
+		if dbToken.Permissions, err = json.Marshal(permissions); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "failed to encode permissions: " + err.Error(),
+			})
+			return
+		}
 
-	// Generate JWT token
-	token, expiresAt, err := GenerateToken(company.ID, req.RoomID, req.UserName, company.SecretKey, req.Duration)
-	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": "failed to generate token: " + err.Error(),
+		if err := database.CreateToken(dbToken); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "failed to store token: " + err.Error(),
+			})
+			return
+		}
+
+		recordAudit(auditor, r, audit.EventTokenMinted, "token", dbToken.ID, map[string]any{
+			"room_id":    req.RoomID,
+			"user_name":  req.UserName,
+			"single_use": req.SingleUse,
+			"expires_at": expiresAt,
 		})
-		return
-	}
 
-	// Hash token for storage
-	tokenHash := HashToken(token)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(TokenResponse{
+			ID:        dbToken.ID,
+			Token:     token,
+			ExpiresAt: expiresAt,
+			RoomID:    req.RoomID,
+			UserName:  req.UserName,
+			SingleUse: req.SingleUse,
 
-	// Store token in database
-	dbToken := &database.Token{
-		CompanyID: company.ID,
-		TokenHash: tokenHash,
-		RoomID:    req.RoomID,
-		UserName:  req.UserName,
-		ExpiresAt: expiresAt,
+			Permissions: permissions,
+		})
 	}
+}
 
-	if err := database.CreateToken(dbToken); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": "failed to store token: " + err.Error(),
+// apiKeyCompany returns the company of the API key in the request context, or
+// responds with an error
+func apiKeyCompany(w http.ResponseWriter, r *http.Request) (*database.Company, bool) {
+	// Get API key from context (set by middleware)
+	key, ok := r.Context().Value(APIKeyKey).(*database.APIKey)
+	if !ok || key.Company == nil {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{
+			"error": "api key not found",
 		})
-		return
+		return nil, false
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(TokenResponse{
-		Token:     token,
-		ExpiresAt: expiresAt,
-		RoomID:    req.RoomID,
-		UserName:  req.UserName,
-	})
+	return key.Company, true
 }
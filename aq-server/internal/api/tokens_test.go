@@ -0,0 +1,33 @@
+package api
+
+import (
+	"testing"
+
+	"aq-server/internal/types"
+)
+
+func TestPermissionsRequest(t *testing.T) {
+	no := false
+	yes := true
+
+	tests := []struct {
+		name     string
+		request  *PermissionsRequest
+		userType string
+		expected types.Permissions
+	}{
+		{"guest defaults", nil, "guest", types.Permissions{Publish: true, Subscribe: true, Chat: true}},
+		{"host defaults to admin", nil, "host", types.Permissions{Publish: true, Subscribe: true, Chat: true, Admin: true}},
+		{"viewer", &PermissionsRequest{Publish: &no, Chat: &no}, "guest", types.Permissions{Subscribe: true}},
+		{"host without admin", &PermissionsRequest{Admin: &no}, "host", types.Permissions{Publish: true, Subscribe: true, Chat: true}},
+		{"guest moderator", &PermissionsRequest{Admin: &yes}, "guest", types.Permissions{Publish: true, Subscribe: true, Chat: true, Admin: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if permissions := tt.request.permissions(tt.userType); permissions != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, permissions)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"aq-server/internal/database"
+)
+
+// dateLayout is the format of days in usage queries and reports
+const dateLayout = "2006-01-02"
+
+// maxUsageDays is the longest range usage can be reported for at once
+const maxUsageDays = 366
+
+// UsageTotals is metered usage in billable units
+type UsageTotals struct {
+	ParticipantMinutes    float64 `json:"participant_minutes"`
+	PublishedTrackMinutes float64 `json:"published_track_minutes"`
+	ForwardedBytes        int64   `json:"forwarded_bytes"`
+}
+
+// UsageDay is what a room used on a day
+type UsageDay struct {
+	Date   string `json:"date"`
+	RoomID string `json:"room_id"`
+	UsageTotals
+}
+
+// UsageResponse reports a company's usage per room and day over a range of days
+type UsageResponse struct {
+	From   string      `json:"from"`
+	To     string      `json:"to"`
+	Totals UsageTotals `json:"totals"`
+	Days   []UsageDay  `json:"days"`
+}
+
+// UsageHandler reports a company's metered usage per room and day. The query selects
+// the days with from and to (YYYY-MM-DD, UTC), by default the current month so far,
+// and a room with room_id. format=csv returns the days as CSV instead of JSON. Usage
+// is written periodically, so the current day lags behind by up to a minute.
+func UsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	company, ok := apiKeyCompany(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	from, to, err := parseUsageRange(query, time.Now())
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	metrics, err := database.ListUsage(company.ID, query.Get("room_id"), from, to)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "database error: " + err.Error(),
+		})
+		return
+	}
+
+	response := newUsageResponse(metrics)
+	response.From = from.Format(dateLayout)
+	response.To = to.Format(dateLayout)
+
+	if query.Get("format") == "csv" {
+		writeUsageCSV(w, response)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// parseUsageRange reads the days of a usage query, defaulting to the month of now
+func parseUsageRange(query url.Values, now time.Time) (time.Time, time.Time, error) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	from := today.AddDate(0, 0, 1-today.Day())
+	to := today
+
+	for name, day := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(dateLayout, value)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid %s, expected YYYY-MM-DD", name)
+		}
+		*day = parsed
+	}
+
+	if to.Before(from) {
+		return from, to, fmt.Errorf("to must not be before from")
+	}
+	if to.Sub(from) >= maxUsageDays*24*time.Hour {
+		return from, to, fmt.Errorf("at most %d days can be reported at once", maxUsageDays)
+	}
+
+	return from, to, nil
+}
+
+// newUsageResponse sums the metrics of each room and day in billable units
+func newUsageResponse(metrics []database.Analytics) UsageResponse {
+	type dayKey struct{ date, roomID string }
+
+	response := UsageResponse{Days: []UsageDay{}}
+	days := make(map[dayKey]int)
+	var participantSeconds, trackSeconds int64
+
+	for _, metric := range metrics {
+		key := dayKey{metric.MetricDate.Format(dateLayout), metric.RoomID}
+		i, ok := days[key]
+		if !ok {
+			i = len(response.Days)
+			days[key] = i
+			response.Days = append(response.Days, UsageDay{Date: key.date, RoomID: key.roomID})
+		}
+		day := &response.Days[i]
+
+		switch metric.MetricType {
+		case database.MetricParticipantSeconds:
+			day.ParticipantMinutes = minutes(metric.Value)
+			participantSeconds += metric.Value
+		case database.MetricTrackSeconds:
+			day.PublishedTrackMinutes = minutes(metric.Value)
+			trackSeconds += metric.Value
+		case database.MetricForwardedBytes:
+			day.ForwardedBytes = metric.Value
+			response.Totals.ForwardedBytes += metric.Value
+		}
+	}
+
+	response.Totals.ParticipantMinutes = minutes(participantSeconds)
+	response.Totals.PublishedTrackMinutes = minutes(trackSeconds)
+
+	return response
+}
+
+// minutes converts seconds to minutes, rounded to hundredths
+func minutes(seconds int64) float64 {
+	return math.Round(float64(seconds)/60*100) / 100
+}
+
+// writeUsageCSV writes the days of a usage report as a CSV download
+func writeUsageCSV(w http.ResponseWriter, response UsageResponse) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s-%s.csv"`, response.From, response.To))
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"date", "room_id", "participant_minutes", "published_track_minutes", "forwarded_bytes"})
+	for _, day := range response.Days {
+		_ = writer.Write([]string{
+			day.Date,
+			day.RoomID,
+			strconv.FormatFloat(day.ParticipantMinutes, 'f', 2, 64),
+			strconv.FormatFloat(day.PublishedTrackMinutes, 'f', 2, 64),
+			strconv.FormatInt(day.ForwardedBytes, 10),
+		})
+	}
+	writer.Flush()
+}
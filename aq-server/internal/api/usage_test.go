@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"aq-server/internal/database"
+)
+
+func TestParseUsageRange(t *testing.T) {
+	now := time.Date(2026, 10, 16, 15, 30, 0, 0, time.UTC)
+
+	from, to, err := parseUsageRange(url.Values{}, now)
+	if err != nil {
+		t.Fatalf("Expected the default range, got %v", err)
+	}
+	if from.Format(dateLayout) != "2026-10-01" || to.Format(dateLayout) != "2026-10-16" {
+		t.Errorf("Expected the month so far, got %s to %s", from.Format(dateLayout), to.Format(dateLayout))
+	}
+
+	from, to, err = parseUsageRange(url.Values{"from": {"2026-09-01"}, "to": {"2026-09-30"}}, now)
+	if err != nil || from.Format(dateLayout) != "2026-09-01" || to.Format(dateLayout) != "2026-09-30" {
+		t.Errorf("Expected September, got %s to %s (%v)", from.Format(dateLayout), to.Format(dateLayout), err)
+	}
+
+	invalid := []url.Values{
+		{"from": {"September"}},
+		{"from": {"2026-10-02"}, "to": {"2026-10-01"}},
+		{"from": {"2024-01-01"}, "to": {"2026-01-01"}},
+	}
+	for _, query := range invalid {
+		if _, _, err := parseUsageRange(query, now); err == nil {
+			t.Errorf("Expected %v to be rejected", query)
+		}
+	}
+}
+
+func TestUsageReport(t *testing.T) {
+	october1 := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	october2 := october1.AddDate(0, 0, 1)
+	metrics := []database.Analytics{
+		{MetricDate: october1, RoomID: "lobby", MetricType: database.MetricParticipantSeconds, Value: 90},
+		{MetricDate: october1, RoomID: "lobby", MetricType: database.MetricTrackSeconds, Value: 60},
+		{MetricDate: october1, RoomID: "lobby", MetricType: database.MetricForwardedBytes, Value: 1000},
+		{MetricDate: october2, RoomID: "lobby", MetricType: database.MetricParticipantSeconds, Value: 20},
+		{MetricDate: october2, RoomID: "standup", MetricType: database.MetricParticipantSeconds, Value: 600},
+	}
+
+	response := newUsageResponse(metrics)
+	if len(response.Days) != 3 {
+		t.Fatalf("Expected 3 room days, got %+v", response.Days)
+	}
+
+	first := response.Days[0]
+	if first.Date != "2026-10-01" || first.ParticipantMinutes != 1.5 || first.PublishedTrackMinutes != 1 || first.ForwardedBytes != 1000 {
+		t.Errorf("Expected the lobby's usage on October 1st, got %+v", first)
+	}
+	if response.Totals.ParticipantMinutes != 11.83 || response.Totals.ForwardedBytes != 1000 {
+		t.Errorf("Expected 11.83 participant minutes and 1000 bytes in total, got %+v", response.Totals)
+	}
+
+	response.From, response.To = "2026-10-01", "2026-10-02"
+	w := httptest.NewRecorder()
+	writeUsageCSV(w, response)
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected a header and 3 rows, got %q", w.Body.String())
+	}
+	if lines[1] != "2026-10-01,lobby,1.50,1.00,1000" {
+		t.Errorf("Expected the lobby's first day, got %q", lines[1])
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/csv") {
+		t.Errorf("Expected CSV, got %s", contentType)
+	}
+}
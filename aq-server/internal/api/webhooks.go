@@ -0,0 +1,391 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"aq-server/internal/audit"
+	"aq-server/internal/database"
+	"aq-server/internal/webhook"
+
+	"github.com/google/uuid"
+)
+
+// Deliveries are listed in pages of defaultDeliveryLimit, or up to maxDeliveryLimit
+const (
+	defaultDeliveryLimit = 100
+	maxDeliveryLimit     = 1000
+)
+
+// Webhooks sends test deliveries to webhooks
+type Webhooks interface {
+	Test(hook *database.Webhook) (*database.WebhookDelivery, error)
+}
+
+// WebhookRequest creates or changes a webhook. Events selects the event types it's
+// notified of, all of them if empty. Fields left out aren't changed by an update.
+type WebhookRequest struct {
+	URL    string    `json:"url"`
+	Events *[]string `json:"events"`
+	Active *bool     `json:"active"`
+}
+
+// WebhookResponse represents a webhook in responses. The secret is only set when the
+// webhook is created.
+type WebhookResponse struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newWebhookResponse(hook *database.Webhook) WebhookResponse {
+	events := hook.EventTypes()
+	if events == nil {
+		events = []string{}
+	}
+
+	return WebhookResponse{
+		ID:        hook.ID,
+		URL:       hook.URL,
+		Events:    events,
+		Active:    hook.IsActive,
+		CreatedAt: hook.CreatedAt,
+		UpdatedAt: hook.UpdatedAt,
+	}
+}
+
+// DeliveryResponse represents an attempted or pending delivery of an event
+type DeliveryResponse struct {
+	ID             string          `json:"id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	DurationMs     int64           `json:"duration_ms"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+func newDeliveryResponse(delivery *database.WebhookDelivery) DeliveryResponse {
+	response := DeliveryResponse{
+		ID:             delivery.ID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		ResponseStatus: delivery.ResponseStatus,
+		LastError:      delivery.LastError,
+		DurationMs:     delivery.DurationMs,
+		CreatedAt:      delivery.CreatedAt,
+		DeliveredAt:    delivery.DeliveredAt,
+		Payload:        json.RawMessage(delivery.Payload),
+	}
+	if delivery.Status == database.DeliveryPending {
+		response.NextAttemptAt = &delivery.NextAttemptAt
+	}
+
+	return response
+}
+
+// WebhooksHandler lists a company's webhooks and creates new ones
+func WebhooksHandler(auditor *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listWebhooks(w, r)
+		case http.MethodPost:
+			createWebhook(w, r, auditor)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request) {
+	company, ok := apiKeyCompany(w, r)
+	if !ok {
+		return
+	}
+
+	hooks, err := database.ListWebhooks(company.ID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "database error: " + err.Error(),
+		})
+		return
+	}
+
+	responses := make([]WebhookResponse, len(hooks))
+	for i := range hooks {
+		responses[i] = newWebhookResponse(&hooks[i])
+	}
+
+	respondJSON(w, http.StatusOK, responses)
+}
+
+func createWebhook(w http.ResponseWriter, r *http.Request, auditor *audit.Recorder) {
+	var req WebhookRequest
+
+	// Parse request body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "invalid request body: " + err.Error(),
+		})
+		return
+	}
+
+	// Validate request
+	if req.URL == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "url is required",
+		})
+		return
+	}
+
+	company, ok := apiKeyCompany(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	hook := &database.Webhook{
+		ID:        uuid.NewString(),
+		CompanyID: company.ID,
+		Secret:    secret,
+		IsActive:  true,
+	}
+	if err := applyWebhookRequest(hook, req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := database.CreateWebhook(hook); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "failed to store webhook: " + err.Error(),
+		})
+		return
+	}
+
+	recordAudit(auditor, r, audit.EventWebhookCreated, "webhook", hook.ID, webhookAuditDetails(hook))
+
+	response := newWebhookResponse(hook)
+	response.Secret = secret
+
+	respondJSON(w, http.StatusCreated, response)
+}
+
+// applyWebhookRequest validates a webhook request and applies it to a webhook
+func applyWebhookRequest(hook *database.Webhook, req WebhookRequest) error {
+	if req.URL != "" {
+		parsed, err := url.Parse(req.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("url must be an absolute http or https URL")
+		}
+		if len(req.URL) > 2048 {
+			return fmt.Errorf("url must be at most 2048 characters")
+		}
+		// Hosts that resolve to private addresses are refused when delivering
+		host := parsed.Hostname()
+		if addr, err := netip.ParseAddr(host); (err == nil && !webhook.IsPublic(addr)) || strings.EqualFold(host, "localhost") {
+			return fmt.Errorf("url must point to a public address")
+		}
+		hook.URL = req.URL
+	}
+
+	if req.Events != nil {
+		for _, event := range *req.Events {
+			if !slices.Contains(webhook.EventTypes, event) {
+				return fmt.Errorf("unknown event type %q, expected one of %s", event, strings.Join(webhook.EventTypes, ", "))
+			}
+		}
+		hook.Events = strings.Join(*req.Events, ",")
+	}
+
+	if req.Active != nil {
+		hook.IsActive = *req.Active
+	}
+
+	return nil
+}
+
+// WebhookHandler manages a company's webhook under /api/v1/webhooks/{id}: GET, PUT
+// and DELETE the webhook, GET {id}/deliveries for its delivery log and POST {id}/test
+// to send it a test event right away
+func WebhookHandler(webhooks Webhooks, auditor *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/webhooks/"), "/")
+		if _, err := uuid.Parse(path[0]); err != nil || len(path) > 2 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "invalid path",
+			})
+			return
+		}
+
+		company, ok := apiKeyCompany(w, r)
+		if !ok {
+			return
+		}
+
+		hook, err := database.GetWebhook(company.ID, path[0])
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "database error: " + err.Error(),
+			})
+			return
+		}
+		if hook == nil {
+			respondJSON(w, http.StatusNotFound, map[string]string{
+				"error": "webhook not found",
+			})
+			return
+		}
+
+		action := ""
+		if len(path) == 2 {
+			action = path[1]
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			respondJSON(w, http.StatusOK, newWebhookResponse(hook))
+		case action == "" && r.Method == http.MethodPut:
+			updateWebhook(w, r, auditor, hook)
+		case action == "" && r.Method == http.MethodDelete:
+			deleteWebhook(w, r, auditor, hook)
+		case action == "deliveries" && r.Method == http.MethodGet:
+			listDeliveries(w, r, hook)
+		case action == "test" && r.Method == http.MethodPost:
+			testWebhook(w, r, webhooks, hook)
+		case action == "" || action == "deliveries" || action == "test":
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		default:
+			respondJSON(w, http.StatusNotFound, map[string]string{
+				"error": "not found",
+			})
+		}
+	}
+}
+
+func updateWebhook(w http.ResponseWriter, r *http.Request, auditor *audit.Recorder, hook *database.Webhook) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "invalid request body: " + err.Error(),
+		})
+		return
+	}
+
+	if err := applyWebhookRequest(hook, req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := database.UpdateWebhook(hook); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "failed to update webhook: " + err.Error(),
+		})
+		return
+	}
+
+	recordAudit(auditor, r, audit.EventWebhookUpdated, "webhook", hook.ID, webhookAuditDetails(hook))
+
+	respondJSON(w, http.StatusOK, newWebhookResponse(hook))
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request, auditor *audit.Recorder, hook *database.Webhook) {
+	deleted, err := database.DeleteWebhook(hook.CompanyID, hook.ID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "failed to delete webhook: " + err.Error(),
+		})
+		return
+	}
+	if !deleted {
+		respondJSON(w, http.StatusNotFound, map[string]string{
+			"error": "webhook not found",
+		})
+		return
+	}
+
+	recordAudit(auditor, r, audit.EventWebhookDeleted, "webhook", hook.ID, webhookAuditDetails(hook))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listDeliveries(w http.ResponseWriter, r *http.Request, hook *database.Webhook) {
+	query := r.URL.Query()
+	limit, offset, err := parsePage(query, defaultDeliveryLimit, maxDeliveryLimit)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	deliveries, err := database.ListWebhookDeliveries(hook.CompanyID, hook.ID, query.Get("status"), limit, offset)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "database error: " + err.Error(),
+		})
+		return
+	}
+
+	responses := make([]DeliveryResponse, len(deliveries))
+	for i := range deliveries {
+		responses[i] = newDeliveryResponse(&deliveries[i])
+	}
+
+	respondJSON(w, http.StatusOK, responses)
+}
+
+func testWebhook(w http.ResponseWriter, r *http.Request, webhooks Webhooks, hook *database.Webhook) {
+	delivery, err := webhooks.Test(hook)
+	if delivery == nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "failed to send test event: " + err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "failed to log test delivery: " + err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newDeliveryResponse(delivery))
+}
+
+// webhookAuditDetails describes a webhook in the audit log
+func webhookAuditDetails(hook *database.Webhook) map[string]any {
+	return map[string]any{
+		"url":    hook.URL,
+		"events": hook.Events,
+		"active": hook.IsActive,
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"testing"
+
+	"aq-server/internal/database"
+)
+
+func TestApplyWebhookRequest(t *testing.T) {
+	hook := &database.Webhook{IsActive: true}
+	events := []string{"room.started", "participant.joined"}
+	if err := applyWebhookRequest(hook, WebhookRequest{URL: "https://example.com/hooks", Events: &events}); err != nil {
+		t.Fatalf("Expected a valid webhook, got %v", err)
+	}
+	if hook.URL != "https://example.com/hooks" || hook.Events != "room.started,participant.joined" || !hook.IsActive {
+		t.Errorf("Expected the request to be applied, got %+v", hook)
+	}
+
+	// Fields left out aren't changed
+	inactive := false
+	if err := applyWebhookRequest(hook, WebhookRequest{Active: &inactive}); err != nil {
+		t.Fatalf("Expected a valid update, got %v", err)
+	}
+	if hook.URL != "https://example.com/hooks" || hook.Events != "room.started,participant.joined" || hook.IsActive {
+		t.Errorf("Expected only the webhook to be deactivated, got %+v", hook)
+	}
+
+	unknown := []string{"room.exploded"}
+	invalid := []WebhookRequest{
+		{URL: "ftp://example.com/hooks"},
+		{URL: "/hooks"},
+		{URL: "http://169.254.169.254/latest/meta-data"},
+		{URL: "http://[::1]:8080/hooks"},
+		{URL: "http://localhost/hooks"},
+		{Events: &unknown},
+	}
+	for _, req := range invalid {
+		if err := applyWebhookRequest(&database.Webhook{}, req); err == nil {
+			t.Errorf("Expected %+v to be rejected", req)
+		}
+	}
+}
@@ -7,38 +7,52 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"text/template"
 	"time"
 
 	"aq-server/internal/api"
+	"aq-server/internal/audit"
 	"aq-server/internal/config"
 	"aq-server/internal/database"
 	"aq-server/internal/handlers"
 	"aq-server/internal/keepalive"
+	"aq-server/internal/metering"
+	"aq-server/internal/ratelimit"
 	"aq-server/internal/room"
 	"aq-server/internal/sfu"
-	"aq-server/internal/types"
+	"aq-server/internal/webhook"
 
 	"github.com/gorilla/websocket"
 	"github.com/pion/logging"
-	"github.com/pion/webrtc/v4"
 	"github.com/urfave/negroni/v3"
+	"gorm.io/datatypes"
 )
 
+// Audit log entries are queued up to auditQueueSize and written auditBatchSize at a time
+const (
+	auditQueueSize = 4096
+	auditBatchSize = 100
+)
+
+// webhookInterval is how often due webhook deliveries are attempted
+const webhookInterval = time.Second
+
 // App holds the application state
 type App struct {
-	cfg             *config.Config
-	httpServer      *http.Server
-	serveMux        *http.ServeMux
-	upgrader        websocket.Upgrader
-	indexTemplate   *template.Template
-	listLock        sync.RWMutex
-	peerConnections []types.PeerConnectionState
-	trackLocals     map[string]*webrtc.TrackLocalStaticRTP
-	log             logging.LeveledLogger
-	roomManager     *room.RoomManager
+	cfg           *config.Config
+	httpServer    *http.Server
+	serveMux      *http.ServeMux
+	upgrader      websocket.Upgrader
+	indexTemplate *template.Template
+	log           logging.LeveledLogger
+	roomManager   *room.RoomManager
+	engine        *sfu.Engine
+	wsHandler     *handlers.Handler
+	limiter       *ratelimit.Limiter
+	meter         *metering.Meter
+	auditor       *audit.Recorder
+	webhooks      *webhook.Dispatcher
 }
 
 // New creates and initializes a new App
@@ -63,6 +77,36 @@ func New() (*App, error) {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Sessions still open were left behind by a server that didn't shut down cleanly
+	if closed, err := database.CloseOrphanedSessions(); err != nil {
+		log.Errorf("Failed to close orphaned sessions: %v", err)
+	} else if closed > 0 {
+		log.Warnf("Closed %d sessions left open by the previous run", closed)
+	}
+
+	roomManager := room.NewRoomManager()
+
+	engine, err := sfu.NewEngine(log, roomManager)
+	if err != nil {
+		return nil, err
+	}
+	engine.StartSpeakerDetection(cfg.SpeakerInterval)
+
+	meter := metering.NewMeter()
+	engine.SetMeter(meter)
+
+	proxies, err := audit.ParseProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	auditor := audit.NewRecorder(auditQueueSize, proxies, log)
+
+	webhooks := webhook.NewDispatcher(webhook.NewStore(), webhook.NewClient(cfg.WebhookTimeout), log)
+	if cfg.WebhookAttempts > 0 {
+		webhooks.MaxAttempts = cfg.WebhookAttempts
+	}
+	engine.SetWebhooks(webhooks)
+
 	app := &App{
 		cfg:        cfg,
 		httpServer: httpServer,
@@ -71,9 +115,17 @@ func New() (*App, error) {
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
 		indexTemplate: &template.Template{},
-		trackLocals:   make(map[string]*webrtc.TrackLocalStaticRTP),
 		log:           log,
-		roomManager:   room.NewRoomManager(),
+		roomManager:   roomManager,
+		engine:        engine,
+		limiter: ratelimit.NewLimiter(cfg.RateLimitDefault, map[string]int{
+			ratelimit.BudgetTokens: cfg.RateLimitTokens,
+			ratelimit.BudgetRooms:  cfg.RateLimitRooms,
+			ratelimit.BudgetAPI:    cfg.RateLimitAPI,
+		}),
+		meter:    meter,
+		auditor:  auditor,
+		webhooks: webhooks,
 	}
 
 	// Read index.html from disk into memory
@@ -83,33 +135,17 @@ func New() (*App, error) {
 	}
 	app.indexTemplate = template.Must(template.New("").Parse(string(indexHTML)))
 
-	// Initialize handlers package with context
+	// Create the WebSocket handler bound to our SFU engine
 	keepaliveCfg := keepalive.Config{
 		PingInterval:  app.cfg.KeepalivePingInt,
 		PongWaitTime:  app.cfg.KeepalivePongWait,
 		WriteDeadline: app.cfg.WriteDeadline,
 	}
 
-	handlers.InitContext(&handlers.HandlerContext{
-		Upgrader:              app.upgrader,
-		Logger:                app.log,
-		PeerConnections:       &app.peerConnections,
-		TrackLocals:           &app.trackLocals,
-		AddTrack:              sfu.AddTrack,
-		RemoveTrack:           sfu.RemoveTrack,
-		SignalPeerConnections: sfu.SignalPeerConnections,
-		BroadcastChat:         sfu.BroadcastChat,
-		KeepaliveConfig:       keepaliveCfg,
-		RoomManager:           app.roomManager,
-	})
-
-	// Initialize SFU package with context
-	sfu.InitContext(&sfu.SFUContext{
-		Logger:          app.log,
-		PeerConnections: &app.peerConnections,
-		TrackLocals:     &app.trackLocals,
-		RoomManager:     app.roomManager,
-	})
+	app.wsHandler = handlers.NewHandler(app.engine, app.log, keepaliveCfg)
+	app.wsHandler.Upgrader = app.upgrader
+	app.wsHandler.GracePeriod = app.cfg.SessionGracePeriod
+	app.wsHandler.Audit = auditor
 
 	return app, nil
 }
@@ -126,16 +162,64 @@ func (a *App) Run() error {
 	n.Use(negroni.NewRecovery())
 
 	// Setup REST API routes with net/http
-	if err := api.SetupRoutes(a.serveMux); err != nil {
+	if err := api.SetupRoutes(a.serveMux, a.wsHandler, a.webhooks, a.limiter, a.auditor); err != nil {
 		a.log.Errorf("Failed to setup API routes: %v", err)
 		return err
 	}
 
+	// Write API usage to the database periodically and once more on shutdown
+	flushInterval := a.cfg.RateLimitFlush
+	if flushInterval <= 0 {
+		flushInterval = time.Minute
+	}
+	stopLimiter := make(chan struct{})
+	limiterDone := make(chan struct{})
+	go func() {
+		defer close(limiterDone)
+		a.limiter.Run(flushInterval, stopLimiter, a.persistRateLimitUsage)
+	}()
+
+	// Write metered usage to the daily analytics periodically and once more on shutdown,
+	// after the last participants left
+	meteringInterval := a.cfg.MeteringFlush
+	if meteringInterval <= 0 {
+		meteringInterval = time.Minute
+	}
+	stopMeter := make(chan struct{})
+	meterDone := make(chan struct{})
+	go func() {
+		defer close(meterDone)
+		a.meter.Run(meteringInterval, stopMeter, a.persistUsage)
+	}()
+
+	// Write audit log entries in batches, and what's left once the server stopped
+	auditInterval := a.cfg.AuditFlush
+	if auditInterval <= 0 {
+		auditInterval = 5 * time.Second
+	}
+	stopAudit := make(chan struct{})
+	auditDone := make(chan struct{})
+	go func() {
+		defer close(auditDone)
+		a.auditor.Run(auditBatchSize, auditInterval, stopAudit, a.persistAuditLogs)
+	}()
+
+	// Deliver webhook events, including those left pending by the previous run. Events
+	// of the participants disconnected on shutdown are stored for the next run.
+	stopWebhooks := make(chan struct{})
+	webhooksDone := make(chan struct{})
+	go func() {
+		defer close(webhooksDone)
+		a.webhooks.Run(webhookInterval, stopWebhooks)
+	}()
+
 	// Register route handlers for WebSocket and static files
 	a.serveMux.HandleFunc("/", a.indexHandler)
 	a.serveMux.HandleFunc("/aq_server/", a.indexHandler)
 	a.serveMux.HandleFunc("/aq_server/ws", a.websocketHandler)
 	a.serveMux.HandleFunc("/ws", a.websocketHandler)
+	a.serveMux.HandleFunc(handlers.WHIPPath, a.wsHandler.WHIPHandler)
+	a.serveMux.HandleFunc(handlers.WHEPPath, a.wsHandler.WHEPHandler)
 	a.serveMux.HandleFunc("/health", a.healthHandler)
 	a.serveMux.HandleFunc("/metrics", a.metricsHandler)
 
@@ -182,6 +266,15 @@ func (a *App) Run() error {
 		return err
 	}
 
+	close(stopLimiter)
+	<-limiterDone
+	close(stopMeter)
+	<-meterDone
+	close(stopWebhooks)
+	<-webhooksDone
+	close(stopAudit)
+	<-auditDone
+
 	// Close database connection
 	a.log.Infof("Closing database connection...")
 	if err := database.Close(); err != nil {
@@ -215,7 +308,7 @@ func (a *App) indexHandler(w http.ResponseWriter, r *http.Request) {
 
 // websocketHandler handles WebSocket connections
 func (a *App) websocketHandler(w http.ResponseWriter, r *http.Request) {
-	handlers.WebsocketHandler(w, r)
+	a.wsHandler.WebsocketHandler(w, r)
 }
 
 // healthHandler returns health status
@@ -230,7 +323,7 @@ func (a *App) healthHandler(w http.ResponseWriter, r *http.Request) {
 		"status":    "healthy",
 		"message":   "Server is running",
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
-		"peers":     len(a.peerConnections),
+		"peers":     a.engine.PeerCount(),
 	}
 
 	if err := json.NewEncoder(w).Encode(health); err != nil {
@@ -247,8 +340,8 @@ func (a *App) metricsHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	metrics := map[string]interface{}{
-		"active_connections":        len(a.peerConnections),
-		"total_connections_created": len(a.peerConnections),
+		"active_connections":        a.engine.PeerCount(),
+		"total_connections_created": a.engine.PeerCount(),
 		"uptime_seconds":            int(time.Since(time.Now()).Seconds()),
 		"rooms_active":              0,
 		"timestamp":                 time.Now().UTC().Format(time.RFC3339),
@@ -259,21 +352,90 @@ func (a *App) metricsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// shutdown closes all peer connections and cleans up resources
-func (a *App) shutdown() {
-	a.listLock.Lock()
-	defer a.listLock.Unlock()
-
-	for _, pc := range a.peerConnections {
-		if pc.PeerConnection != nil {
-			pc.Websocket.Close()
-			if err := pc.PeerConnection.Close(); err != nil {
-				a.log.Warnf("Error closing peer connection: %v", err)
+// persistRateLimitUsage writes the API requests counted by the rate limiter
+func (a *App) persistRateLimitUsage(usage []ratelimit.Usage) {
+	rows := make([]database.RateLimitTracker, len(usage))
+	for i, u := range usage {
+		rows[i] = database.RateLimitTracker{
+			CompanyID:      u.CompanyID,
+			CredentialType: u.CredentialType,
+			CredentialID:   u.CredentialID,
+			Endpoint:       u.Budget,
+			RequestCount:   u.Requests,
+			WindowStart:    u.WindowStart,
+			WindowEnd:      u.WindowEnd,
+		}
+	}
+
+	if err := database.RecordRateLimitUsage(rows); err != nil {
+		a.log.Errorf("Failed to record API usage: %v", err)
+	}
+}
+
+// persistUsage adds metered usage to the daily analytics
+func (a *App) persistUsage(usage []metering.Usage) {
+	rows := make([]database.Analytics, 0, 3*len(usage))
+	for _, u := range usage {
+		for metric, value := range map[string]int64{
+			database.MetricParticipantSeconds: u.ParticipantSeconds,
+			database.MetricTrackSeconds:       u.TrackSeconds,
+			database.MetricForwardedBytes:     u.ForwardedBytes,
+		} {
+			if value == 0 {
+				continue
 			}
+			rows = append(rows, database.Analytics{
+				CompanyID:  u.CompanyID,
+				RoomID:     u.RoomID,
+				MetricType: metric,
+				MetricDate: u.Date,
+				Value:      value,
+			})
 		}
 	}
 
-	a.peerConnections = []types.PeerConnectionState{}
+	if err := database.RecordUsage(rows); err != nil {
+		a.log.Errorf("Failed to record metered usage: %v", err)
+	}
+}
+
+// persistAuditLogs writes a batch of audit log entries
+func (a *App) persistAuditLogs(entries []audit.Entry) {
+	logs := make([]database.AuditLog, 0, len(entries))
+	for _, e := range entries {
+		details, err := json.Marshal(e.Details)
+		if err != nil {
+			a.log.Errorf("Failed to encode details of audit log entry %s: %v", e.EventType, err)
+			details = []byte("{}")
+		}
+
+		row := database.AuditLog{
+			EventType:    e.EventType,
+			ActorType:    e.ActorType,
+			ActorID:      e.ActorID,
+			ResourceType: e.ResourceType,
+			ResourceID:   e.ResourceID,
+			Action:       e.Action(),
+			Status:       e.Status,
+			Details:      datatypes.JSON(details),
+			CreatedAt:    e.Time,
+		}
+		if e.CompanyID != "" {
+			companyID := e.CompanyID
+			row.CompanyID = &companyID
+		}
+		logs = append(logs, row)
+	}
+
+	if err := database.CreateAuditLogs(logs); err != nil {
+		a.log.Errorf("Failed to write %d audit log entries: %v", len(logs), err)
+	}
+}
+
+// shutdown closes all peer connections and cleans up resources
+func (a *App) shutdown() {
+	a.wsHandler.Shutdown()
+	a.engine.Close()
 	a.log.Infof("All peer connections closed")
 }
 
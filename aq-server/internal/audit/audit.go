@@ -0,0 +1,251 @@
+// Package audit records administrative and security events. Entries are queued in
+// memory and written in batches, so recording never waits for the database.
+package audit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+)
+
+// Event types, named after the resource and what happened to it
+const (
+	EventTokenMinted       = "token.minted"
+	EventTokensRevoked     = "token.revoked"
+	EventRoomCreated       = "room.created"
+	EventRoomUpdated       = "room.updated"
+	EventRoomDeleted       = "room.deleted"
+	EventAPIKeyCreated     = "api_key.created"
+	EventAPIKeyRevoked     = "api_key.revoked"
+	EventSigningKeyCreated = "signing_key.created"
+	EventSigningKeyRetired = "signing_key.retired"
+	EventWebhookCreated    = "webhook.created"
+	EventWebhookUpdated    = "webhook.updated"
+	EventWebhookDeleted    = "webhook.deleted"
+	EventAuthFailed        = "auth.failed"
+	EventParticipantMuted  = "participant.muted"
+)
+
+// Actor types
+const (
+	ActorAPIKey      = "api_key"     // ID is the API key's ID
+	ActorToken       = "token"       // ID is the room token's ID
+	ActorParticipant = "participant" // ID is the participant's user name
+	ActorAnonymous   = "anonymous"   // A caller without valid credentials
+)
+
+// Statuses
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// Entry is an audited event. CompanyID is empty for events that can't be attributed
+// to a company, e.g. requests with unknown credentials.
+type Entry struct {
+	CompanyID    string
+	EventType    string
+	ActorType    string
+	ActorID      string
+	ResourceType string
+	ResourceID   string
+	Status       string
+	Details      map[string]any
+	Time         time.Time
+}
+
+// Action is what happened to the resource, the part of the event type after the dot
+func (e Entry) Action() string {
+	if i := strings.IndexByte(e.EventType, '.'); i >= 0 {
+		return e.EventType[i+1:]
+	}
+	return e.EventType
+}
+
+// Recorder queues entries until they're written. Entries recorded while the queue is
+// full are dropped, rather than slowing down the request that recorded them. Record
+// may be called on a nil recorder, which discards entries.
+type Recorder struct {
+	entries chan Entry
+	dropped atomic.Uint64
+	proxies Proxies
+	logger  logging.LeveledLogger
+}
+
+// NewRecorder creates a recorder that queues up to size entries, taking the client
+// addresses of requests forwarded by proxies from their headers
+func NewRecorder(size int, proxies Proxies, logger logging.LeveledLogger) *Recorder {
+	return &Recorder{
+		entries: make(chan Entry, size),
+		proxies: proxies,
+		logger:  logger,
+	}
+}
+
+// Record queues an entry, stamped with the current time unless it has one
+func (r *Recorder) Record(e Entry) {
+	if r == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Status == "" {
+		e.Status = StatusSuccess
+	}
+
+	select {
+	case r.entries <- e:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// AuthFailure records a request whose credential, an API key or a room token, was
+// refused. The company is only known when the credential belongs to one but isn't
+// allowed to make the request.
+func (r *Recorder) AuthFailure(req *http.Request, companyID, credential, reason string) {
+	r.Record(Entry{
+		CompanyID:    companyID,
+		EventType:    EventAuthFailed,
+		ActorType:    ActorAnonymous,
+		ResourceType: credential,
+		Status:       StatusFailure,
+		Details: map[string]any{
+			"reason":  reason,
+			"path":    req.URL.Path,
+			"address": r.ClientAddress(req),
+		},
+	})
+}
+
+// Run hands queued entries to persist in batches of up to batchSize, or whatever was
+// queued once interval passed, until stop is closed. Then it writes what's left.
+func (r *Recorder) Run(batchSize int, interval time.Duration, stop <-chan struct{}, persist func([]Entry)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	batch := make([]Entry, 0, batchSize)
+	flush := func() {
+		if dropped := r.dropped.Swap(0); dropped > 0 {
+			r.logger.Warnf("Dropped %d audit log entries, the queue was full", dropped)
+		}
+		if len(batch) > 0 {
+			persist(batch)
+			batch = make([]Entry, 0, batchSize)
+		}
+	}
+
+	for {
+		select {
+		case e := <-r.entries:
+			batch = append(batch, e)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-stop:
+			for {
+				select {
+				case e := <-r.entries:
+					batch = append(batch, e)
+					if len(batch) >= batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// ClientAddress returns the address of the client of req, see Proxies.ClientAddress.
+// A nil recorder trusts no proxy.
+func (r *Recorder) ClientAddress(req *http.Request) string {
+	if r == nil {
+		return Proxies(nil).ClientAddress(req)
+	}
+	return r.proxies.ClientAddress(req)
+}
+
+// Proxies are the networks of proxies trusted to forward client addresses
+type Proxies []netip.Prefix
+
+// ParseProxies parses a comma-separated list of IP addresses and CIDR networks
+func ParseProxies(list string) (Proxies, error) {
+	proxies := Proxies{}
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// trusts reports whether address is one of the proxies
+func (p Proxies) trusts(address string) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientAddress returns the address of the client of r. X-Real-IP and
+// X-Forwarded-For are only honoured when r comes from a trusted proxy, anyone else
+// could forge them. X-Forwarded-For is read from the right, skipping the proxies
+// that appended to it.
+func (p Proxies) ClientAddress(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !p.trusts(remote) {
+		return remote
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop != "" && (i == 0 || !p.trusts(hop)) {
+				return hop
+			}
+		}
+	}
+	return remote
+}
@@ -0,0 +1,122 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+)
+
+func TestRecorderBatches(t *testing.T) {
+	r := NewRecorder(10, nil, logging.NewDefaultLoggerFactory().NewLogger("audit-test"))
+
+	for i := 0; i < 5; i++ {
+		r.Record(Entry{CompanyID: "acme", EventType: EventRoomCreated})
+	}
+
+	batches := make(chan []Entry, 10)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(2, time.Hour, stop, func(batch []Entry) { batches <- batch })
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case batch := <-batches:
+			if len(batch) != 2 {
+				t.Errorf("Expected a full batch of 2, got %d", len(batch))
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected full batches to be written right away")
+		}
+	}
+
+	// What's left is written on stop
+	close(stop)
+	<-done
+	close(batches)
+
+	left := 0
+	for batch := range batches {
+		for _, e := range batch {
+			if e.Time.IsZero() || e.Status != StatusSuccess {
+				t.Errorf("Expected entries to be stamped and successful by default, got %+v", e)
+			}
+		}
+		left += len(batch)
+	}
+	if left != 1 {
+		t.Errorf("Expected the last entry to be written on stop, got %d", left)
+	}
+}
+
+func TestRecorderDropsWhenFull(t *testing.T) {
+	r := NewRecorder(1, nil, logging.NewDefaultLoggerFactory().NewLogger("audit-test"))
+
+	r.Record(Entry{EventType: EventAuthFailed})
+	r.Record(Entry{EventType: EventAuthFailed})
+
+	if dropped := r.dropped.Load(); dropped != 1 {
+		t.Errorf("Expected 1 entry to be dropped, got %d", dropped)
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	var r *Recorder
+	r.Record(Entry{EventType: EventAuthFailed})
+}
+
+func TestEntryAction(t *testing.T) {
+	if action := (Entry{EventType: EventTokenMinted}).Action(); action != "minted" {
+		t.Errorf("Expected minted, got %s", action)
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8, 192.0.2.10")
+	if err != nil {
+		t.Fatalf("Expected the proxies to parse, got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		remote   string
+		headers  map[string]string
+		expected string
+	}{
+		{"direct", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"forged real ip", "192.0.2.1:1234", map[string]string{"X-Real-IP": "203.0.113.7"}, "192.0.2.1"},
+		{"forged forwarded", "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "192.0.2.1"},
+		{"real ip", "192.0.2.10:1234", map[string]string{"X-Real-IP": "203.0.113.7"}, "203.0.113.7"},
+		{"forwarded", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.1"}, "203.0.113.7"},
+		{"spoofed hop", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"proxy without headers", "10.0.0.2:1234", nil, "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			r.RemoteAddr = tt.remote
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+
+			if address := proxies.ClientAddress(r); address != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, address)
+			}
+		})
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	if proxies, err := ParseProxies(""); err != nil || len(proxies) != 0 {
+		t.Errorf("Expected no proxies, got %v and %v", proxies, err)
+	}
+	if _, err := ParseProxies("10.0.0.0/8,proxy.internal"); err == nil {
+		t.Error("Expected an invalid proxy to fail")
+	}
+}
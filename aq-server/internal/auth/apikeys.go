@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// apiKeyPrefix starts every generated API key, so leaked keys are easy to spot
+const apiKeyPrefix = "aq_"
+
+// apiKeyBytes is the number of random bytes in an API key
+const apiKeyBytes = 32
+
+// GenerateAPIKey generates a new random API key. It's shown to the company once and
+// only its hash is stored.
+func GenerateAPIKey() (string, error) {
+	random := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	return apiKeyPrefix + hex.EncodeToString(random), nil
+}
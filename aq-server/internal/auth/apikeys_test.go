@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"aq-server/internal/database"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	first, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("Failed to generate api key: %v", err)
+	}
+	second, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("Failed to generate api key: %v", err)
+	}
+
+	if !strings.HasPrefix(first, apiKeyPrefix) || len(first) != len(apiKeyPrefix)+2*apiKeyBytes {
+		t.Errorf("Expected an %s key of %d characters, got %s", apiKeyPrefix, len(apiKeyPrefix)+2*apiKeyBytes, first)
+	}
+	if first == second {
+		t.Error("Expected generated api keys to differ")
+	}
+
+	// Keys are told apart by their stored prefix, not their hash
+	if database.APIKeyPrefix(first) == database.APIKeyPrefix(second) {
+		t.Errorf("Expected different prefixes, got %s twice", database.APIKeyPrefix(first))
+	}
+	if database.HashAPIKey(first) == database.HashAPIKey(second) || strings.Contains(database.HashAPIKey(first), first[len(apiKeyPrefix):]) {
+		t.Error("Expected api keys to hash to different values that don't contain the key")
+	}
+}
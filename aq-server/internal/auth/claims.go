@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"aq-server/internal/types"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Claims are the claims of a room token. Companies mint room tokens with their
+// API key, and the tokens are signed with the company's secret key, or sign room
+// tokens themselves with one of their signing keys.
+type Claims struct {
+	CompanyID string `json:"company_id"`
+	RoomID    string `json:"room_id"`
+	UserName  string `json:"user_name"`
+	UserType  string `json:"user_type,omitempty"` // "host", "guest", "presenter"
+
+	// AutoSubscribe forwards every track in the room unless unsubscribed; when false
+	// only tracks subscribed to explicitly are forwarded. Defaults to true.
+	AutoSubscribe *bool `json:"auto_subscribe,omitempty"`
+
+	// Permissions are what the token allows. Tokens without them get the default
+	// permissions.
+	Permissions types.Permissions `json:"permissions"`
+	jwt.RegisteredClaims
+
+	// TokenID is the ID of the stored token and SingleUse whether it may only be
+	// used to join once, set by Validate
+	TokenID   string `json:"-"`
+	SingleUse bool   `json:"-"`
+}
+
+// Role returns the user type of the token, guest if it has none
+func (c *Claims) Role() string {
+	if c.UserType == "" {
+		return "guest"
+	}
+
+	return c.UserType
+}
+
+// GenerateToken signs a room token with a company's secret key, valid for duration
+// seconds. It fills in the registered claims, giving every token a unique ID.
+func GenerateToken(claims Claims, secretKey string, duration int) (string, time.Time, error) {
+	return sign(claims, jwt.SigningMethodHS256, "", []byte(secretKey), duration)
+}
+
+// sign fills in the registered claims of a token and signs it, setting the kid
+// header if keyID isn't empty
+func sign(claims Claims, method jwt.SigningMethod, keyID string, key any, duration int) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(duration) * time.Second)
+
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	if keyID != "" {
+		token.Header["kid"] = keyID
+	}
+
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return tokenString, expiresAt, nil
+}
+
+// HashToken creates a SHA256 hash of a token for storage
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
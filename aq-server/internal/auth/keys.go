@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"aq-server/internal/database"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algorithms of the signing keys companies mint their own room tokens with
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// ErrUnsupportedAlgorithm is returned for signing key algorithms other than RS256 and EdDSA
+var ErrUnsupportedAlgorithm = errors.New("unsupported algorithm, use RS256 or EdDSA")
+
+// rsaKeyBits is the size of generated RSA keys
+const rsaKeyBits = 2048
+
+// GenerateKeyPair generates a key pair for an algorithm, returning both halves PEM
+// encoded: the public key as PKIX and the private key as PKCS #8
+func GenerateKeyPair(algorithm string) (publicKey, privateKey string, err error) {
+	var public, private any
+
+	switch algorithm {
+	case AlgorithmRS256:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+		public, private = &key.PublicKey, key
+	case AlgorithmEdDSA:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to generate Ed25519 key: %w", err)
+		}
+		public, private = pub, priv
+	default:
+		return "", "", ErrUnsupportedAlgorithm
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode public key: %w", err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	publicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	privateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+
+	return publicKey, privateKey, nil
+}
+
+// ParsePublicKey parses a PEM encoded public key of an algorithm
+func ParsePublicKey(algorithm, publicKey string) (crypto.PublicKey, error) {
+	switch algorithm {
+	case AlgorithmRS256:
+		key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(publicKey))
+		if err != nil {
+			return nil, err
+		}
+		if key.N.BitLen() < rsaKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", rsaKeyBits)
+		}
+		return key, nil
+	case AlgorithmEdDSA:
+		return jwt.ParseEdPublicKeyFromPEM([]byte(publicKey))
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// GenerateTokenWithKey signs a room token with a company's private key like
+// GenerateToken does with its secret key, setting the kid header to keyID, the way
+// companies sign tokens on their own backend.
+func GenerateTokenWithKey(claims Claims, algorithm, keyID, privateKey string, duration int) (string, error) {
+	var method jwt.SigningMethod
+	var key any
+	var err error
+
+	switch algorithm {
+	case AlgorithmRS256:
+		method = jwt.SigningMethodRS256
+		key, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(privateKey))
+	case AlgorithmEdDSA:
+		method = jwt.SigningMethodEdDSA
+		key, err = jwt.ParseEdPrivateKeyFromPEM([]byte(privateKey))
+	default:
+		return "", ErrUnsupportedAlgorithm
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	token, _, err := sign(claims, method, keyID, key, duration)
+	return token, err
+}
+
+// JWK is the public half of a signing key as a JSON Web Key (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Ed25519 keys (RFC 8037)
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK converts a stored signing key to a JSON Web Key
+func NewJWK(key *database.SigningKey) (JWK, error) {
+	public, err := ParsePublicKey(key.Algorithm, key.PublicKey)
+	if err != nil {
+		return JWK{}, fmt.Errorf("failed to parse key %s: %w", key.ID, err)
+	}
+
+	jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	default:
+		return JWK{}, ErrUnsupportedAlgorithm
+	}
+
+	return jwk, nil
+}
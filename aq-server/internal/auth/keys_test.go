@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestNewJWK(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, _ := newSigningKey(t, "acme", algorithm)
+
+			jwk, err := NewJWK(key)
+			if err != nil {
+				t.Fatalf("Failed to convert key: %v", err)
+			}
+			if jwk.KeyID != key.ID || jwk.Algorithm != algorithm || jwk.Use != "sig" {
+				t.Errorf("Expected a signing JWK of %s for %s, got %+v", key.ID, algorithm, jwk)
+			}
+
+			public, err := ParsePublicKey(algorithm, key.PublicKey)
+			if err != nil {
+				t.Fatalf("Failed to parse public key: %v", err)
+			}
+
+			switch public := public.(type) {
+			case *rsa.PublicKey:
+				n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+				e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+				if jwk.KeyType != "RSA" || new(big.Int).SetBytes(n).Cmp(public.N) != 0 || new(big.Int).SetBytes(e).Int64() != int64(public.E) {
+					t.Errorf("Expected the RSA modulus and exponent, got %+v", jwk)
+				}
+			case ed25519.PublicKey:
+				x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+				if jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || !public.Equal(ed25519.PublicKey(x)) {
+					t.Errorf("Expected the Ed25519 public key, got %+v", jwk)
+				}
+			default:
+				t.Fatalf("Unexpected public key type %T", public)
+			}
+		})
+	}
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	if _, _, err := GenerateKeyPair("HS256"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected %v generating a key pair, got %v", ErrUnsupportedAlgorithm, err)
+	}
+
+	key, _ := newSigningKey(t, "acme", AlgorithmEdDSA)
+	if _, err := ParsePublicKey(AlgorithmRS256, key.PublicKey); err == nil {
+		t.Error("Expected an Ed25519 key not to parse as RS256")
+	}
+
+	key.Algorithm = "ES256"
+	if _, err := NewJWK(key); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected %v converting the key, got %v", ErrUnsupportedAlgorithm, err)
+	}
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"aq-server/internal/database"
+	"aq-server/internal/types"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrUnknownCompany is returned for tokens of companies that don't exist or are inactive
+	ErrUnknownCompany = errors.New("unknown or inactive company")
+
+	// ErrUnknownToken is returned for validly signed tokens that were never issued
+	ErrUnknownToken = errors.New("token was not issued")
+
+	// ErrTokenRevoked is returned for tokens that were revoked
+	ErrTokenRevoked = errors.New("token was revoked")
+
+	// ErrTokenExpired is returned for tokens past their expiry
+	ErrTokenExpired = errors.New("token has expired")
+
+	// ErrTokenUsed is returned when joining again with a single-use token
+	ErrTokenUsed = errors.New("token was already used")
+
+	// ErrMissingClaims is returned for tokens without a company, room or user name
+	ErrMissingClaims = errors.New("token is missing company_id, room_id or user_name")
+
+	// ErrUnknownKey is returned for tokens signed with a key that doesn't exist, is
+	// retired or belongs to another company
+	ErrUnknownKey = errors.New("unknown or retired signing key")
+
+	// ErrTokenLifetime is returned for self-signed tokens that don't expire soon enough
+	ErrTokenLifetime = errors.New("self-signed tokens must expire within 24 hours")
+)
+
+// MaxSelfSignedLifetime is the longest a token a company signs itself may be valid
+const MaxSelfSignedLifetime = 24 * time.Hour
+
+// Validator validates room tokens against the companies and tokens in the database
+type Validator struct {
+	// Company looks up the company a token claims to be issued by
+	Company func(companyID string) (*database.Company, error)
+
+	// Token looks up the stored token with a hash, nil if there is none
+	Token func(tokenHash string) (*database.Token, error)
+
+	// MarkUsed marks a stored token as used, reporting false if it already was
+	MarkUsed func(tokenID string) (bool, error)
+
+	// Key looks up the signing key with an ID, nil if there is none
+	Key func(keyID string) (*database.SigningKey, error)
+
+	// Register stores a token a company signed itself the first time it's used
+	Register func(token *database.Token) error
+}
+
+// NewValidator creates a validator backed by the database
+func NewValidator() *Validator {
+	return &Validator{
+		Company:  database.GetCompanyByID,
+		Token:    database.GetToken,
+		MarkUsed: database.MarkTokenUsed,
+		Key:      database.GetSigningKey,
+		Register: database.CreateToken,
+	}
+}
+
+// Validate verifies a room token with the secret key of the company that issued it,
+// or with the company's signing key named by its kid header, and checks that the
+// token was issued and hasn't been revoked or expired since. Tokens a company signed
+// itself are stored the first time they're used so they can be revoked like issued
+// ones. Single-use tokens validate after they were used, joining with them again is
+// refused by Consume.
+func (v *Validator) Validate(tokenString string) (*Claims, error) {
+	claims := &Claims{Permissions: types.DefaultPermissions()}
+	selfSigned := false
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if claims.CompanyID == "" || claims.RoomID == "" || claims.UserName == "" {
+			return nil, ErrMissingClaims
+		}
+
+		company, err := v.Company(claims.CompanyID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up company: %w", err)
+		}
+		if company == nil || !company.IsActive {
+			return nil, ErrUnknownCompany
+		}
+
+		keyID, _ := token.Header["kid"].(string)
+		if keyID == "" {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(company.SecretKey), nil
+		}
+
+		key, err := v.Key(keyID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up signing key: %w", err)
+		}
+		if key == nil || !key.Active || key.CompanyID != company.ID {
+			return nil, ErrUnknownKey
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		selfSigned = true
+		return ParsePublicKey(key.Algorithm, key.PublicKey)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), AlgorithmRS256, AlgorithmEdDSA}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	tokenHash := HashToken(tokenString)
+	stored, err := v.Token(tokenHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up token: %w", err)
+	}
+	if stored == nil && selfSigned {
+		if stored, err = v.register(tokenHash, claims); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case stored == nil || stored.CompanyID != claims.CompanyID:
+		return nil, ErrUnknownToken
+	case stored.Revoked:
+		return nil, ErrTokenRevoked
+	case !stored.ExpiresAt.IsZero() && time.Now().After(stored.ExpiresAt):
+		return nil, ErrTokenExpired
+	}
+
+	claims.TokenID = stored.ID
+	claims.SingleUse = stored.SingleUse
+
+	return claims, nil
+}
+
+// register stores a self-signed token. Such tokens must expire within
+// MaxSelfSignedLifetime, since until they're stored they can't be revoked.
+func (v *Validator) register(tokenHash string, claims *Claims) (*database.Token, error) {
+	if claims.ExpiresAt == nil || claims.ExpiresAt.After(time.Now().Add(MaxSelfSignedLifetime)) {
+		return nil, ErrTokenLifetime
+	}
+
+	permissions, err := json.Marshal(claims.Permissions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode permissions: %w", err)
+	}
+
+	token := &database.Token{
+		ID:          uuid.NewString(),
+		CompanyID:   claims.CompanyID,
+		TokenHash:   tokenHash,
+		RoomID:      claims.RoomID,
+		UserName:    claims.UserName,
+		Permissions: permissions,
+		ExpiresAt:   claims.ExpiresAt.Time,
+	}
+	if err := v.Register(token); err != nil {
+		// Another join with the same token may have stored it first
+		if stored, lookupErr := v.Token(tokenHash); lookupErr == nil && stored != nil {
+			return stored, nil
+		}
+		return nil, fmt.Errorf("failed to store token: %w", err)
+	}
+
+	return token, nil
+}
+
+// Consume uses a single-use token to join a room. It returns ErrTokenUsed if the
+// token was used before; tokens that aren't single-use can always join.
+func (v *Validator) Consume(claims *Claims) error {
+	if !claims.SingleUse {
+		return nil
+	}
+
+	used, err := v.MarkUsed(claims.TokenID)
+	if err != nil {
+		return fmt.Errorf("failed to use token: %w", err)
+	}
+	if !used {
+		return ErrTokenUsed
+	}
+
+	return nil
+}
@@ -0,0 +1,416 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"aq-server/internal/database"
+	"aq-server/internal/types"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testValidator validates tokens against one company, the tokens stored in it and
+// signing keys
+func testValidator(company *database.Company, stored map[string]*database.Token, keys ...*database.SigningKey) *Validator {
+	return &Validator{
+		Company: func(companyID string) (*database.Company, error) {
+			if company == nil || company.ID != companyID {
+				return nil, nil
+			}
+			return company, nil
+		},
+		Token: func(tokenHash string) (*database.Token, error) {
+			return stored[tokenHash], nil
+		},
+		MarkUsed: func(tokenID string) (bool, error) {
+			for _, token := range stored {
+				if token.ID == tokenID && !token.IsUsed {
+					token.IsUsed = true
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+		Key: func(keyID string) (*database.SigningKey, error) {
+			for _, key := range keys {
+				if key.ID == keyID {
+					return key, nil
+				}
+			}
+			return nil, nil
+		},
+		Register: func(token *database.Token) error {
+			stored[token.TokenHash] = token
+			return nil
+		},
+	}
+}
+
+// issue mints a token and stores it like the tokens API does
+func issue(t *testing.T, stored map[string]*database.Token, claims Claims, secretKey string) string {
+	t.Helper()
+
+	token, expiresAt, err := GenerateToken(claims, secretKey, 60)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	stored[HashToken(token)] = &database.Token{
+		ID:        "token-" + claims.UserName,
+		CompanyID: claims.CompanyID,
+		RoomID:    claims.RoomID,
+		UserName:  claims.UserName,
+		ExpiresAt: expiresAt,
+	}
+
+	return token
+}
+
+func TestValidatorValidate(t *testing.T) {
+	company := &database.Company{ID: "acme", SecretKey: "acme-secret", IsActive: true}
+	stored := map[string]*database.Token{}
+	validator := testValidator(company, stored)
+
+	token := issue(t, stored, Claims{CompanyID: "acme", RoomID: "lobby", UserName: "alice", UserType: "host"}, "acme-secret")
+
+	claims, err := validator.Validate(token)
+	if err != nil {
+		t.Fatalf("Expected token to validate, got %v", err)
+	}
+
+	if claims.CompanyID != "acme" || claims.RoomID != "lobby" || claims.UserName != "alice" || claims.Role() != "host" {
+		t.Errorf("Expected claims of alice in acme/lobby as host, got %+v", claims)
+	}
+	if claims.TokenID != "token-alice" {
+		t.Errorf("Expected token ID token-alice, got %s", claims.TokenID)
+	}
+}
+
+func TestValidatorRejects(t *testing.T) {
+	company := &database.Company{ID: "acme", SecretKey: "acme-secret", IsActive: true}
+	alice := Claims{CompanyID: "acme", RoomID: "lobby", UserName: "alice"}
+
+	tests := []struct {
+		name     string
+		token    func(stored map[string]*database.Token) string
+		expected error
+	}{
+		{
+			name: "wrong secret",
+			token: func(stored map[string]*database.Token) string {
+				return issue(t, stored, alice, "other-secret")
+			},
+		},
+		{
+			name: "unknown company",
+			token: func(stored map[string]*database.Token) string {
+				return issue(t, stored, Claims{CompanyID: "globex", RoomID: "lobby", UserName: "alice"}, "acme-secret")
+			},
+			expected: ErrUnknownCompany,
+		},
+		{
+			name: "missing room",
+			token: func(stored map[string]*database.Token) string {
+				return issue(t, stored, Claims{CompanyID: "acme", UserName: "alice"}, "acme-secret")
+			},
+			expected: ErrMissingClaims,
+		},
+		{
+			name: "not issued",
+			token: func(stored map[string]*database.Token) string {
+				token, _, _ := GenerateToken(alice, "acme-secret", 60)
+				return token
+			},
+			expected: ErrUnknownToken,
+		},
+		{
+			name: "revoked",
+			token: func(stored map[string]*database.Token) string {
+				token := issue(t, stored, alice, "acme-secret")
+				stored[HashToken(token)].Revoked = true
+				return token
+			},
+			expected: ErrTokenRevoked,
+		},
+		{
+			name: "expired",
+			token: func(stored map[string]*database.Token) string {
+				token := issue(t, stored, alice, "acme-secret")
+				stored[HashToken(token)].ExpiresAt = time.Now().Add(-time.Minute)
+				return token
+			},
+			expected: ErrTokenExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := map[string]*database.Token{}
+			validator := testValidator(company, stored)
+
+			_, err := validator.Validate(tt.token(stored))
+			if err == nil {
+				t.Fatal("Expected token to be rejected")
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestValidatorRejectsInactiveCompany(t *testing.T) {
+	company := &database.Company{ID: "acme", SecretKey: "acme-secret", IsActive: false}
+	stored := map[string]*database.Token{}
+	validator := testValidator(company, stored)
+
+	token := issue(t, stored, Claims{CompanyID: "acme", RoomID: "lobby", UserName: "alice"}, "acme-secret")
+
+	if _, err := validator.Validate(token); !errors.Is(err, ErrUnknownCompany) {
+		t.Errorf("Expected %v, got %v", ErrUnknownCompany, err)
+	}
+}
+
+func TestValidatorPermissions(t *testing.T) {
+	company := &database.Company{ID: "acme", SecretKey: "acme-secret", IsActive: true}
+	stored := map[string]*database.Token{}
+	validator := testValidator(company, stored)
+
+	viewer := issue(t, stored, Claims{
+		CompanyID:   "acme",
+		RoomID:      "lobby",
+		UserName:    "viewer",
+		Permissions: types.Permissions{Subscribe: true},
+	}, "acme-secret")
+
+	claims, err := validator.Validate(viewer)
+	if err != nil {
+		t.Fatalf("Expected token to validate, got %v", err)
+	}
+	if expected := (types.Permissions{Subscribe: true}); claims.Permissions != expected {
+		t.Errorf("Expected permissions %+v, got %+v", expected, claims.Permissions)
+	}
+
+	// Tokens minted before permissions existed get the defaults
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"company_id": "acme",
+		"room_id":    "lobby",
+		"user_name":  "legacy",
+		"exp":        time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte("acme-secret"))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	stored[HashToken(legacy)] = &database.Token{CompanyID: "acme"}
+
+	claims, err = validator.Validate(legacy)
+	if err != nil {
+		t.Fatalf("Expected legacy token to validate, got %v", err)
+	}
+	if claims.Permissions != types.DefaultPermissions() {
+		t.Errorf("Expected default permissions, got %+v", claims.Permissions)
+	}
+}
+
+func TestValidatorConsume(t *testing.T) {
+	company := &database.Company{ID: "acme", SecretKey: "acme-secret", IsActive: true}
+	stored := map[string]*database.Token{}
+	validator := testValidator(company, stored)
+
+	reusable := issue(t, stored, Claims{CompanyID: "acme", RoomID: "lobby", UserName: "alice"}, "acme-secret")
+	once := issue(t, stored, Claims{CompanyID: "acme", RoomID: "lobby", UserName: "bob"}, "acme-secret")
+	stored[HashToken(once)].SingleUse = true
+
+	for i := 0; i < 2; i++ {
+		claims, err := validator.Validate(reusable)
+		if err != nil {
+			t.Fatalf("Expected token to validate, got %v", err)
+		}
+		if err := validator.Consume(claims); err != nil {
+			t.Errorf("Expected reusable token to join again, got %v", err)
+		}
+	}
+
+	claims, err := validator.Validate(once)
+	if err != nil {
+		t.Fatalf("Expected token to validate, got %v", err)
+	}
+	if err := validator.Consume(claims); err != nil {
+		t.Fatalf("Expected single-use token to join once, got %v", err)
+	}
+
+	// A used single-use token still validates, e.g. to resume its session, but can't join
+	claims, err = validator.Validate(once)
+	if err != nil {
+		t.Fatalf("Expected used token to validate, got %v", err)
+	}
+	if err := validator.Consume(claims); !errors.Is(err, ErrTokenUsed) {
+		t.Errorf("Expected %v, got %v", ErrTokenUsed, err)
+	}
+}
+
+// newSigningKey generates a signing key of a company, returning it with its private key
+func newSigningKey(t *testing.T, companyID, algorithm string) (*database.SigningKey, string) {
+	t.Helper()
+
+	publicKey, privateKey, err := GenerateKeyPair(algorithm)
+	if err != nil {
+		t.Fatalf("Failed to generate %s key pair: %v", algorithm, err)
+	}
+
+	return &database.SigningKey{
+		ID:        companyID + "-" + algorithm,
+		CompanyID: companyID,
+		Algorithm: algorithm,
+		PublicKey: publicKey,
+		Active:    true,
+	}, privateKey
+}
+
+func TestValidatorSelfSigned(t *testing.T) {
+	company := &database.Company{ID: "acme", SecretKey: "acme-secret", IsActive: true}
+
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, privateKey := newSigningKey(t, "acme", algorithm)
+			stored := map[string]*database.Token{}
+			validator := testValidator(company, stored, key)
+
+			token, err := GenerateTokenWithKey(Claims{
+				CompanyID:   "acme",
+				RoomID:      "lobby",
+				UserName:    "alice",
+				Permissions: types.Permissions{Subscribe: true},
+			}, algorithm, key.ID, privateKey, 60)
+			if err != nil {
+				t.Fatalf("Failed to sign token: %v", err)
+			}
+
+			claims, err := validator.Validate(token)
+			if err != nil {
+				t.Fatalf("Expected token to validate, got %v", err)
+			}
+			if claims.UserName != "alice" || claims.Permissions != (types.Permissions{Subscribe: true}) {
+				t.Errorf("Expected claims of alice with subscribe only, got %+v", claims)
+			}
+
+			// The first use stores the token so it can be revoked like an issued one
+			registered := stored[HashToken(token)]
+			if registered == nil {
+				t.Fatal("Expected the token to be stored on first use")
+			}
+			if registered.ID != claims.TokenID || registered.RoomID != "lobby" || registered.UserName != "alice" {
+				t.Errorf("Expected the stored token to match the claims, got %+v", registered)
+			}
+
+			again, err := validator.Validate(token)
+			if err != nil {
+				t.Fatalf("Expected token to validate again, got %v", err)
+			}
+			if again.TokenID != claims.TokenID {
+				t.Errorf("Expected the stored token %s to be reused, got %s", claims.TokenID, again.TokenID)
+			}
+
+			registered.Revoked = true
+			if _, err := validator.Validate(token); !errors.Is(err, ErrTokenRevoked) {
+				t.Errorf("Expected %v, got %v", ErrTokenRevoked, err)
+			}
+		})
+	}
+}
+
+func TestValidatorRejectsSelfSigned(t *testing.T) {
+	company := &database.Company{ID: "acme", SecretKey: "acme-secret", IsActive: true}
+	alice := Claims{CompanyID: "acme", RoomID: "lobby", UserName: "alice"}
+
+	tests := []struct {
+		name     string
+		token    func(t *testing.T) (*database.SigningKey, string)
+		expected error
+	}{
+		{
+			name: "unknown key",
+			token: func(t *testing.T) (*database.SigningKey, string) {
+				key, privateKey := newSigningKey(t, "acme", AlgorithmEdDSA)
+				token, _ := GenerateTokenWithKey(alice, AlgorithmEdDSA, "missing", privateKey, 60)
+				return key, token
+			},
+			expected: ErrUnknownKey,
+		},
+		{
+			name: "retired key",
+			token: func(t *testing.T) (*database.SigningKey, string) {
+				key, privateKey := newSigningKey(t, "acme", AlgorithmEdDSA)
+				key.Active = false
+				token, _ := GenerateTokenWithKey(alice, AlgorithmEdDSA, key.ID, privateKey, 60)
+				return key, token
+			},
+			expected: ErrUnknownKey,
+		},
+		{
+			name: "key of another company",
+			token: func(t *testing.T) (*database.SigningKey, string) {
+				key, privateKey := newSigningKey(t, "globex", AlgorithmEdDSA)
+				token, _ := GenerateTokenWithKey(alice, AlgorithmEdDSA, key.ID, privateKey, 60)
+				return key, token
+			},
+			expected: ErrUnknownKey,
+		},
+		{
+			name: "other private key",
+			token: func(t *testing.T) (*database.SigningKey, string) {
+				key, _ := newSigningKey(t, "acme", AlgorithmEdDSA)
+				_, otherKey := newSigningKey(t, "acme", AlgorithmEdDSA)
+				token, _ := GenerateTokenWithKey(alice, AlgorithmEdDSA, key.ID, otherKey, 60)
+				return key, token
+			},
+		},
+		{
+			name: "algorithm of another key",
+			token: func(t *testing.T) (*database.SigningKey, string) {
+				key, _ := newSigningKey(t, "acme", AlgorithmEdDSA)
+				_, rsaKey := newSigningKey(t, "acme", AlgorithmRS256)
+				token, _ := GenerateTokenWithKey(alice, AlgorithmRS256, key.ID, rsaKey, 60)
+				return key, token
+			},
+		},
+		{
+			name: "secret key with kid",
+			token: func(t *testing.T) (*database.SigningKey, string) {
+				key, _ := newSigningKey(t, "acme", AlgorithmEdDSA)
+				token, _, _ := sign(alice, jwt.SigningMethodHS256, key.ID, []byte("acme-secret"), 60)
+				return key, token
+			},
+		},
+		{
+			name: "valid too long",
+			token: func(t *testing.T) (*database.SigningKey, string) {
+				key, privateKey := newSigningKey(t, "acme", AlgorithmEdDSA)
+				token, _ := GenerateTokenWithKey(alice, AlgorithmEdDSA, key.ID, privateKey, 48*60*60)
+				return key, token
+			},
+			expected: ErrTokenLifetime,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := map[string]*database.Token{}
+			key, token := tt.token(t)
+			validator := testValidator(company, stored, key)
+
+			_, err := validator.Validate(token)
+			if err == nil {
+				t.Fatal("Expected token to be rejected")
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+			if len(stored) != 0 {
+				t.Error("Expected a rejected token not to be stored")
+			}
+		})
+	}
+}
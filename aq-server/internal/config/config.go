@@ -10,13 +10,25 @@ import (
 
 // Config holds application configuration
 type Config struct {
-	Port              int
-	ServerURL         string
-	LogLevel          string
-	Env               string
-	KeepalivePingInt  time.Duration // Keepalive ping interval
-	KeepalivePongWait time.Duration // Time to wait for pong
-	WriteDeadline     time.Duration // Write operation timeout
+	Port               int
+	ServerURL          string
+	LogLevel           string
+	Env                string
+	KeepalivePingInt   time.Duration // Keepalive ping interval
+	KeepalivePongWait  time.Duration // Time to wait for pong
+	WriteDeadline      time.Duration // Write operation timeout
+	SpeakerInterval    time.Duration // Minimum time between active speaker events
+	SessionGracePeriod time.Duration // How long a disconnected session can be resumed
+	RateLimitDefault   int           // API requests per minute of a room token, and of an API key without its own limit
+	RateLimitTokens    int           // Token minting requests per minute of a company
+	RateLimitRooms     int           // Room management requests per minute of a company
+	RateLimitAPI       int           // Other API requests per minute of a company
+	RateLimitFlush     time.Duration // How often API usage is written to the database
+	MeteringFlush      time.Duration // How often metered usage is written to the database
+	AuditFlush         time.Duration // How often queued audit log entries are written to the database
+	WebhookTimeout     time.Duration // How long a webhook has to respond to a delivery
+	WebhookAttempts    int           // Attempts to deliver an event before giving up
+	TrustedProxies     string        // Comma-separated IPs and CIDRs of proxies trusted to forward client addresses
 }
 
 // Load parses and returns the application configuration
@@ -31,12 +43,35 @@ func Load() *Config {
 	pingInt := flag.String("keepalive-ping", getEnv("KEEPALIVE_PING", "30"), "keepalive ping interval in seconds")
 	pongWait := flag.String("keepalive-pong", getEnv("KEEPALIVE_PONG", "10"), "keepalive pong wait time in seconds")
 	writeDeadline := flag.String("write-deadline", getEnv("WRITE_DEADLINE", "5"), "write operation timeout in seconds")
+	speakerInterval := flag.String("speaker-interval", getEnv("SPEAKER_INTERVAL", "500"), "active speaker event interval in milliseconds")
+	sessionGrace := flag.String("session-grace", getEnv("SESSION_GRACE_PERIOD", "30"), "session resume grace period in seconds")
+	rateLimitDefault := flag.String("rate-limit-default", getEnv("DEFAULT_RATE_LIMIT_PER_MINUTE", "60"), "API requests per minute of a credential without its own limit (0 disables)")
+	rateLimitTokens := flag.String("rate-limit-tokens", getEnv("RATE_LIMIT_TOKENS_PER_MINUTE", "600"), "token minting requests per minute of a company (0 disables)")
+	rateLimitRooms := flag.String("rate-limit-rooms", getEnv("RATE_LIMIT_ROOMS_PER_MINUTE", "120"), "room management requests per minute of a company (0 disables)")
+	rateLimitAPI := flag.String("rate-limit-api", getEnv("RATE_LIMIT_API_PER_MINUTE", "120"), "other API requests per minute of a company (0 disables)")
+	rateLimitFlush := flag.String("rate-limit-flush", getEnv("RATE_LIMIT_FLUSH_INTERVAL", "60"), "how often API usage is written to the database in seconds")
+	meteringFlush := flag.String("metering-flush", getEnv("METERING_FLUSH_INTERVAL", "60"), "how often metered usage is written to the database in seconds")
+	auditFlush := flag.String("audit-flush", getEnv("AUDIT_FLUSH_INTERVAL", "5"), "how often queued audit log entries are written to the database in seconds")
+	webhookTimeout := flag.String("webhook-timeout", getEnv("WEBHOOK_TIMEOUT", "10"), "how long a webhook has to respond to a delivery in seconds")
+	webhookAttempts := flag.String("webhook-attempts", getEnv("WEBHOOK_MAX_ATTEMPTS", "10"), "attempts to deliver an event to a webhook before giving up")
+	trustedProxies := flag.String("trusted-proxies", getEnv("TRUSTED_PROXIES", ""), "comma-separated IPs and CIDRs of proxies whose X-Real-IP and X-Forwarded-For headers are trusted")
 	flag.Parse()
 
 	// Parse durations
 	pingIntSecs, _ := strconv.ParseInt(*pingInt, 10, 64)
 	pongWaitSecs, _ := strconv.ParseInt(*pongWait, 10, 64)
 	writeDeadlineSecs, _ := strconv.ParseInt(*writeDeadline, 10, 64)
+	speakerIntervalMillis, _ := strconv.ParseInt(*speakerInterval, 10, 64)
+	sessionGraceSecs, _ := strconv.ParseInt(*sessionGrace, 10, 64)
+	rateLimitDefaultPerMin, _ := strconv.Atoi(*rateLimitDefault)
+	rateLimitTokensPerMin, _ := strconv.Atoi(*rateLimitTokens)
+	rateLimitRoomsPerMin, _ := strconv.Atoi(*rateLimitRooms)
+	rateLimitAPIPerMin, _ := strconv.Atoi(*rateLimitAPI)
+	rateLimitFlushSecs, _ := strconv.ParseInt(*rateLimitFlush, 10, 64)
+	meteringFlushSecs, _ := strconv.ParseInt(*meteringFlush, 10, 64)
+	auditFlushSecs, _ := strconv.ParseInt(*auditFlush, 10, 64)
+	webhookTimeoutSecs, _ := strconv.ParseInt(*webhookTimeout, 10, 64)
+	webhookMaxAttempts, _ := strconv.Atoi(*webhookAttempts)
 
 	// Parse port from address
 	portStr := strings.TrimPrefix(*addr, ":")
@@ -46,13 +81,25 @@ func Load() *Config {
 	}
 
 	return &Config{
-		Port:              port,
-		ServerURL:         getEnv("SERVER_URL", "http://localhost:8080"),
-		LogLevel:          strings.ToLower(*logLevel),
-		Env:               strings.ToLower(*env),
-		KeepalivePingInt:  time.Duration(pingIntSecs) * time.Second,
-		KeepalivePongWait: time.Duration(pongWaitSecs) * time.Second,
-		WriteDeadline:     time.Duration(writeDeadlineSecs) * time.Second * 2, // Doubled to prevent premature timeout
+		Port:               port,
+		ServerURL:          getEnv("SERVER_URL", "http://localhost:8080"),
+		LogLevel:           strings.ToLower(*logLevel),
+		Env:                strings.ToLower(*env),
+		KeepalivePingInt:   time.Duration(pingIntSecs) * time.Second,
+		KeepalivePongWait:  time.Duration(pongWaitSecs) * time.Second,
+		WriteDeadline:      time.Duration(writeDeadlineSecs) * time.Second * 2, // Doubled to prevent premature timeout
+		SpeakerInterval:    time.Duration(speakerIntervalMillis) * time.Millisecond,
+		SessionGracePeriod: time.Duration(sessionGraceSecs) * time.Second,
+		RateLimitDefault:   rateLimitDefaultPerMin,
+		RateLimitTokens:    rateLimitTokensPerMin,
+		RateLimitRooms:     rateLimitRoomsPerMin,
+		RateLimitAPI:       rateLimitAPIPerMin,
+		RateLimitFlush:     time.Duration(rateLimitFlushSecs) * time.Second,
+		MeteringFlush:      time.Duration(meteringFlushSecs) * time.Second,
+		AuditFlush:         time.Duration(auditFlushSecs) * time.Second,
+		WebhookTimeout:     time.Duration(webhookTimeoutSecs) * time.Second,
+		WebhookAttempts:    webhookMaxAttempts,
+		TrustedProxies:     *trustedProxies,
 	}
 }
 
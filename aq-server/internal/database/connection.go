@@ -78,11 +78,15 @@ func runMigrations(logger logging.LeveledLogger) error {
 	err := DB.AutoMigrate(
 		&Company{},
 		&Token{},
+		&SigningKey{},
 		&Room{},
 		&Session{},
 		&APIKey{},
 		&AuditLog{},
 		&RateLimitTracker{},
+		&Analytics{},
+		&Webhook{},
+		&WebhookDelivery{},
 	)
 
 	if err != nil {
@@ -96,10 +100,65 @@ func runMigrations(logger logging.LeveledLogger) error {
 		}
 	}
 
+	// Move plaintext API keys of companies to the api_keys table
+	migrated, err := migrateLegacyAPIKeys()
+	if err != nil {
+		return fmt.Errorf("api key migration failed: %w", err)
+	}
+	if migrated > 0 {
+		logger.Infof("Moved %d legacy API keys to api_keys", migrated)
+	}
+
+	// Usage used to be tracked per API key only, room tokens have no api_keys row
+	if DB.Migrator().HasColumn(&RateLimitTracker{}, "api_key_id") {
+		if err := migrateRateLimitCredentials(); err != nil {
+			return fmt.Errorf("rate limit tracker migration failed: %w", err)
+		}
+		logger.Infof("Moved rate limit usage from api_key_id to credential_id")
+	}
+
 	logger.Infof("✅ Database migrations completed successfully")
 	return nil
 }
 
+// migrateLegacyAPIKeys stores the hash of every company's plaintext API key in the
+// api_keys table, where it keeps working, and clears the plaintext key
+func migrateLegacyAPIKeys() (int, error) {
+	migrated := 0
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var companies []Company
+		if err := tx.Where("api_key IS NOT NULL AND api_key <> ''").Find(&companies).Error; err != nil {
+			return err
+		}
+
+		for _, company := range companies {
+			key := NewAPIKey(company.ID, "Legacy API key", *company.LegacyAPIKey)
+			if err := tx.Where(APIKey{APIKeyHash: key.APIKeyHash}).FirstOrCreate(key).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&Company{}).Where("id = ?", company.ID).Update("api_key", nil).Error; err != nil {
+				return err
+			}
+			migrated++
+		}
+		return nil
+	})
+	return migrated, err
+}
+
+// migrateRateLimitCredentials moves the API key IDs of tracked usage to credential_id
+// and drops the api_key_id column along with its foreign key
+func migrateRateLimitCredentials() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&RateLimitTracker{}).
+			Where("credential_id = ''").
+			Updates(map[string]any{"credential_type": "api_key", "credential_id": gorm.Expr("api_key_id::text")}).Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(&RateLimitTracker{}, "api_key_id")
+	})
+}
+
 // Close closes the database connection
 func Close() error {
 	if DB != nil {
@@ -1,23 +1,30 @@
 package database
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
 	"time"
 
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Company represents a tenant company
 type Company struct {
-	ID        string    `gorm:"primaryKey;type:varchar(50)"`
-	Name      string    `gorm:"type:varchar(255);not null"`
-	APIKey    string    `gorm:"uniqueIndex;type:varchar(255);not null"`
-	SecretKey string    `gorm:"type:varchar(255);not null"`
-	Tier      string    `gorm:"type:varchar(50);default:'free'"`
-	IsActive  bool      `gorm:"default:true"`
-	CreatedAt time.Time `gorm:"autoCreateTime"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime"`
-	Metadata  datatypes.JSON `gorm:"type:jsonb;default:'{}';serializer:json"`
+	ID   string `gorm:"primaryKey;type:varchar(50)"`
+	Name string `gorm:"type:varchar(255);not null"`
+	// LegacyAPIKey is the plaintext API key companies had before API keys moved to
+	// the api_keys table. Migrations move it there and clear it.
+	LegacyAPIKey *string        `gorm:"column:api_key;uniqueIndex;type:varchar(255)"`
+	SecretKey    string         `gorm:"type:varchar(255);not null"`
+	Tier         string         `gorm:"type:varchar(50);default:'free'"`
+	IsActive     bool           `gorm:"default:true"`
+	CreatedAt    time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt    time.Time      `gorm:"autoUpdateTime"`
+	Metadata     datatypes.JSON `gorm:"type:jsonb;default:'{}';serializer:json"`
 
 	// Relations
 	Tokens   []Token   `gorm:"foreignKey:CompanyID;references:ID;constraint:OnDelete:CASCADE"`
@@ -28,17 +35,34 @@ type Company struct {
 
 // Token represents an access token for room access
 type Token struct {
-	ID          string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	CompanyID   string    `gorm:"index;type:varchar(50);not null"`
-	TokenHash   string    `gorm:"uniqueIndex;type:varchar(255);not null"`
-	RoomID      string    `gorm:"index;type:varchar(255);not null"`
-	UserName    string    `gorm:"type:varchar(255);not null"`
+	ID          string         `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	CompanyID   string         `gorm:"index;type:varchar(50);not null"`
+	TokenHash   string         `gorm:"uniqueIndex;type:varchar(255);not null"`
+	RoomID      string         `gorm:"index;type:varchar(255);not null"`
+	UserName    string         `gorm:"type:varchar(255);not null"`
 	Permissions datatypes.JSON `gorm:"type:jsonb;default:'{\"publish\": true, \"subscribe\": true}';serializer:json"`
-	CreatedAt   time.Time `gorm:"autoCreateTime"`
-	ExpiresAt   time.Time `gorm:"index"`
-	IsUsed      bool      `gorm:"default:false"`
+	CreatedAt   time.Time      `gorm:"autoCreateTime"`
+	ExpiresAt   time.Time      `gorm:"index"`
+	IsUsed      bool           `gorm:"default:false"`
 	UsedAt      *time.Time
-	Revoked     bool      `gorm:"default:false"`
+	SingleUse   bool `gorm:"default:false"`
+	Revoked     bool `gorm:"default:false"`
+
+	// Foreign Key
+	Company *Company `gorm:"foreignKey:CompanyID;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+// SigningKey is the public half of a key pair a company signs its own room tokens
+// with. Its ID is the kid header of the tokens it signs; the private key is never
+// stored.
+type SigningKey struct {
+	ID        string    `gorm:"primaryKey;type:varchar(64)"`
+	CompanyID string    `gorm:"index;type:varchar(50);not null"`
+	Algorithm string    `gorm:"type:varchar(10);not null"` // RS256 or EdDSA
+	PublicKey string    `gorm:"type:text;not null"`        // PEM encoded
+	Active    bool      `gorm:"index;default:true"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	RetiredAt *time.Time
 
 	// Foreign Key
 	Company *Company `gorm:"foreignKey:CompanyID;references:ID;constraint:OnDelete:CASCADE"`
@@ -46,69 +70,174 @@ type Token struct {
 
 // Room represents a video room
 type Room struct {
-	ID              string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	CompanyID       string    `gorm:"index;type:varchar(50);not null"`
-	RoomID          string    `gorm:"index;type:varchar(255);not null"`
-	Name            string    `gorm:"type:varchar(255)"`
-	Description     string    `gorm:"type:text"`
-	MaxParticipants int       `gorm:"default:100"`
-	CreatedAt       time.Time `gorm:"autoCreateTime"`
-	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
+	ID              string         `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	CompanyID       string         `gorm:"index;type:varchar(50);not null"`
+	RoomID          string         `gorm:"index;type:varchar(255);not null"`
+	Name            string         `gorm:"type:varchar(255)"`
+	Description     string         `gorm:"type:text"`
+	MaxParticipants int            `gorm:"default:100"`
+	CreatedAt       time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time      `gorm:"autoUpdateTime"`
 	Metadata        datatypes.JSON `gorm:"type:jsonb;default:'{}';serializer:json"`
 }
 
-// Session represents an active user session
+// Session is a participant's stay in a room, open until it disconnects
 type Session struct {
-	ID             string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	CompanyID      string    `gorm:"index;type:varchar(50);not null"`
-	RoomID         string    `gorm:"index;type:varchar(255);not null"`
-	UserName       string    `gorm:"type:varchar(255);not null"`
-	TokenID        *string   `gorm:"type:uuid"`
-	ConnectedAt    time.Time `gorm:"autoCreateTime;index"`
-	DisconnectedAt *time.Time
-	DurationSeconds int `gorm:"generated:stored"`
-	PeerAddress     string `gorm:"type:varchar(100)"`
+	ID              string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	CompanyID       string    `gorm:"index;type:varchar(50);not null"`
+	RoomID          string    `gorm:"index;type:varchar(255);not null"`
+	UserName        string    `gorm:"type:varchar(255);not null"`
+	TokenID         *string   `gorm:"type:uuid"`
+	ConnectedAt     time.Time `gorm:"autoCreateTime;index"`
+	DisconnectedAt  *time.Time
+	DurationSeconds int            `gorm:"generated:stored"`
+	PeerAddress     string         `gorm:"type:varchar(100)"`
+	UserAgent       string         `gorm:"type:varchar(512)"`
 	Metadata        datatypes.JSON `gorm:"type:jsonb;default:'{}';serializer:json"`
 }
 
-// APIKey represents an API key for rate limiting
+// APIKey is a named API key of a company. Only its hash is stored, and a prefix of
+// the key to tell keys apart.
 type APIKey struct {
 	ID                 string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
 	CompanyID          string    `gorm:"index;type:varchar(50);not null"`
 	APIKeyHash         string    `gorm:"uniqueIndex;type:varchar(255);not null"`
+	Prefix             string    `gorm:"type:varchar(20)"`
 	Name               string    `gorm:"type:varchar(100)"`
 	IsActive           bool      `gorm:"default:true"`
-	RateLimitPerMinute int       `gorm:"default:60"`
+	RateLimitPerMinute int       `gorm:"default:0"` // 0 uses the server's default limit
 	CreatedAt          time.Time `gorm:"autoCreateTime"`
 	UpdatedAt          time.Time `gorm:"autoUpdateTime"`
+	LastUsedAt         *time.Time
+	RevokedAt          *time.Time
+
+	// Foreign Key
+	Company *Company `gorm:"foreignKey:CompanyID;references:ID;constraint:OnDelete:CASCADE"`
 }
 
 // AuditLog represents audit log entries
 type AuditLog struct {
-	ID           string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	CompanyID    *string   `gorm:"index;type:varchar(50)"`
-	EventType    string    `gorm:"index;type:varchar(50);not null"`
-	ActorType    string    `gorm:"type:varchar(50)"`
-	ActorID      string    `gorm:"type:varchar(255)"`
-	ResourceType string    `gorm:"type:varchar(50)"`
-	ResourceID   string    `gorm:"type:varchar(255)"`
-	Action       string    `gorm:"type:varchar(50)"`
-	Status       string    `gorm:"type:varchar(50)"`
+	ID           string         `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	CompanyID    *string        `gorm:"index;type:varchar(50)"`
+	EventType    string         `gorm:"index;type:varchar(50);not null"`
+	ActorType    string         `gorm:"type:varchar(50)"`
+	ActorID      string         `gorm:"type:varchar(255)"`
+	ResourceType string         `gorm:"type:varchar(50)"`
+	ResourceID   string         `gorm:"type:varchar(255)"`
+	Action       string         `gorm:"type:varchar(50)"`
+	Status       string         `gorm:"type:varchar(50)"`
 	Details      datatypes.JSON `gorm:"type:jsonb;default:'{}';serializer:json"`
-	CreatedAt    time.Time `gorm:"autoCreateTime;index"`
+	CreatedAt    time.Time      `gorm:"autoCreateTime;index"`
 }
 
-// RateLimitTracker tracks API usage
+// RateLimitTracker tracks API usage of an API key or a room token
 type RateLimitTracker struct {
-	ID         string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	CompanyID  string    `gorm:"index;type:varchar(50);not null"`
-	APIKeyID   string    `gorm:"index;type:uuid;not null"`
-	Endpoint   string    `gorm:"type:varchar(100)"`
-	RequestCount int     `gorm:"default:1"`
-	WindowStart time.Time `gorm:"index"`
-	WindowEnd   time.Time `gorm:"index"`
+	ID             string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	CompanyID      string    `gorm:"index;type:varchar(50);not null"`
+	CredentialType string    `gorm:"type:varchar(20);not null;default:'api_key'"`
+	CredentialID   string    `gorm:"index;type:varchar(255);not null;default:''"`
+	Endpoint       string    `gorm:"type:varchar(100)"`
+	RequestCount   int       `gorm:"default:1"`
+	WindowStart    time.Time `gorm:"index"`
+	WindowEnd      time.Time `gorm:"index"`
+}
+
+// Metrics metered per company, room and day
+const (
+	MetricParticipantSeconds = "participant_seconds"
+	MetricTrackSeconds       = "published_track_seconds"
+	MetricForwardedBytes     = "forwarded_bytes"
+)
+
+// Analytics is a usage metric of a company's room on a day. Rows are added to as
+// usage is metered, so a day's row is final once the day is over.
+type Analytics struct {
+	ID         string         `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	CompanyID  string         `gorm:"uniqueIndex:idx_analytics_metric;type:varchar(50);not null"`
+	RoomID     string         `gorm:"uniqueIndex:idx_analytics_metric;type:varchar(255);not null"`
+	MetricType string         `gorm:"uniqueIndex:idx_analytics_metric;type:varchar(50);not null"`
+	MetricDate time.Time      `gorm:"uniqueIndex:idx_analytics_metric;type:date;not null;index"`
+	Value      int64          `gorm:"default:0"`
+	Metadata   datatypes.JSON `gorm:"type:jsonb;default:'{}';serializer:json"`
+	CreatedAt  time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt  time.Time      `gorm:"autoUpdateTime"`
+
+	// Foreign Key
+	Company *Company `gorm:"foreignKey:CompanyID;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+// TableName keeps GORM from naming the table after a plural of Analytics
+func (Analytics) TableName() string {
+	return "analytics"
+}
+
+// Webhook is an endpoint a company is notified at of events in its rooms. Payloads
+// are signed with the secret.
+type Webhook struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	CompanyID string    `gorm:"index;type:varchar(50);not null"`
+	URL       string    `gorm:"type:varchar(2048);not null"`
+	Secret    string    `gorm:"type:varchar(100);not null"`
+	Events    string    `gorm:"type:text"` // Comma-separated event types, empty for all events
+	IsActive  bool      `gorm:"default:true"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+
+	// Foreign Key
+	Company *Company `gorm:"foreignKey:CompanyID;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+// EventTypes returns the event types the webhook is notified of, nil for all events
+func (w *Webhook) EventTypes() []string {
+	if w.Events == "" {
+		return nil
+	}
+	return strings.Split(w.Events, ",")
+}
+
+// Subscribes reports whether the webhook is notified of an event type
+func (w *Webhook) Subscribes(eventType string) bool {
+	if w.Events == "" {
+		return true
+	}
+	for _, subscribed := range w.EventTypes() {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
 }
 
+// WebhookDelivery is an event to deliver to a webhook, and the log of its attempts.
+// Pending deliveries are retried until they succeed or run out of attempts.
+type WebhookDelivery struct {
+	ID             string         `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	WebhookID      string         `gorm:"index;type:uuid;not null"`
+	CompanyID      string         `gorm:"index;type:varchar(50);not null"`
+	EventID        string         `gorm:"type:varchar(50);not null"`
+	EventType      string         `gorm:"type:varchar(50);not null"`
+	Payload        datatypes.JSON `gorm:"type:jsonb;not null"`
+	Status         string         `gorm:"index:idx_webhook_deliveries_due;type:varchar(20);not null"`
+	Attempts       int            `gorm:"default:0"`
+	NextAttemptAt  time.Time      `gorm:"index:idx_webhook_deliveries_due"`
+	ResponseStatus int            // HTTP status of the last attempt, 0 if it got no response
+	LastError      string         `gorm:"type:varchar(1024)"`
+	DurationMs     int64          // How long the last attempt took
+	CreatedAt      time.Time      `gorm:"autoCreateTime;index"`
+	UpdatedAt      time.Time      `gorm:"autoUpdateTime"`
+	DeliveredAt    *time.Time
+
+	// Foreign Key
+	Webhook *Webhook `gorm:"foreignKey:WebhookID;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+// Statuses of webhook deliveries
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
 // Legacy models for backward compatibility (kept for reference)
 // These are replaced by GORM models above
 
@@ -124,17 +253,105 @@ type TokenRequest struct {
 	Used      bool      `db:"used"`
 }
 
-// GetCompanyByAPIKey retrieves company by API key
-func GetCompanyByAPIKey(apiKey string) (*Company, error) {
-	company := &Company{}
-	result := DB.Where("api_key = ?", apiKey).First(company)
+// ErrLastAPIKey is returned when revoking the only active API key of a company
+var ErrLastAPIKey = errors.New("cannot revoke the last active api key")
+
+// apiKeyPrefixLength is how much of an API key is kept to identify it
+const apiKeyPrefixLength = 11
+
+// lastUsedInterval is how often the last use of an API key is recorded
+const lastUsedInterval = time.Minute
+
+// HashAPIKey creates a SHA256 hash of an API key for storage
+func HashAPIKey(apiKey string) string {
+	hash := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(hash[:])
+}
+
+// APIKeyPrefix returns the start of an API key that is stored to identify it
+func APIKeyPrefix(apiKey string) string {
+	if len(apiKey) > apiKeyPrefixLength {
+		return apiKey[:apiKeyPrefixLength]
+	}
+	return apiKey
+}
+
+// NewAPIKey returns an active API key of a company for storage
+func NewAPIKey(companyID, name, apiKey string) *APIKey {
+	return &APIKey{
+		CompanyID:  companyID,
+		APIKeyHash: HashAPIKey(apiKey),
+		Prefix:     APIKeyPrefix(apiKey),
+		Name:       name,
+		IsActive:   true,
+	}
+}
+
+// CreateAPIKey stores a new API key
+func CreateAPIKey(key *APIKey) error {
+	return DB.Create(key).Error
+}
+
+// GetAPIKey retrieves an active API key with its company, or nil if there is none.
+// It records when the key was last used, at most once a minute.
+func GetAPIKey(apiKey string) (*APIKey, error) {
+	key := &APIKey{}
+	result := DB.Preload("Company").Where("api_key_hash = ? AND is_active = ?", HashAPIKey(apiKey), true).First(key)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			return nil, nil
 		}
 		return nil, result.Error
 	}
-	return company, nil
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedInterval {
+		if err := DB.Model(&APIKey{}).Where("id = ?", key.ID).Update("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+		key.LastUsedAt = &now
+	}
+
+	return key, nil
+}
+
+// ListAPIKeys lists the API keys of a company, newest first
+func ListAPIKeys(companyID string) ([]APIKey, error) {
+	keys := []APIKey{}
+	result := DB.Where("company_id = ?", companyID).Order("created_at DESC").Find(&keys)
+	return keys, result.Error
+}
+
+// RevokeAPIKey deactivates an API key of a company. It reports false if the company
+// has no such active key and returns ErrLastAPIKey instead of revoking its only one.
+func RevokeAPIKey(companyID, keyID string) (bool, error) {
+	revoked := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var active []string
+		if err := tx.Model(&APIKey{}).
+			Where("company_id = ? AND is_active = ?", companyID, true).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Pluck("id", &active).Error; err != nil {
+			return err
+		}
+
+		found := false
+		for _, id := range active {
+			found = found || id == keyID
+		}
+		if !found {
+			return nil
+		}
+		if len(active) == 1 {
+			return ErrLastAPIKey
+		}
+
+		revoked = true
+		return tx.Model(&APIKey{}).
+			Where("id = ?", keyID).
+			Updates(map[string]interface{}{"is_active": false, "revoked_at": time.Now()}).Error
+	})
+	return revoked, err
 }
 
 // GetCompanyByID retrieves company by company ID
@@ -168,9 +385,195 @@ func GetToken(tokenHash string) (*Token, error) {
 	return token, nil
 }
 
-// MarkTokenUsed marks a token as used
-func MarkTokenUsed(tokenHash string) error {
-	return DB.Model(&Token{}).Where("token_hash = ?", tokenHash).Update("is_used", true).Update("used_at", time.Now()).Error
+// MarkTokenUsed marks a token as used. It reports false if the token was already
+// used, so that only one caller can use a single-use token.
+func MarkTokenUsed(tokenID string) (bool, error) {
+	result := DB.Model(&Token{}).
+		Where("id = ? AND is_used = ?", tokenID, false).
+		Updates(map[string]interface{}{"is_used": true, "used_at": time.Now()})
+	return result.RowsAffected == 1, result.Error
+}
+
+// TokenFilter selects tokens of a company. Empty fields match any token.
+type TokenFilter struct {
+	ID       string
+	RoomID   string
+	UserName string
+}
+
+// RevokeTokens revokes the company's tokens that match the filter and returns the
+// IDs of the tokens it revoked
+func RevokeTokens(companyID string, filter TokenFilter) ([]string, error) {
+	ids := []string{}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&Token{}).Where("company_id = ? AND revoked = ?", companyID, false)
+		if filter.ID != "" {
+			query = query.Where("id = ?", filter.ID)
+		}
+		if filter.RoomID != "" {
+			query = query.Where("room_id = ?", filter.RoomID)
+		}
+		if filter.UserName != "" {
+			query = query.Where("user_name = ?", filter.UserName)
+		}
+
+		if err := query.Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		return tx.Model(&Token{}).Where("id IN ?", ids).Update("revoked", true).Error
+	})
+
+	return ids, err
+}
+
+// GetRoom retrieves a company's room by its room ID, or nil if it isn't configured
+func GetRoom(companyID, roomID string) (*Room, error) {
+	room := &Room{}
+	result := DB.Where("company_id = ? AND room_id = ?", companyID, roomID).First(room)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return room, nil
+}
+
+// CreateSigningKey stores a new signing key
+func CreateSigningKey(key *SigningKey) error {
+	return DB.Create(key).Error
+}
+
+// GetSigningKey retrieves a signing key by ID
+func GetSigningKey(keyID string) (*SigningKey, error) {
+	key := &SigningKey{}
+	result := DB.Where("id = ?", keyID).First(key)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return key, nil
+}
+
+// ListSigningKeys lists the signing keys of a company, newest first
+func ListSigningKeys(companyID string) ([]SigningKey, error) {
+	keys := []SigningKey{}
+	result := DB.Where("company_id = ?", companyID).Order("created_at DESC").Find(&keys)
+	return keys, result.Error
+}
+
+// ListActiveSigningKeys lists the active signing keys of all companies
+func ListActiveSigningKeys() ([]SigningKey, error) {
+	keys := []SigningKey{}
+	result := DB.Where("active = ?", true).Order("created_at DESC").Find(&keys)
+	return keys, result.Error
+}
+
+// RetireSigningKey deactivates a company's signing key so tokens it signed no
+// longer validate. It reports false if the company has no such active key.
+func RetireSigningKey(companyID, keyID string) (bool, error) {
+	result := DB.Model(&SigningKey{}).
+		Where("id = ? AND company_id = ? AND active = ?", keyID, companyID, true).
+		Updates(map[string]interface{}{"active": false, "retired_at": time.Now()})
+	return result.RowsAffected == 1, result.Error
+}
+
+// RecordRateLimitUsage stores the API requests counted over windows
+func RecordRateLimitUsage(usage []RateLimitTracker) error {
+	if len(usage) == 0 {
+		return nil
+	}
+	return DB.Create(&usage).Error
+}
+
+// RecordUsage adds metered usage to the daily metrics of the rooms it was metered in
+func RecordUsage(metrics []Analytics) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	return DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "company_id"}, {Name: "room_id"}, {Name: "metric_type"}, {Name: "metric_date"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"value":      gorm.Expr("analytics.value + excluded.value"),
+			"updated_at": gorm.Expr("excluded.updated_at"),
+		}),
+	}).Create(&metrics).Error
+}
+
+// ListUsage returns a company's daily metrics from one day to another, both included,
+// optionally of a single room
+func ListUsage(companyID, roomID string, from, to time.Time) ([]Analytics, error) {
+	query := DB.Where("company_id = ? AND metric_date BETWEEN ? AND ?", companyID, from, to)
+	if roomID != "" {
+		query = query.Where("room_id = ?", roomID)
+	}
+
+	var metrics []Analytics
+	result := query.Order("metric_date, room_id, metric_type").Find(&metrics)
+	return metrics, result.Error
+}
+
+// CreateAuditLogs stores a batch of audit log entries
+func CreateAuditLogs(entries []AuditLog) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return DB.Create(&entries).Error
+}
+
+// AuditLogFilter selects a company's audit log entries. Empty fields don't filter.
+type AuditLogFilter struct {
+	CompanyID    string
+	EventType    string
+	ActorType    string
+	ActorID      string
+	ResourceType string
+	ResourceID   string
+	Status       string
+	From         *time.Time
+	To           *time.Time
+	Limit        int
+	Offset       int
+}
+
+// ListAuditLogs returns a page of the audit log entries matching a filter, most
+// recent first, and how many entries match in total
+func ListAuditLogs(filter AuditLogFilter) ([]AuditLog, int64, error) {
+	query := DB.Model(&AuditLog{}).Where("company_id = ?", filter.CompanyID)
+	for column, value := range map[string]string{
+		"event_type":    filter.EventType,
+		"actor_type":    filter.ActorType,
+		"actor_id":      filter.ActorID,
+		"resource_type": filter.ResourceType,
+		"resource_id":   filter.ResourceID,
+		"status":        filter.Status,
+	} {
+		if value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at <= ?", *filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []AuditLog
+	result := query.Order("created_at DESC, id").Limit(filter.Limit).Offset(filter.Offset).Find(&entries)
+	return entries, total, result.Error
 }
 
 // CreateSession creates a new session record
@@ -178,11 +581,65 @@ func CreateSession(session *Session) error {
 	return DB.Create(session).Error
 }
 
-// CloseSession closes a session
-func CloseSession(companyID, roomID, userName string) error {
+// CloseSession closes an open session, recording how long it lasted
+func CloseSession(id string) error {
+	now := time.Now()
 	return DB.Model(&Session{}).
-		Where("company_id = ? AND room_id = ? AND user_name = ? AND disconnected_at IS NULL", companyID, roomID, userName).
-		Update("disconnected_at", time.Now()).Error
+		Where("id = ? AND disconnected_at IS NULL", id).
+		Updates(map[string]interface{}{
+			"disconnected_at":  now,
+			"duration_seconds": gorm.Expr("EXTRACT(EPOCH FROM (? - connected_at))::int", now),
+		}).Error
+}
+
+// CloseOrphanedSessions closes the sessions left open by a server that stopped without
+// closing them. Run at startup, before any participant joins.
+func CloseOrphanedSessions() (int64, error) {
+	now := time.Now()
+	result := DB.Model(&Session{}).
+		Where("disconnected_at IS NULL").
+		Updates(map[string]interface{}{
+			"disconnected_at":  now,
+			"duration_seconds": gorm.Expr("EXTRACT(EPOCH FROM (? - connected_at))::int", now),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// SessionFilter selects a company's sessions. Empty fields don't filter; From and To
+// select the sessions that were open at some point between them.
+type SessionFilter struct {
+	CompanyID string
+	RoomID    string
+	UserName  string
+	From      *time.Time
+	To        *time.Time
+	Active    bool // Only sessions that are still open
+	Limit     int
+	Offset    int
+}
+
+// ListSessions returns the sessions matching a filter, most recent first
+func ListSessions(filter SessionFilter) ([]Session, error) {
+	query := DB.Where("company_id = ?", filter.CompanyID)
+	if filter.RoomID != "" {
+		query = query.Where("room_id = ?", filter.RoomID)
+	}
+	if filter.UserName != "" {
+		query = query.Where("user_name = ?", filter.UserName)
+	}
+	if filter.From != nil {
+		query = query.Where("(disconnected_at IS NULL OR disconnected_at >= ?)", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("connected_at <= ?", *filter.To)
+	}
+	if filter.Active {
+		query = query.Where("disconnected_at IS NULL")
+	}
+
+	var sessions []Session
+	result := query.Order("connected_at DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&sessions)
+	return sessions, result.Error
 }
 
 // GetActiveSessionCount returns the number of active sessions in a room
@@ -193,3 +650,93 @@ func GetActiveSessionCount(companyID, roomID string) (int64, error) {
 		Count(&count)
 	return count, result.Error
 }
+
+// CreateWebhook stores a new webhook
+func CreateWebhook(webhook *Webhook) error {
+	return DB.Create(webhook).Error
+}
+
+// GetWebhook retrieves a company's webhook, or nil if there is none
+func GetWebhook(companyID, webhookID string) (*Webhook, error) {
+	webhook := &Webhook{}
+	result := DB.Where("id = ? AND company_id = ?", webhookID, companyID).First(webhook)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return webhook, nil
+}
+
+// ListWebhooks returns a company's webhooks, oldest first
+func ListWebhooks(companyID string) ([]Webhook, error) {
+	var webhooks []Webhook
+	result := DB.Where("company_id = ?", companyID).Order("created_at").Find(&webhooks)
+	return webhooks, result.Error
+}
+
+// ListActiveWebhooks returns a company's active webhooks
+func ListActiveWebhooks(companyID string) ([]Webhook, error) {
+	var webhooks []Webhook
+	result := DB.Where("company_id = ? AND is_active = ?", companyID, true).Find(&webhooks)
+	return webhooks, result.Error
+}
+
+// UpdateWebhook saves a changed webhook
+func UpdateWebhook(webhook *Webhook) error {
+	return DB.Save(webhook).Error
+}
+
+// DeleteWebhook deletes a company's webhook with its deliveries, reporting whether
+// there was one
+func DeleteWebhook(companyID, webhookID string) (bool, error) {
+	result := DB.Where("id = ? AND company_id = ?", webhookID, companyID).Delete(&Webhook{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// CreateWebhookDeliveries stores deliveries
+func CreateWebhookDeliveries(deliveries []WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return DB.Create(&deliveries).Error
+}
+
+// ListDueWebhookDeliveries returns up to limit pending deliveries to active webhooks
+// whose next attempt is due, with their webhooks, oldest first
+func ListDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	result := DB.Joins("Webhook").
+		Where("webhook_deliveries.status = ? AND webhook_deliveries.next_attempt_at <= ?", DeliveryPending, now).
+		Where(`"Webhook".is_active = ?`, true).
+		Order("webhook_deliveries.next_attempt_at").
+		Limit(limit).
+		Find(&deliveries)
+	return deliveries, result.Error
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt to deliver
+func UpdateWebhookDelivery(delivery *WebhookDelivery) error {
+	return DB.Model(&WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(map[string]interface{}{
+		"status":          delivery.Status,
+		"attempts":        delivery.Attempts,
+		"next_attempt_at": delivery.NextAttemptAt,
+		"response_status": delivery.ResponseStatus,
+		"last_error":      delivery.LastError,
+		"duration_ms":     delivery.DurationMs,
+		"delivered_at":    delivery.DeliveredAt,
+	}).Error
+}
+
+// ListWebhookDeliveries returns a page of a webhook's deliveries, most recent first
+func ListWebhookDeliveries(companyID, webhookID, status string, limit, offset int) ([]WebhookDelivery, error) {
+	query := DB.Where("company_id = ? AND webhook_id = ?", companyID, webhookID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var deliveries []WebhookDelivery
+	result := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&deliveries)
+	return deliveries, result.Error
+}
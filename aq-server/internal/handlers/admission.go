@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"aq-server/internal/auth"
+	"aq-server/internal/database"
+	"aq-server/internal/quota"
+	"aq-server/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// Admission looks up the limits participants join rooms within
+type Admission struct {
+	// Company looks up the company of a token, whose tier sets its limits
+	Company func(companyID string) (*database.Company, error)
+
+	// Room looks up a configured room, nil if the room isn't configured
+	Room func(companyID, roomID string) (*database.Room, error)
+}
+
+// NewAdmission creates an admission backed by the database
+func NewAdmission() *Admission {
+	return &Admission{
+		Company: database.GetCompanyByID,
+		Room:    database.GetRoom,
+	}
+}
+
+// limits returns the limits of the token's company tier and describes its join
+func (a *Admission) limits(claims *auth.Claims) (quota.Limits, quota.Join, error) {
+	join := quota.Join{RoomID: claims.RoomID, Publisher: claims.Permissions.Publish}
+
+	company, err := a.Company(claims.CompanyID)
+	if err != nil {
+		return quota.Limits{}, join, fmt.Errorf("failed to look up company: %w", err)
+	}
+	if company == nil {
+		return quota.Limits{}, join, auth.ErrUnknownCompany
+	}
+
+	room, err := a.Room(claims.CompanyID, claims.RoomID)
+	if err != nil {
+		return quota.Limits{}, join, fmt.Errorf("failed to look up room: %w", err)
+	}
+	if room != nil {
+		join.RoomCapacity = room.MaxParticipants
+	}
+
+	return quota.ForTier(company.Tier), join, nil
+}
+
+// join adds a peer to the engine and its room if its company's limits and the room's
+// capacity allow, calling beforeJoin, if not nil, once they do. Joins are serialized
+// so that concurrent ones can't exceed a limit together. It returns the limits the
+// peer joined within.
+func (h *Handler) join(peer *types.PeerConnectionState, claims *auth.Claims, beforeJoin func() error) (quota.Limits, error) {
+	limits, join, err := h.Admission.limits(claims)
+	if err != nil {
+		return limits, err
+	}
+
+	// WHEP viewers publish nothing whatever their token allows
+	join.Publisher = peer.Permissions.Publish
+
+	h.admissionLock.Lock()
+	defer h.admissionLock.Unlock()
+
+	if err := limits.Admit(h.Engine.Usage(claims.CompanyID), join); err != nil {
+		return limits, err
+	}
+
+	if beforeJoin != nil {
+		if err := beforeJoin(); err != nil {
+			return limits, err
+		}
+	}
+
+	h.Engine.Join(peer)
+
+	return limits, nil
+}
+
+// limitSession ends a session once it lasted the maximum session length, if any
+func (h *Handler) limitSession(s *session, maxLength time.Duration) {
+	if maxLength <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	s.deadline = time.AfterFunc(maxLength, func() {
+		h.kick(s, websocket.ClosePolicyViolation, "session length limit reached")
+	})
+	s.mu.Unlock()
+}
+
+// Usage reports what a company uses of the engine right now
+func (h *Handler) Usage(companyID string) quota.Usage {
+	return h.Engine.Usage(companyID)
+}
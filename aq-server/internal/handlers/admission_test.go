@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"aq-server/internal/auth"
+	"aq-server/internal/database"
+	"aq-server/internal/quota"
+	"aq-server/internal/signaling"
+	"aq-server/internal/types"
+)
+
+// newTestAdmission admits the participants of a company of the tier into rooms of
+// the given capacity
+func newTestAdmission(tier string, capacity int) *Admission {
+	return &Admission{
+		Company: func(companyID string) (*database.Company, error) {
+			return &database.Company{ID: companyID, Tier: tier, IsActive: true}, nil
+		},
+		Room: func(companyID, roomID string) (*database.Room, error) {
+			if capacity == 0 {
+				return nil, nil
+			}
+			return &database.Room{CompanyID: companyID, RoomID: roomID, MaxParticipants: capacity}, nil
+		},
+	}
+}
+
+func TestJoinRoomCapacity(t *testing.T) {
+	h := newTestHandler(t)
+	h.Admission = newTestAdmission("enterprise", 1)
+
+	claims := &auth.Claims{CompanyID: "acme", RoomID: "lobby", UserName: "alice"}
+	first := newPeerState(newTestPeerConnection(t), claims)
+	if _, err := h.join(first, claims, nil); err != nil {
+		t.Fatalf("Expected the first participant to join, got %v", err)
+	}
+
+	called := false
+	second := newPeerState(newTestPeerConnection(t), claims)
+	_, err := h.join(second, claims, func() error {
+		called = true
+		return nil
+	})
+	if called {
+		t.Error("Expected a rejected join not to use up its token")
+	}
+
+	var limitErr *quota.LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != quota.LimitRoomCapacity {
+		t.Fatalf("Expected the room capacity to be exceeded, got %v", err)
+	}
+	if participants := h.Engine.Usage("acme").Participants; participants != 1 {
+		t.Errorf("Expected 1 participant in the engine, got %d", participants)
+	}
+
+	response := signalingError(err)
+	if response.Code != signaling.ErrorRoomFull || response.Limit != quota.LimitRoomCapacity || response.Max != 1 {
+		t.Errorf("Expected a room_full error with a maximum of 1, got %+v", response)
+	}
+}
+
+func TestJoinTierLimits(t *testing.T) {
+	h := newTestHandler(t)
+	h.Admission = newTestAdmission("free", 0)
+	limits := quota.ForTier("free")
+
+	publisher := &auth.Claims{CompanyID: "acme", RoomID: "lobby", UserName: "obs", Permissions: types.Permissions{Publish: true}}
+	for i := 0; i < limits.MaxPublishers; i++ {
+		peer := newPeerState(newTestPeerConnection(t), publisher)
+		joined, err := h.join(peer, publisher, nil)
+		if err != nil {
+			t.Fatalf("Expected publisher %d to join, got %v", i+1, err)
+		}
+		if joined.MaxSessionLength != limits.MaxSessionLength {
+			t.Errorf("Expected the free session length, got %v", joined.MaxSessionLength)
+		}
+	}
+
+	_, err := h.join(newPeerState(newTestPeerConnection(t), publisher), publisher, nil)
+	response := signalingError(err)
+	if response.Code != signaling.ErrorQuotaExceeded || response.Limit != quota.LimitPublishers {
+		t.Errorf("Expected the publisher quota to be exceeded, got %+v", response)
+	}
+
+	// Viewers don't count against the publisher limit, WHEP viewers neither
+	viewer := newPeerState(newTestPeerConnection(t), publisher)
+	viewer.Permissions.Publish = false
+	if _, err := h.join(viewer, publisher, nil); err != nil {
+		t.Errorf("Expected a viewer to join, got %v", err)
+	}
+}
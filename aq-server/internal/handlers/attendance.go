@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"aq-server/internal/database"
+	"aq-server/internal/types"
+
+	"github.com/google/uuid"
+)
+
+// maxUserAgent is the longest user agent stored with a session
+const maxUserAgent = 512
+
+// Attendance records participants' stays in rooms in the sessions table
+type Attendance struct {
+	Open  func(session *database.Session) error
+	Close func(id string) error
+}
+
+// NewAttendance creates an attendance backed by the database
+func NewAttendance() *Attendance {
+	return &Attendance{
+		Open:  database.CreateSession,
+		Close: database.CloseSession,
+	}
+}
+
+// openRecord records that a peer joined its room from the client of r. A peer whose
+// record can't be written still joins, it's only missing from the history.
+func (h *Handler) openRecord(peer *types.PeerConnectionState, r *http.Request) {
+	record := &database.Session{
+		ID:          uuid.NewString(),
+		CompanyID:   peer.CompanyID,
+		RoomID:      peer.RoomID,
+		UserName:    peer.Username,
+		PeerAddress: h.Audit.ClientAddress(r),
+		UserAgent:   truncateUTF8(r.UserAgent(), maxUserAgent),
+	}
+	if peer.TokenID != "" {
+		record.TokenID = &peer.TokenID
+	}
+
+	if err := h.Attendance.Open(record); err != nil {
+		h.Logger.Errorf("Failed to record session of %s in room %s: %v", peer.Username, peer.RoomID, err)
+		return
+	}
+
+	peer.RecordID = record.ID
+}
+
+// closeRecord records that a peer left its room
+func (h *Handler) closeRecord(peer *types.PeerConnectionState) {
+	if peer.RecordID == "" {
+		return
+	}
+
+	if err := h.Attendance.Close(peer.RecordID); err != nil {
+		h.Logger.Errorf("Failed to close session record %s: %v", peer.RecordID, err)
+	}
+}
+
+// truncateUTF8 cuts s to at most max bytes without splitting a character, replacing
+// invalid UTF-8 first, which the database would refuse
+func truncateUTF8(s string, max int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if len(s) <= max {
+		return s
+	}
+
+	end := max
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end]
+}
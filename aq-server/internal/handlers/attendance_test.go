@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"unicode/utf8"
+
+	"aq-server/internal/database"
+	"aq-server/internal/types"
+)
+
+func TestAttendanceShutdown(t *testing.T) {
+	h := newTestHandler(t)
+
+	opened := map[string]*database.Session{}
+	closed := map[string]bool{}
+	h.Attendance = &Attendance{
+		Open: func(session *database.Session) error {
+			opened[session.ID] = session
+			return nil
+		},
+		Close: func(id string) error {
+			closed[id] = true
+			return nil
+		},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set("User-Agent", "test-client/1.0")
+	r.RemoteAddr = "203.0.113.7:51234"
+
+	serverConn, _ := newTestWebsocket(t)
+	peer := &types.PeerConnectionState{
+		PeerConnection: newTestPeerConnection(t),
+		Websocket:      types.NewThreadSafeWriter(serverConn, 1),
+		Username:       "alice",
+		RoomID:         "lobby",
+		CompanyID:      "acme",
+		TokenID:        "token-1",
+	}
+	h.Engine.Join(peer)
+	h.openRecord(peer, r)
+	h.newSession(peer)
+
+	record, ok := opened[peer.RecordID]
+	if !ok {
+		t.Fatalf("Expected a session record for the peer, got %v", opened)
+	}
+	if record.PeerAddress != "203.0.113.7" || record.UserAgent != "test-client/1.0" {
+		t.Errorf("Expected the client's address and user agent, got %q and %q", record.PeerAddress, record.UserAgent)
+	}
+	if record.TokenID == nil || *record.TokenID != "token-1" {
+		t.Errorf("Expected the token ID to be recorded, got %v", record.TokenID)
+	}
+
+	h.Shutdown()
+
+	if !closed[peer.RecordID] {
+		t.Error("Expected the session record to be closed on shutdown")
+	}
+	if count := h.Engine.PeerCount(); count != 0 {
+		t.Errorf("Expected no peers after shutdown, got %d", count)
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		in       string
+		max      int
+		expected string
+	}{
+		{"test-client/1.0", 512, "test-client/1.0"},
+		{"abcdef", 3, "abc"},
+		{"aé", 2, "a"}, // é is 2 bytes, cutting after 2 would split it
+		{"日本", 5, "日"},
+		{"bad\xffbyte", 512, "bad\uFFFDbyte"},
+	}
+
+	for _, tt := range tests {
+		if truncated := truncateUTF8(tt.in, tt.max); truncated != tt.expected || !utf8.ValidString(truncated) {
+			t.Errorf("Expected %q cut to %d bytes to be %q, got %q", tt.in, tt.max, tt.expected, truncated)
+		}
+	}
+}
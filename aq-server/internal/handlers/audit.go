@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"aq-server/internal/audit"
+	"aq-server/internal/signaling"
+	"aq-server/internal/types"
+)
+
+// recordModeration records a host muting another participant's tracks, including
+// attempts that weren't allowed
+func (h *Handler) recordModeration(peer *types.PeerConnectionState, request signaling.MuteRequest, err error) {
+	status := audit.StatusSuccess
+	details := map[string]any{
+		"room_id":  peer.RoomID,
+		"track_id": request.TrackID,
+	}
+	if err != nil {
+		status = audit.StatusFailure
+		details["error"] = err.Error()
+	}
+
+	h.Audit.Record(audit.Entry{
+		CompanyID:    peer.CompanyID,
+		EventType:    audit.EventParticipantMuted,
+		ActorType:    audit.ActorParticipant,
+		ActorID:      peer.Username,
+		ResourceType: "participant",
+		ResourceID:   request.Participant,
+		Status:       status,
+		Details:      details,
+	})
+}
@@ -1,18 +1,20 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"sync"
 	"time"
 
+	"aq-server/internal/audit"
+	"aq-server/internal/auth"
 	"aq-server/internal/keepalive"
-	"aq-server/internal/room"
+	"aq-server/internal/quota"
+	"aq-server/internal/sfu"
+	"aq-server/internal/signaling"
 	"aq-server/internal/types"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 	"github.com/pion/logging"
 	"github.com/pion/rtp"
@@ -25,42 +27,42 @@ var (
 	}
 )
 
-// HandlerContext holds all the state needed by the handler
-type HandlerContext struct {
-	Upgrader              websocket.Upgrader
-	Logger                logging.LeveledLogger
-	ListLock              sync.RWMutex
-	PeerConnections       *[]types.PeerConnectionState
-	TrackLocals           *map[string]*webrtc.TrackLocalStaticRTP
-	AddTrack              func(*webrtc.TrackRemote) *webrtc.TrackLocalStaticRTP
-	RemoveTrack           func(*webrtc.TrackLocalStaticRTP)
-	SignalPeerConnections func()
-	BroadcastChat         func(types.ChatMessage, *types.ThreadSafeWriter)
-	KeepaliveConfig       keepalive.Config  // Keepalive configuration
-	RoomManager           *room.RoomManager // New: room management
-}
+// iceDisconnectedTimeout is how long ICE may stay disconnected before it is restarted
+const iceDisconnectedTimeout = 3 * time.Second
 
-var handlerCtx *HandlerContext
+// Handler serves WebSocket signaling for a single SFU engine
+type Handler struct {
+	Upgrader        websocket.Upgrader
+	Logger          logging.LeveledLogger
+	Engine          *sfu.Engine
+	KeepaliveConfig keepalive.Config // Keepalive configuration
+	GracePeriod     time.Duration    // How long a session waits for its client to reconnect
+	Validator       *auth.Validator  // Validates the room tokens clients join with
+	Admission       *Admission       // Looks up the limits participants join rooms within
+	Attendance      *Attendance      // Records who joined which room and when they left
+	Audit           *audit.Recorder  // Records refused joins and moderation, if set
 
-// InitContext initializes the handler context
-func InitContext(ctx *HandlerContext) {
-	handlerCtx = ctx
-}
+	sessions     map[string]*session
+	sessionsLock sync.Mutex
 
-// removePeerConnection safely removes a peer connection from the list
-func removePeerConnection(ws *types.ThreadSafeWriter) {
-	if handlerCtx == nil {
-		return
-	}
+	admissionLock sync.Mutex // Serializes joins, see join
 
-	handlerCtx.ListLock.Lock()
-	defer handlerCtx.ListLock.Unlock()
+	resources     map[string]*types.PeerConnectionState // WHIP and WHEP peers by resource ID
+	resourcesLock sync.Mutex
+}
 
-	for i, pc := range *handlerCtx.PeerConnections {
-		if pc.Websocket == ws {
-			*handlerCtx.PeerConnections = append((*handlerCtx.PeerConnections)[:i], (*handlerCtx.PeerConnections)[i+1:]...)
-			return
-		}
+// NewHandler creates a WebSocket handler bound to the given SFU engine
+func NewHandler(engine *sfu.Engine, logger logging.LeveledLogger, keepaliveCfg keepalive.Config) *Handler {
+	return &Handler{
+		Upgrader:        upgrader,
+		Logger:          logger,
+		Engine:          engine,
+		KeepaliveConfig: keepaliveCfg,
+		Validator:       auth.NewValidator(),
+		Admission:       NewAdmission(),
+		Attendance:      NewAttendance(),
+		sessions:        make(map[string]*session),
+		resources:       make(map[string]*types.PeerConnectionState),
 	}
 }
 
@@ -78,60 +80,35 @@ func isHeaderWritten(w http.ResponseWriter) bool {
 	return false // For websocket upgrades, this is less critical
 }
 
-// TokenClaims represents the JWT token claims
-type TokenClaims struct {
-	UserID   string `json:"user_id"`
-	Email    string `json:"email"`
-	Room     string `json:"room"`
-	UserType string `json:"user_type"` // "host", "guest", "presenter"
-	jwt.RegisteredClaims
-}
-
-// ValidateJWTToken validates and parses a JWT token
-func ValidateJWTToken(tokenString string) (*TokenClaims, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "tt55oo77" // Default for development
-	}
-
-	claims := &TokenClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// Verify the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(jwtSecret), nil
-	})
-
-	if err != nil {
-		return nil, fmt.Errorf("token parse failed: %v", err)
-	}
-
-	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
+// newPeerState describes the peer of a room token's user
+func newPeerState(peerConnection *webrtc.PeerConnection, claims *auth.Claims) *types.PeerConnectionState {
+	return &types.PeerConnectionState{
+		PeerConnection: peerConnection,
+		Username:       claims.UserName,
+		RoomID:         claims.RoomID,
+		UserType:       claims.Role(),
+		CompanyID:      claims.CompanyID,
+		TokenID:        claims.TokenID,
+		Permissions:    claims.Permissions,
 	}
+}
 
-	return claims, nil
+// isTokenPeer reports whether a peer belongs to a room token's user
+func isTokenPeer(peer *types.PeerConnectionState, claims *auth.Claims) bool {
+	return peer.CompanyID == claims.CompanyID && peer.RoomID == claims.RoomID && peer.Username == claims.UserName
 }
 
-// Handle incoming websockets.
-func WebsocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
+// WebsocketHandler handles incoming websockets.
+func (h *Handler) WebsocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
 	defer func() {
 		if err := recover(); err != nil {
-			if handlerCtx != nil {
-				handlerCtx.Logger.Errorf("PANIC in WebSocket handler: %v", err)
-			}
+			h.Logger.Errorf("PANIC in WebSocket handler: %v", err)
 			if !isHeaderWritten(w) {
 				http.Error(w, fmt.Sprintf("Internal server error: %v", err), http.StatusInternalServerError)
 			}
 		}
 	}()
 
-	if handlerCtx == nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		return
-	}
-
 	// Extract and validate JWT token from query parameters
 	tokenString := r.URL.Query().Get("token")
 	if tokenString == "" {
@@ -139,97 +116,165 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
 		return
 	}
 
-	// Validate JWT token
-	claims, err := ValidateJWTToken(tokenString)
+	// Validate JWT token, the room and username come from its claims
+	claims, err := h.Validator.Validate(tokenString)
 	if err != nil {
+		h.Audit.AuthFailure(r, "", audit.ActorToken, err.Error())
 		http.Error(w, fmt.Sprintf("Unauthorized: %v", err), http.StatusUnauthorized)
 		return
 	}
 
-	// Extract room and username from JWT claims
-	roomID := claims.Room
-	if roomID == "" {
-		roomID = "default"
-	}
+	h.Logger.Debugf("Client connecting to room=%s with username=%s (type=%s, company=%s)", claims.RoomID, claims.UserName, claims.Role(), claims.CompanyID)
 
-	username := claims.UserID
-	if username == "" {
-		username = "anonymous"
-	}
+	sessionID := r.URL.Query().Get("session")
 
-	userType := claims.UserType
-	if userType == "" {
-		userType = "guest"
+	// Negotiate the signaling protocol version from the offered subprotocols
+	version, ok := signaling.Negotiate(websocket.Subprotocols(r))
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unsupported signaling protocol, supported: %v", signaling.Subprotocols), http.StatusBadRequest)
+		return
 	}
 
-	handlerCtx.Logger.Debugf("Client connecting to room=%s with username=%s (type=%s)", roomID, username, userType)
-
 	// Upgrade HTTP request to Websocket
-	unsafeConn, err := handlerCtx.Upgrader.Upgrade(w, r, nil)
+	upgrader := h.Upgrader
+	upgrader.Subprotocols = signaling.Subprotocols
+	unsafeConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		handlerCtx.Logger.Errorf("Failed to upgrade HTTP to Websocket: %v", err)
+		h.Logger.Errorf("Failed to upgrade HTTP to Websocket: %v", err)
 		return
 	}
 
-	c := &types.ThreadSafeWriter{Conn: unsafeConn, Mutex: sync.Mutex{}} // nolint
+	// When this frame returns close the Websocket
+	defer unsafeConn.Close() //nolint
+
+	// Reattach to the session the client was in, or join the room
+	sess, generation, resumed := h.resumeSession(sessionID, claims, unsafeConn, version)
+	if !resumed {
+		c := types.NewThreadSafeWriter(unsafeConn, version)
+
+		// A single-use token joins once, later connections may only resume its session.
+		// It's used up once the join was admitted, so requests that fail before, such as
+		// an unsupported subprotocol, a failed upgrade or exceeded limits, don't burn it.
+		peer, limits, err := h.newPeer(c, claims, func() error { return h.Validator.Consume(claims) })
+		if err != nil {
+			h.Logger.Warnf("Peer %s can't join room %s: %v", claims.UserName, claims.RoomID, err)
+			if errors.Is(err, auth.ErrTokenUsed) {
+				h.Audit.AuthFailure(r, claims.CompanyID, audit.ActorToken, err.Error())
+			}
+			h.respond(c, "", err)
+			_ = c.CloseWithReason(websocket.ClosePolicyViolation, err.Error())
+			return
+		}
+
+		h.openRecord(peer, r)
+		sess, generation = h.newSession(peer), 1
+		h.limitSession(sess, limits.MaxSessionLength)
+	}
+
+	// When this frame returns the session waits for the client to reconnect
+	defer h.detach(sess, generation)
+
+	peerConnectionState := sess.peer
+	c := peerConnectionState.Websocket
+
+	if err := c.WriteEvent(signaling.TypeSession, signaling.Session{
+		SessionID:   sess.id,
+		GracePeriod: int(h.GracePeriod / time.Second),
+		Resumed:     resumed,
+		Version:     version,
+	}); err != nil {
+		h.Logger.Errorf("Failed to write session: %v", err)
+	}
+
+	if resumed {
+		h.Logger.Infof("Peer %s resumed session %s in room %s", claims.UserName, sess.id, claims.RoomID)
+		h.Engine.Resume(peerConnectionState)
+	} else {
+		// Signal for the new PeerConnection
+		h.Engine.SignalPeerConnections()
+	}
 
 	// Initialize keepalive monitoring
-	monitor := keepalive.NewMonitor(unsafeConn, handlerCtx.Logger, handlerCtx.KeepaliveConfig)
+	monitor := keepalive.NewMonitor(unsafeConn, h.Logger, h.KeepaliveConfig)
 	monitor.Start()
 	defer monitor.Stop()
 
-	// When this frame returns close the Websocket
-	defer c.Close() //nolint
+	// Monitor connection health in background
+	healthCheckTicker := time.NewTicker(h.KeepaliveConfig.PongWaitTime)
+	defer healthCheckTicker.Stop()
 
-	// Create new PeerConnection
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
-	if err != nil {
-		handlerCtx.Logger.Errorf("Failed to create a PeerConnection: %v", err)
-		return
-	}
+	healthCheckDone := make(chan struct{})
+	defer close(healthCheckDone)
 
-	// When this frame returns close the PeerConnection and remove from list
-	defer func() {
-		if err := peerConnection.Close(); err != nil {
-			handlerCtx.Logger.Errorf("Failed to close PeerConnection: %v", err)
-		}
-		removePeerConnection(c)
-		// Remove from room manager
-		if handlerCtx.RoomManager != nil {
-			handlerCtx.RoomManager.RemovePeer(roomID, c)
+	go func() {
+		for {
+			select {
+			case <-healthCheckDone:
+				return
+			case <-healthCheckTicker.C:
+				// Check if connection is alive
+				if !monitor.IsAlive() {
+					h.Logger.Warnf("Connection health check failed, closing stale connection")
+					unsafeConn.Close()
+					return
+				}
+			}
 		}
-		handlerCtx.SignalPeerConnections()
-	}() //nolint
+	}()
+
+	for {
+		_, raw, err := unsafeConn.ReadMessage()
+		if err != nil {
+			// Check if it's a normal close (user left)
+			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				h.Logger.Infof("Client disconnected normally")
+			} else {
+				h.Logger.Errorf("Failed to read message: %v", err)
+			}
 
-	// Accept one audio and one video track incoming
-	for _, typ := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
-		if _, err := peerConnection.AddTransceiverFromKind(typ, webrtc.RTPTransceiverInit{
-			Direction: webrtc.RTPTransceiverDirectionRecvonly,
-		}); err != nil {
-			handlerCtx.Logger.Errorf("Failed to add transceiver: %v", err)
 			return
 		}
+
+		message, err := signaling.Decode(version, raw)
+		if err != nil {
+			// Skip invalid messages instead of closing connection
+			h.Logger.Errorf("Failed to decode message: %v", err)
+			h.respond(c, "", signaling.NewError(signaling.ErrorBadRequest, "invalid message: %v", err))
+			continue
+		}
+
+		h.respond(c, message.ID, h.handleMessage(peerConnectionState, message))
 	}
+}
 
-	// Add our new PeerConnection to global list
-	peerConnectionState := types.PeerConnectionState{
-		PeerConnection: peerConnection,
-		Websocket:      c,
-		Username:       username,
-		RoomID:         roomID,
-		UserType:       userType,
+// newPeer creates the PeerConnection of a peer joining a room and adds it to the engine
+// if its limits allow, calling beforeJoin as join does. It returns the limits the
+// peer joined within.
+func (h *Handler) newPeer(c *types.ThreadSafeWriter, claims *auth.Claims, beforeJoin func() error) (*types.PeerConnectionState, quota.Limits, error) {
+	peerConnection, err := h.Engine.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, quota.Limits{}, fmt.Errorf("failed to create a PeerConnection: %w", err)
 	}
 
-	handlerCtx.ListLock.Lock()
-	*handlerCtx.PeerConnections = append(*handlerCtx.PeerConnections, peerConnectionState)
-	handlerCtx.ListLock.Unlock()
+	// Accept one audio and one video track incoming from publishers, more are added
+	// by publish_track
+	for _, typ := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		if !claims.Permissions.Publish {
+			break
+		}
 
-	// Add to room manager
-	if handlerCtx.RoomManager != nil {
-		handlerCtx.RoomManager.AddPeer(roomID, c, &peerConnectionState)
-		handlerCtx.Logger.Infof("Peer %s added to room %s (total: %d)", username, roomID, handlerCtx.RoomManager.GetRoomPeerCount(roomID))
+		if _, err := peerConnection.AddTransceiverFromKind(typ, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		}); err != nil {
+			_ = peerConnection.Close()
+			return nil, quota.Limits{}, fmt.Errorf("failed to add transceiver: %w", err)
+		}
 	}
 
+	peerConnectionState := newPeerState(peerConnection, claims)
+	peerConnectionState.Websocket = c
+	peerConnectionState.ManualSubscribe = claims.AutoSubscribe != nil && !*claims.AutoSubscribe
+
 	// Trickle ICE. Emit server candidate to client
 	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
 		if i == nil {
@@ -237,153 +282,134 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
 		}
 		// If you are serializing a candidate make sure to use ToJSON
 		// Using Marshal will result in errors around `sdpMid`
-		candidateString, err := json.Marshal(i.ToJSON())
-		if err != nil {
-			handlerCtx.Logger.Errorf("Failed to marshal candidate to json: %v", err)
-
-			return
-		}
+		candidate := i.ToJSON()
 
-		handlerCtx.Logger.Infof("Send candidate to client: %s", candidateString)
+		h.Logger.Infof("Send candidate to client: %s", candidate.Candidate)
 
-		if writeErr := c.WriteJSON(&types.WebsocketMessage{
-			Event: "candidate",
-			Data:  string(candidateString),
-		}); writeErr != nil {
-			handlerCtx.Logger.Errorf("Failed to write JSON: %v", writeErr)
+		if writeErr := c.WriteEvent(signaling.TypeCandidate, candidate); writeErr != nil {
+			h.Logger.Errorf("Failed to write JSON: %v", writeErr)
 		}
 	})
 
 	// If PeerConnection is closed remove it from global list
 	peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
-		handlerCtx.Logger.Infof("Connection state change: %s", p)
+		h.Logger.Infof("Connection state change: %s", p)
 
 		switch p {
 		case webrtc.PeerConnectionStateFailed:
-			if err := peerConnection.Close(); err != nil {
-				handlerCtx.Logger.Errorf("Failed to close PeerConnection: %v", err)
-			}
+			// Give the ICE restart until the end of the grace period to recover
+			time.AfterFunc(h.GracePeriod, func() {
+				if peerConnection.ConnectionState() != webrtc.PeerConnectionStateFailed {
+					return
+				}
+				if err := peerConnection.Close(); err != nil {
+					h.Logger.Errorf("Failed to close PeerConnection: %v", err)
+				}
+			})
 		case webrtc.PeerConnectionStateClosed:
-			handlerCtx.SignalPeerConnections()
+			h.Engine.SignalPeerConnections()
 		default:
 		}
 	})
 
-	peerConnection.OnTrack(func(t *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
-		handlerCtx.Logger.Infof("Got remote track: Kind=%s, ID=%s, PayloadType=%d", t.Kind(), t.ID(), t.PayloadType())
-
-		// Create a track to fan out our incoming video to all peers
-		trackLocal := handlerCtx.AddTrack(t)
-		defer handlerCtx.RemoveTrack(trackLocal)
-
-		buf := make([]byte, 1500)
-		rtpPkt := &rtp.Packet{}
-
-		for {
-			i, _, err := t.Read(buf)
-			if err != nil {
-				return
-			}
-
-			if err = rtpPkt.Unmarshal(buf[:i]); err != nil {
-				handlerCtx.Logger.Errorf("Failed to unmarshal incoming RTP packet: %v", err)
-
-				return
-			}
-
-			rtpPkt.Extension = false
-			rtpPkt.Extensions = nil
-
-			if err = trackLocal.WriteRTP(rtpPkt); err != nil {
-				return
-			}
-		}
+	peerConnection.OnTrack(func(t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		h.forwardTrack(peerConnectionState, t, receiver)
 	})
 
+	// Restart ICE when the network path is lost, e.g. after switching networks
 	peerConnection.OnICEConnectionStateChange(func(is webrtc.ICEConnectionState) {
-		handlerCtx.Logger.Infof("ICE connection state changed: %s", is)
+		h.Logger.Infof("ICE connection state changed: %s", is)
+
+		switch is {
+		case webrtc.ICEConnectionStateFailed:
+			go h.Engine.RestartICE(peerConnectionState)
+		case webrtc.ICEConnectionStateDisconnected:
+			// Disconnected often recovers by itself, only restart if it persists
+			time.AfterFunc(iceDisconnectedTimeout, func() {
+				if peerConnection.ICEConnectionState() == webrtc.ICEConnectionStateDisconnected {
+					h.Engine.RestartICE(peerConnectionState)
+				}
+			})
+		default:
+		}
 	})
 
-	// Signal for the new PeerConnection
-	handlerCtx.SignalPeerConnections()
+	// Add our new PeerConnection to the engine and its room
+	limits, err := h.join(peerConnectionState, claims, beforeJoin)
+	if err != nil {
+		_ = peerConnection.Close()
+		return nil, limits, err
+	}
 
-	// Monitor connection health in background
-	healthCheckTicker := time.NewTicker(handlerCtx.KeepaliveConfig.PongWaitTime)
-	defer healthCheckTicker.Stop()
+	return peerConnectionState, limits, nil
+}
 
-	healthCheckDone := make(chan struct{})
-	defer close(healthCheckDone)
+// forwardTrack registers a remote track (or simulcast layer) of a peer to fan it out
+// to the other peers in its room, and forwards its packets until it ends
+func (h *Handler) forwardTrack(peer *types.PeerConnectionState, t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	h.Logger.Infof("Got remote track: Kind=%s, ID=%s, RID=%s, PayloadType=%d", t.Kind(), t.ID(), t.RID(), t.PayloadType())
 
-	go func() {
-		for {
-			select {
-			case <-healthCheckDone:
-				return
-			case <-healthCheckTicker.C:
-				// Check if connection is alive
-				if !monitor.IsAlive() {
-					handlerCtx.Logger.Warnf("Connection health check failed, closing stale connection")
-					c.Close()
-					return
-				}
-			}
-		}
-	}()
+	published := h.Engine.Publish(peer, t, receiver)
+	if published == nil {
+		return
+	}
+	defer h.Engine.Unpublish(peer, t)
+
+	buf := make([]byte, 1500)
+	rtpPkt := &rtp.Packet{}
 
-	message := &types.WebsocketMessage{}
 	for {
-		_, raw, err := c.ReadMessage()
+		i, _, err := t.Read(buf)
 		if err != nil {
-			// Check if it's a normal close (user left)
-			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-				handlerCtx.Logger.Infof("Client disconnected normally")
-			} else {
-				handlerCtx.Logger.Errorf("Failed to read message: %v", err)
-			}
+			return
+		}
+
+		if err = rtpPkt.Unmarshal(buf[:i]); err != nil {
+			h.Logger.Errorf("Failed to unmarshal incoming RTP packet: %v", err)
 
 			return
 		}
 
-		if err := json.Unmarshal(raw, &message); err != nil {
-			handlerCtx.Logger.Errorf("Failed to unmarshal json to message: %v", err)
-			continue // Skip invalid messages instead of closing connection
+		published.WriteRTP(t.RID(), rtpPkt)
+	}
+}
+
+// answerOffer applies an offer sent by the client and replies with an answer.
+// If a server offer is outstanding the server yields to the client and rolls it back,
+// then renegotiates once the client's offer has been applied.
+func (h *Handler) answerOffer(peer *types.PeerConnectionState, offer webrtc.SessionDescription) error {
+	peerConnection := peer.PeerConnection
+
+	rolledBack := false
+	if peerConnection.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		if err := peerConnection.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err != nil {
+			return fmt.Errorf("failed to roll back local offer: %w", err)
 		}
+		rolledBack = true
+	}
 
-		switch message.Event {
-		case "candidate":
-			candidate := webrtc.ICECandidateInit{}
-			if err := json.Unmarshal([]byte(message.Data), &candidate); err != nil {
-				handlerCtx.Logger.Errorf("Failed to unmarshal json to candidate: %v", err)
-				continue
-			}
+	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+		return fmt.Errorf("failed to set remote description: %w", err)
+	}
 
-			if err := peerConnection.AddICECandidate(candidate); err != nil {
-				handlerCtx.Logger.Errorf("Failed to add ICE candidate: %v", err)
-				// Continue on ICE candidate errors - not critical
-			}
-		case "answer":
-			answer := webrtc.SessionDescription{}
-			if err := json.Unmarshal([]byte(message.Data), &answer); err != nil {
-				handlerCtx.Logger.Errorf("Failed to unmarshal json to answer: %v", err)
-				continue
-			}
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create answer: %w", err)
+	}
 
-			if err := peerConnection.SetRemoteDescription(answer); err != nil {
-				handlerCtx.Logger.Errorf("Failed to set remote description: %v", err)
-				// Continue on SDP errors - not critical
-			}
-		case "chat":
-			// Handle chat message
-			chatMsg := types.ChatMessage{
-				Event:   "chat",
-				Message: message.Data,
-				Time:    "15:04:05",
-			}
+	if err = peerConnection.SetLocalDescription(answer); err != nil {
+		return fmt.Errorf("failed to set local description: %w", err)
+	}
 
-			// Broadcast to all other peers
-			handlerCtx.BroadcastChat(chatMsg, c)
-		default:
-			handlerCtx.Logger.Errorf("unknown message: %+v", message)
-		}
+	if err = peer.Websocket.WriteEvent(signaling.TypeAnswer, answer); err != nil {
+		return fmt.Errorf("failed to write answer: %w", err)
 	}
+
+	if rolledBack {
+		go h.Engine.RequestNegotiation(peer)
+	} else {
+		go h.Engine.ResumeNegotiation(peer)
+	}
+
+	return nil
 }
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+
+	"aq-server/internal/auth"
+	"aq-server/internal/quota"
+	"aq-server/internal/sfu"
+	"aq-server/internal/signaling"
+	"aq-server/internal/types"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// handleMessage carries out a request of a peer
+func (h *Handler) handleMessage(peer *types.PeerConnectionState, message signaling.Message) error {
+	switch message.Type {
+	case signaling.TypeCandidate:
+		candidate := webrtc.ICECandidateInit{}
+		if err := message.Unmarshal(&candidate); err != nil {
+			return err
+		}
+
+		if err := peer.PeerConnection.AddICECandidate(candidate); err != nil {
+			return signaling.NewError(signaling.ErrorBadRequest, "failed to add ICE candidate: %v", err)
+		}
+	case signaling.TypeAnswer:
+		answer := webrtc.SessionDescription{}
+		if err := message.Unmarshal(&answer); err != nil {
+			return err
+		}
+
+		if err := peer.PeerConnection.SetRemoteDescription(answer); err != nil {
+			return signaling.NewError(signaling.ErrorBadRequest, "failed to set remote description: %v", err)
+		}
+
+		// Send what changed while this exchange was in progress
+		go h.Engine.ResumeNegotiation(peer)
+	case signaling.TypeOffer:
+		// Client-initiated negotiation, used to publish simulcast tracks
+		offer := webrtc.SessionDescription{}
+		if err := message.Unmarshal(&offer); err != nil {
+			return err
+		}
+
+		if err := h.answerOffer(peer, offer); err != nil {
+			return signaling.NewError(signaling.ErrorBadRequest, "failed to answer offer: %v", err)
+		}
+	case signaling.TypePublishTrack:
+		// Publisher declares a track's source and name before sending it
+		declaration := signaling.PublishTrack{}
+		if err := message.Unmarshal(&declaration); err != nil {
+			return err
+		}
+		if declaration.TrackID == "" {
+			return signaling.NewError(signaling.ErrorBadRequest, "track_id is required")
+		}
+
+		return h.Engine.DeclareTrack(peer, declaration.TrackID, sfu.TrackSource(declaration.Source), declaration.Name)
+	case signaling.TypeSubscribe, signaling.TypeUnsubscribe:
+		// Subscriber picks the tracks it renders
+		subscription := signaling.Subscription{}
+		if err := message.Unmarshal(&subscription); err != nil {
+			return err
+		}
+
+		if message.Type == signaling.TypeSubscribe {
+			h.Engine.Subscribe(peer, subscription.TrackIDs)
+		} else {
+			h.Engine.Unsubscribe(peer, subscription.TrackIDs)
+		}
+	case signaling.TypeMute, signaling.TypeUnmute:
+		// Mute own tracks, or another participant's as a host
+		request := signaling.MuteRequest{}
+		if err := message.Unmarshal(&request); err != nil {
+			return err
+		}
+
+		muted := message.Type == signaling.TypeMute
+		err := h.Engine.SetMuted(peer, request.Participant, request.TrackID, muted)
+		if muted && request.Participant != "" && request.Participant != peer.Username {
+			h.recordModeration(peer, request, err)
+		}
+		return err
+	case signaling.TypeVideoConstraints:
+		// Subscriber tells us how large it renders a track, to pick a simulcast layer
+		constraints := signaling.VideoConstraints{}
+		if err := message.Unmarshal(&constraints); err != nil {
+			return err
+		}
+
+		h.Engine.SetVideoConstraints(peer, constraints.TrackID, constraints.Width, constraints.Height)
+	case signaling.TypeChat:
+		request := signaling.ChatRequest{}
+		if err := message.Unmarshal(&request); err != nil {
+			return err
+		}
+
+		// Broadcast to all other peers
+		return h.Engine.BroadcastChat(types.ChatMessage{
+			Event:   signaling.TypeChat,
+			Message: request.Message,
+		}, peer.Websocket)
+	default:
+		return signaling.NewError(signaling.ErrorUnknownType, "unknown message type %q", message.Type)
+	}
+
+	return nil
+}
+
+// respond answers a request, logging why it failed if it did
+func (h *Handler) respond(c *types.ThreadSafeWriter, requestID string, err error) {
+	var response *signaling.Error
+	if err != nil {
+		response = signalingError(err)
+		h.Logger.Warnf("Request %q failed: %v", requestID, err)
+	}
+
+	if writeErr := c.WriteResponse(requestID, response); writeErr != nil {
+		h.Logger.Errorf("Failed to write response: %v", writeErr)
+	}
+}
+
+// signalingError maps an error to the error sent to the client
+func signalingError(err error) *signaling.Error {
+	var signalingErr *signaling.Error
+	var limitErr *quota.LimitError
+	switch {
+	case errors.As(err, &signalingErr):
+		return signalingErr
+	case errors.As(err, &limitErr):
+		code := signaling.ErrorQuotaExceeded
+		if limitErr.Limit == quota.LimitRoomCapacity {
+			code = signaling.ErrorRoomFull
+		}
+		return &signaling.Error{Code: code, Message: limitErr.Error(), Limit: limitErr.Limit, Max: limitErr.Max}
+	case errors.Is(err, auth.ErrTokenUsed), errors.Is(err, auth.ErrUnknownCompany):
+		return signaling.NewError(signaling.ErrorNotAllowed, "%v", err)
+	case errors.Is(err, sfu.ErrTrackNotFound):
+		return signaling.NewError(signaling.ErrorNotFound, "%v", err)
+	case errors.Is(err, sfu.ErrNotAllowed):
+		return signaling.NewError(signaling.ErrorNotAllowed, "%v", err)
+	case errors.Is(err, sfu.ErrInvalidTrackSource):
+		return signaling.NewError(signaling.ErrorBadRequest, "%v", err)
+	default:
+		return signaling.NewError(signaling.ErrorInternal, "%v", err)
+	}
+}
@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"aq-server/internal/audit"
+	"aq-server/internal/auth"
+	"aq-server/internal/quota"
+	"aq-server/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v4"
+)
+
+// WHIP and WHEP negotiate a PeerConnection over HTTP: the client POSTs an offer to
+// the endpoint, gets the answer and a resource URL in the Location header, then
+// PATCHes trickled ICE candidates to the resource and DELETEs it to leave.
+
+const (
+	sdpContentType        = "application/sdp"
+	trickleICEContentType = "application/trickle-ice-sdpfrag"
+
+	// resourceMaxBody limits the size of offers and trickled candidates
+	resourceMaxBody = 1 << 20
+
+	// resourceGatherTimeout is how long an answer waits for the server's ICE candidates
+	resourceGatherTimeout = 5 * time.Second
+)
+
+// resourceCreator answers the offer POSTed to an endpoint for a token's room
+type resourceCreator func(w http.ResponseWriter, r *http.Request, claims *auth.Claims)
+
+// serveResources serves an HTTP negotiated endpoint under path, authenticated by
+// room tokens that grant permission
+func (h *Handler) serveResources(w http.ResponseWriter, r *http.Request, path, permission string, create resourceCreator) {
+	roomID, resourceID, ok := parseResourcePath(path, r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Accept-Post", sdpContentType)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	tokenString, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized: JWT token is required", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := h.Validator.Validate(tokenString)
+	if err != nil {
+		h.Audit.AuthFailure(r, "", audit.ActorToken, err.Error())
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, fmt.Sprintf("Unauthorized: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	if claims.RoomID != roomID {
+		http.Error(w, "Forbidden: token is not valid for this room", http.StatusForbidden)
+		return
+	}
+
+	if !claims.Permissions.Allows(permission) {
+		http.Error(w, fmt.Sprintf("Forbidden: token does not allow %s", permission), http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case resourceID == "" && r.Method == http.MethodPost:
+		create(w, r, claims)
+	case resourceID != "" && r.Method == http.MethodPatch:
+		h.trickleResource(w, r, h.resource(resourceID, claims))
+	case resourceID != "" && r.Method == http.MethodDelete:
+		if h.resource(resourceID, claims) == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		h.closeResource(resourceID)
+		w.WriteHeader(http.StatusOK)
+	default:
+		if resourceID == "" {
+			w.Header().Set("Allow", "POST, OPTIONS")
+		} else {
+			w.Header().Set("Allow", "PATCH, DELETE, OPTIONS")
+		}
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// readOffer reads the SDP offer POSTed to an endpoint
+func readOffer(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if !hasContentType(r, sdpContentType) {
+		http.Error(w, "Unsupported media type, expected "+sdpContentType, http.StatusUnsupportedMediaType)
+		return "", false
+	}
+
+	offer, err := io.ReadAll(http.MaxBytesReader(w, r.Body, resourceMaxBody))
+	if err != nil {
+		http.Error(w, "Failed to read offer", http.StatusBadRequest)
+		return "", false
+	}
+
+	return string(offer), true
+}
+
+// startResource joins a peer created for an endpoint under path to its room if its
+// limits allow, using up single-use tokens, answers its offer and replies with the
+// answer and the resource URL. beforeAnswer, if not nil, runs once the offer was
+// applied and fails the request if it returns an error. It reports whether the
+// resource was started.
+func (h *Handler) startResource(w http.ResponseWriter, r *http.Request, path string, peer *types.PeerConnectionState, claims *auth.Claims, offer string, beforeAnswer func() error) bool {
+	resourceID := uuid.NewString()
+
+	limits, err := h.join(peer, claims, func() error { return h.Validator.Consume(claims) })
+	if err != nil {
+		_ = peer.PeerConnection.Close()
+		h.Logger.Warnf("Peer %s can't join room %s: %v", peer.Username, peer.RoomID, err)
+		h.rejectResource(w, r, claims, err)
+		return false
+	}
+
+	peer.PeerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
+		h.Logger.Infof("Resource %s connection state change: %s", resourceID, p)
+
+		switch p {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			h.closeResource(resourceID)
+		default:
+		}
+	})
+
+	h.openRecord(peer, r)
+
+	h.resourcesLock.Lock()
+	h.resources[resourceID] = peer
+	h.resourcesLock.Unlock()
+
+	if limits.MaxSessionLength > 0 {
+		time.AfterFunc(limits.MaxSessionLength, func() {
+			if h.closeResource(resourceID) {
+				h.Logger.Infof("Resource %s reached the session length limit", resourceID)
+			}
+		})
+	}
+
+	answer, status, err := h.answerResourceOffer(r.Context(), peer.PeerConnection, offer, beforeAnswer)
+	if err != nil {
+		h.Logger.Errorf("Failed to answer offer of %s: %v", peer.Username, err)
+		h.closeResource(resourceID)
+		http.Error(w, err.Error(), status)
+		return false
+	}
+
+	h.Logger.Infof("Peer %s joined room %s as resource %s", peer.Username, peer.RoomID, resourceID)
+
+	w.Header().Set("Content-Type", sdpContentType)
+	w.Header().Set("Location", path+peer.RoomID+"/"+resourceID)
+	w.WriteHeader(http.StatusCreated)
+	if _, err := io.WriteString(w, answer); err != nil {
+		h.Logger.Errorf("Failed to write answer: %v", err)
+	}
+
+	return true
+}
+
+// rejectResource replies to an offer whose peer couldn't join its room. Exceeded
+// limits are described by the same error as over signaling.
+func (h *Handler) rejectResource(w http.ResponseWriter, r *http.Request, claims *auth.Claims, err error) {
+	var limitErr *quota.LimitError
+	switch {
+	case errors.Is(err, auth.ErrTokenUsed):
+		h.Audit.AuthFailure(r, claims.CompanyID, audit.ActorToken, err.Error())
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, fmt.Sprintf("Unauthorized: %v", err), http.StatusUnauthorized)
+	case errors.As(err, &limitErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		if err := json.NewEncoder(w).Encode(signalingError(err)); err != nil {
+			h.Logger.Errorf("Failed to write error: %v", err)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Failed to join room: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// answerResourceOffer applies an offer and returns the answer with the server's ICE
+// candidates, as the client has no channel to receive trickled ones. It also returns
+// the HTTP status of a failure.
+func (h *Handler) answerResourceOffer(ctx context.Context, peerConnection *webrtc.PeerConnection, offer string, beforeAnswer func() error) (string, int, error) {
+	if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("failed to set remote description: %w", err)
+	}
+
+	if beforeAnswer != nil {
+		if err := beforeAnswer(); err != nil {
+			return "", http.StatusNotFound, err
+		}
+	}
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("failed to create answer: %w", err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+	if err = peerConnection.SetLocalDescription(answer); err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	select {
+	case <-gatherComplete:
+	case <-time.After(resourceGatherTimeout):
+		h.Logger.Warnf("ICE gathering timed out, answering with the candidates gathered so far")
+	case <-ctx.Done():
+		return "", http.StatusRequestTimeout, ctx.Err()
+	}
+
+	return peerConnection.LocalDescription().SDP, 0, nil
+}
+
+// trickleResource adds the ICE candidates a client trickled
+func (h *Handler) trickleResource(w http.ResponseWriter, r *http.Request, peer *types.PeerConnectionState) {
+	if peer == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !hasContentType(r, trickleICEContentType) {
+		http.Error(w, "Unsupported media type, expected "+trickleICEContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	fragment, err := io.ReadAll(http.MaxBytesReader(w, r.Body, resourceMaxBody))
+	if err != nil {
+		http.Error(w, "Failed to read candidates", http.StatusBadRequest)
+		return
+	}
+
+	for _, candidate := range parseTrickleFragment(string(fragment)) {
+		if err := peer.PeerConnection.AddICECandidate(candidate); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to add ICE candidate: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resource returns the peer of a resource if it belongs to the token's user, or nil
+func (h *Handler) resource(resourceID string, claims *auth.Claims) *types.PeerConnectionState {
+	h.resourcesLock.Lock()
+	defer h.resourcesLock.Unlock()
+
+	peer, ok := h.resources[resourceID]
+	if !ok || !isTokenPeer(peer, claims) {
+		return nil
+	}
+
+	return peer
+}
+
+// closeResource closes the PeerConnection of a resource and removes its peer from
+// the engine. Closing a resource that is already gone does nothing and returns false.
+func (h *Handler) closeResource(resourceID string) bool {
+	h.resourcesLock.Lock()
+	peer, ok := h.resources[resourceID]
+	delete(h.resources, resourceID)
+	h.resourcesLock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	if err := peer.PeerConnection.Close(); err != nil {
+		h.Logger.Errorf("Failed to close PeerConnection: %v", err)
+	}
+	h.Engine.Leave(peer)
+	h.closeRecord(peer)
+
+	h.Logger.Infof("Resource %s of %s left room %s", resourceID, peer.Username, peer.RoomID)
+
+	return true
+}
+
+// parseResourcePath splits a URL path under an endpoint's path into its room and
+// resource ID, which is empty for the endpoint itself
+func parseResourcePath(prefix, path string) (roomID, resourceID string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(path, prefix), "/")
+	if !strings.HasPrefix(path, prefix) || parts[0] == "" || len(parts) > 2 {
+		return "", "", false
+	}
+
+	if len(parts) == 2 {
+		if parts[1] == "" {
+			return "", "", false
+		}
+		resourceID = parts[1]
+	}
+
+	return parts[0], resourceID, true
+}
+
+// parseTrickleFragment reads the ICE candidates of a trickle-ice-sdpfrag body
+func parseTrickleFragment(fragment string) []webrtc.ICECandidateInit {
+	candidates := []webrtc.ICECandidateInit{}
+
+	var mid *string
+	var lineIndex uint16
+	mediaSections := 0
+
+	for _, line := range strings.Split(fragment, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "m="):
+			lineIndex = uint16(mediaSections)
+			mediaSections++
+			mid = nil
+		case strings.HasPrefix(line, "a=mid:"):
+			value := strings.TrimPrefix(line, "a=mid:")
+			mid = &value
+		case strings.HasPrefix(line, "a=candidate:"):
+			index := lineIndex
+			candidates = append(candidates, webrtc.ICECandidateInit{
+				Candidate:     strings.TrimPrefix(line, "a="),
+				SDPMid:        mid,
+				SDPMLineIndex: &index,
+			})
+		}
+	}
+
+	return candidates
+}
+
+// bearerToken reads the token of an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	const bearerSchema = "Bearer "
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, bearerSchema) || len(header) == len(bearerSchema) {
+		return "", false
+	}
+
+	return header[len(bearerSchema):], true
+}
+
+// hasContentType reports whether a request body has the given media type
+func hasContentType(r *http.Request, mediaType string) bool {
+	contentType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+	return strings.EqualFold(contentType, mediaType)
+}
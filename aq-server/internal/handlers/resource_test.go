@@ -0,0 +1,56 @@
+package handlers
+
+import "testing"
+
+func TestParseResourcePath(t *testing.T) {
+	tests := []struct {
+		path       string
+		roomID     string
+		resourceID string
+		ok         bool
+	}{
+		{"/api/v1/whip/lobby", "lobby", "", true},
+		{"/api/v1/whip/lobby/abc", "lobby", "abc", true},
+		{"/api/v1/whip/", "", "", false},
+		{"/api/v1/whip/lobby/", "", "", false},
+		{"/api/v1/whip/lobby/abc/def", "", "", false},
+		{"/api/v1/rooms/lobby", "", "", false},
+	}
+
+	for _, tt := range tests {
+		roomID, resourceID, ok := parseResourcePath(WHIPPath, tt.path)
+		if roomID != tt.roomID || resourceID != tt.resourceID || ok != tt.ok {
+			t.Errorf("Expected parseResourcePath(%q) = (%q, %q, %v), got (%q, %q, %v)", tt.path, tt.roomID, tt.resourceID, tt.ok, roomID, resourceID, ok)
+		}
+	}
+}
+
+func TestParseTrickleFragment(t *testing.T) {
+	fragment := "a=ice-ufrag:EsAw\r\n" +
+		"a=ice-pwd:P2uYro0UCOQ4zxjKXaWCBui1\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=mid:0\r\n" +
+		"a=candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=mid:1\r\n" +
+		"a=candidate:3471623853 1 udp 2122194687 198.51.100.2 61765 typ host generation 0\r\n" +
+		"a=end-of-candidates\r\n"
+
+	candidates := parseTrickleFragment(fragment)
+	if len(candidates) != 2 {
+		t.Fatalf("Expected 2 candidates, got %d", len(candidates))
+	}
+
+	for i, candidate := range candidates {
+		if candidate.SDPMid == nil || *candidate.SDPMid != []string{"0", "1"}[i] {
+			t.Errorf("Expected candidate %d in mid %d, got %v", i, i, candidate.SDPMid)
+		}
+		if candidate.SDPMLineIndex == nil || int(*candidate.SDPMLineIndex) != i {
+			t.Errorf("Expected candidate %d in m-line %d, got %v", i, i, candidate.SDPMLineIndex)
+		}
+	}
+
+	if candidates[0].Candidate != "candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0" {
+		t.Errorf("Expected the candidate without the attribute prefix, got %q", candidates[0].Candidate)
+	}
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"aq-server/internal/auth"
+	"aq-server/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+// session is a participant's stay in a room. It outlives the websocket: a client
+// that reconnects with the session ID within the grace period gets its
+// PeerConnection, published tracks and subscriptions back.
+type session struct {
+	id   string
+	peer *types.PeerConnectionState
+
+	mu         sync.Mutex
+	generation int         // Incremented each time a websocket attaches
+	expiry     *time.Timer // Ends the session once the grace period elapsed
+	deadline   *time.Timer // Ends the session once it lasted the maximum session length
+	ended      bool
+}
+
+// newSession registers the session of a peer that just joined
+func (h *Handler) newSession(peer *types.PeerConnectionState) *session {
+	s := &session{
+		id:         uuid.NewString(),
+		peer:       peer,
+		generation: 1,
+	}
+
+	h.sessionsLock.Lock()
+	h.sessions[s.id] = s
+	h.sessionsLock.Unlock()
+
+	return s
+}
+
+// resumeSession attaches a new websocket to a session that belongs to the token's
+// user and room and hasn't ended. A single-use token may only resume the session it
+// joined. Any websocket still attached is closed. It returns the generation of the
+// new attachment.
+func (h *Handler) resumeSession(id string, claims *auth.Claims, conn *websocket.Conn, version int) (*session, int, bool) {
+	if id == "" {
+		return nil, 0, false
+	}
+
+	h.sessionsLock.Lock()
+	s, ok := h.sessions[id]
+	h.sessionsLock.Unlock()
+
+	if !ok || !isTokenPeer(s.peer, claims) || (claims.SingleUse && s.peer.TokenID != claims.TokenID) {
+		return nil, 0, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended || s.peer.PeerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return nil, 0, false
+	}
+
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+
+	s.generation++
+	if previous := s.peer.Websocket.Replace(conn, version); previous != nil {
+		_ = previous.Close()
+	}
+
+	return s, s.generation, true
+}
+
+// detach is called when the websocket of the given generation went away. Unless a
+// newer websocket took over, the session ends once the grace period elapsed.
+func (h *Handler) detach(s *session, generation int) {
+	s.mu.Lock()
+	if s.ended || s.generation != generation {
+		s.mu.Unlock()
+		return
+	}
+
+	if h.GracePeriod <= 0 {
+		s.ended = true
+		s.mu.Unlock()
+		h.endSession(s)
+		return
+	}
+
+	h.Logger.Infof("Peer %s in room %s detached, session %s kept for %s", s.peer.Username, s.peer.RoomID, s.id, h.GracePeriod)
+
+	s.expiry = time.AfterFunc(h.GracePeriod, func() {
+		s.mu.Lock()
+		if s.ended || s.generation != generation {
+			s.mu.Unlock()
+			return
+		}
+		s.ended = true
+		s.mu.Unlock()
+
+		h.Logger.Infof("Session %s of peer %s expired", s.id, s.peer.Username)
+		h.endSession(s)
+	})
+	s.mu.Unlock()
+}
+
+// endSession closes the PeerConnection of a session and removes its peer from the engine
+func (h *Handler) endSession(s *session) {
+	s.mu.Lock()
+	if s.deadline != nil {
+		s.deadline.Stop()
+		s.deadline = nil
+	}
+	s.mu.Unlock()
+
+	h.sessionsLock.Lock()
+	delete(h.sessions, s.id)
+	h.sessionsLock.Unlock()
+
+	if err := s.peer.PeerConnection.Close(); err != nil {
+		h.Logger.Errorf("Failed to close PeerConnection: %v", err)
+	}
+	h.Engine.Leave(s.peer)
+	h.closeRecord(s.peer)
+}
+
+// DisconnectTokens ends the sessions and WHIP and WHEP resources of participants
+// that joined with any of the given tokens, e.g. because the tokens were revoked. It
+// returns how many participants it disconnected.
+func (h *Handler) DisconnectTokens(tokenIDs []string) int {
+	tokens := make(map[string]bool, len(tokenIDs))
+	for _, id := range tokenIDs {
+		tokens[id] = true
+	}
+
+	sessions := []*session{}
+	h.sessionsLock.Lock()
+	for _, s := range h.sessions {
+		if tokens[s.peer.TokenID] {
+			sessions = append(sessions, s)
+		}
+	}
+	h.sessionsLock.Unlock()
+
+	resources := []string{}
+	h.resourcesLock.Lock()
+	for id, peer := range h.resources {
+		if tokens[peer.TokenID] {
+			resources = append(resources, id)
+		}
+	}
+	h.resourcesLock.Unlock()
+
+	disconnected := 0
+	for _, s := range sessions {
+		if h.kick(s, websocket.ClosePolicyViolation, "token revoked") {
+			disconnected++
+		}
+	}
+	for _, id := range resources {
+		if h.closeResource(id) {
+			disconnected++
+		}
+	}
+
+	return disconnected
+}
+
+// Shutdown ends every session and WHIP and WHEP resource when the server stops, so
+// that their records are closed
+func (h *Handler) Shutdown() {
+	h.sessionsLock.Lock()
+	sessions := make([]*session, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		sessions = append(sessions, s)
+	}
+	h.sessionsLock.Unlock()
+
+	h.resourcesLock.Lock()
+	resources := make([]string, 0, len(h.resources))
+	for id := range h.resources {
+		resources = append(resources, id)
+	}
+	h.resourcesLock.Unlock()
+
+	for _, s := range sessions {
+		h.kick(s, websocket.CloseGoingAway, "server shutting down")
+	}
+	for _, id := range resources {
+		h.closeResource(id)
+	}
+}
+
+// kick ends a session right away and closes its websocket with the code and reason.
+// It reports false if the session had already ended.
+func (h *Handler) kick(s *session, code int, reason string) bool {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return false
+	}
+	s.ended = true
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	s.mu.Unlock()
+
+	h.Logger.Infof("Disconnecting peer %s from room %s: %s", s.peer.Username, s.peer.RoomID, reason)
+
+	if err := s.peer.Websocket.CloseWithReason(code, reason); err != nil {
+		h.Logger.Debugf("Failed to close websocket of session %s: %v", s.id, err)
+	}
+	h.endSession(s)
+
+	return true
+}
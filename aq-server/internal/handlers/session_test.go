@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"aq-server/internal/keepalive"
+	"aq-server/internal/sfu"
+	"aq-server/internal/types"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+)
+
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("handlers-test")
+	engine, err := sfu.NewEngine(logger, nil)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	return NewHandler(engine, logger, keepalive.Config{})
+}
+
+// newTestWebsocket returns the server and client ends of a websocket
+func newTestWebsocket(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+
+	serverConns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to upgrade: %v", err)
+			return
+		}
+		serverConns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return <-serverConns, client
+}
+
+func newTestPeerConnection(t *testing.T) *webrtc.PeerConnection {
+	t.Helper()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create PeerConnection: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+
+	return pc
+}
+
+func TestDisconnectTokens(t *testing.T) {
+	h := newTestHandler(t)
+
+	serverConn, client := newTestWebsocket(t)
+	revoked := &types.PeerConnectionState{
+		PeerConnection: newTestPeerConnection(t),
+		Websocket:      types.NewThreadSafeWriter(serverConn, 1),
+		Username:       "alice",
+		RoomID:         "lobby",
+		TokenID:        "revoked-token",
+	}
+	h.Engine.Join(revoked)
+	sess := h.newSession(revoked)
+
+	publisher := &types.PeerConnectionState{PeerConnection: newTestPeerConnection(t), Username: "obs", RoomID: "lobby", TokenID: "revoked-token"}
+	viewer := &types.PeerConnectionState{PeerConnection: newTestPeerConnection(t), Username: "viewer", RoomID: "lobby", TokenID: "other-token"}
+	h.resources["publisher"] = publisher
+	h.resources["viewer"] = viewer
+
+	if disconnected := h.DisconnectTokens([]string{"revoked-token"}); disconnected != 2 {
+		t.Errorf("Expected 2 participants disconnected, got %d", disconnected)
+	}
+
+	if _, ok := h.sessions[sess.id]; ok {
+		t.Error("Expected the session of the revoked token to end")
+	}
+	if _, ok := h.resources["publisher"]; ok {
+		t.Error("Expected the resource of the revoked token to close")
+	}
+	if _, ok := h.resources["viewer"]; !ok {
+		t.Error("Expected the resource of another token to stay")
+	}
+
+	// The client is told why it was disconnected after the events sent on join
+	var err error
+	for err == nil {
+		_, _, err = client.ReadMessage()
+	}
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected a policy violation close, got %v", err)
+	}
+
+	if disconnected := h.DisconnectTokens([]string{"revoked-token"}); disconnected != 0 {
+		t.Errorf("Expected nobody left to disconnect, got %d", disconnected)
+	}
+}
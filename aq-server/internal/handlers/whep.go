@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"aq-server/internal/auth"
+	"aq-server/internal/types"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// WHEPPath prefixes the WHEP endpoint. Viewers POST an offer to WHEPPath+room,
+// optionally with ?participant= to only watch one participant, then PATCH and
+// DELETE the resource URL returned in the Location header.
+const WHEPPath = "/api/v1/whep/"
+
+// WHEPHandler serves WebRTC-HTTP Egress Protocol viewers. They authenticate with room
+// tokens that allow subscribing and only receive.
+func (h *Handler) WHEPHandler(w http.ResponseWriter, r *http.Request) {
+	h.serveResources(w, r, WHEPPath, types.PermissionSubscribe, h.createWHEPResource)
+}
+
+// createWHEPResource answers a viewer's offer with a receive-only PeerConnection that
+// forwards the tracks published in the room, or by one participant, when it connects.
+// A viewer can't be renegotiated, so it receives at most one track per transceiver
+// it offered and doesn't get tracks published later.
+func (h *Handler) createWHEPResource(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	offer, ok := readOffer(w, r)
+	if !ok {
+		return
+	}
+
+	peerConnection, err := h.Engine.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		h.Logger.Errorf("Failed to create a WHEP PeerConnection: %v", err)
+		http.Error(w, "Failed to create PeerConnection", http.StatusInternalServerError)
+		return
+	}
+
+	peer := newPeerState(peerConnection, claims)
+	peer.ManualSubscribe = true
+	peer.Permissions.Publish = false
+
+	participant := r.URL.Query().Get("participant")
+
+	started := h.startResource(w, r, WHEPPath, peer, claims, offer, func() error {
+		if attached := h.Engine.Attach(peer, participant); len(attached) == 0 {
+			if participant != "" {
+				return fmt.Errorf("participant %s publishes no tracks in room %s", participant, claims.RoomID)
+			}
+			return fmt.Errorf("no tracks are published in room %s", claims.RoomID)
+		}
+
+		return nil
+	})
+
+	// Ask publishers for keyframes so the viewer can start decoding
+	if started {
+		h.Engine.DispatchKeyFrame()
+	}
+}
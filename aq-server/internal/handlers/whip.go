@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+
+	"aq-server/internal/auth"
+	"aq-server/internal/types"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// WHIPPath prefixes the WHIP endpoint. Publishers POST an offer to WHIPPath+room,
+// then PATCH and DELETE the resource URL returned in the Location header.
+const WHIPPath = "/api/v1/whip/"
+
+// WHIPHandler serves WebRTC-HTTP Ingestion Protocol publishers such as OBS. They
+// authenticate with the same room tokens as websocket clients and only publish.
+func (h *Handler) WHIPHandler(w http.ResponseWriter, r *http.Request) {
+	h.serveResources(w, r, WHIPPath, types.PermissionPublish, h.createWHIPResource)
+}
+
+// createWHIPResource answers a publisher's offer with a publish-only PeerConnection
+// in the room
+func (h *Handler) createWHIPResource(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	offer, ok := readOffer(w, r)
+	if !ok {
+		return
+	}
+
+	peerConnection, err := h.Engine.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		h.Logger.Errorf("Failed to create a WHIP PeerConnection: %v", err)
+		http.Error(w, "Failed to create PeerConnection", http.StatusInternalServerError)
+		return
+	}
+
+	// Without a websocket the engine never offers tracks to this peer, it only publishes
+	peer := newPeerState(peerConnection, claims)
+
+	peerConnection.OnTrack(func(t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		h.forwardTrack(peer, t, receiver)
+	})
+
+	h.startResource(w, r, WHIPPath, peer, claims, offer, nil)
+}
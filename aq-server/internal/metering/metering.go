@@ -0,0 +1,225 @@
+// Package metering measures billable usage per company and room: how long
+// participants stay, how long tracks are published and how many bytes are forwarded.
+package metering
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Usage is what a company's room used on a day (UTC)
+type Usage struct {
+	CompanyID          string
+	RoomID             string
+	Date               time.Time
+	ParticipantSeconds int64
+	TrackSeconds       int64 // Seconds tracks were published
+	ForwardedBytes     int64
+}
+
+type usageKey struct {
+	companyID string
+	roomID    string
+	date      time.Time
+}
+
+// pending is usage not flushed yet. Seconds are kept fractional so nothing is lost
+// to rounding between flushes.
+type pending struct {
+	participantSeconds float64
+	trackSeconds       float64
+	forwardedBytes     int64
+}
+
+// span is a participant or published track being metered since a time
+type span struct {
+	companyID string
+	roomID    string
+	since     time.Time
+	forwarded func() uint64 // Takes the bytes forwarded since it was last called, tracks only
+}
+
+// Meter accumulates usage from the SFU as participants join and leave and tracks are
+// published and unpublished. Participants and tracks are keyed by whatever identifies
+// them to the caller; joining or leaving twice counts once. A nil Meter meters nothing.
+type Meter struct {
+	mu           sync.Mutex
+	participants map[any]*span
+	tracks       map[any]*span
+	usage        map[usageKey]*pending
+
+	now func() time.Time
+}
+
+// NewMeter creates a meter with nothing metered yet
+func NewMeter() *Meter {
+	return &Meter{
+		participants: make(map[any]*span),
+		tracks:       make(map[any]*span),
+		usage:        make(map[usageKey]*pending),
+		now:          time.Now,
+	}
+}
+
+// Join starts metering a participant in a room
+func (m *Meter) Join(participant any, companyID, roomID string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.participants[participant]; !ok {
+		m.participants[participant] = &span{companyID: companyID, roomID: roomID, since: m.now()}
+	}
+}
+
+// Leave stops metering a participant
+func (m *Meter) Leave(participant any) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.participants[participant]; ok {
+		m.accrue(s, m.now(), func(p *pending, seconds float64) { p.participantSeconds += seconds })
+		delete(m.participants, participant)
+	}
+}
+
+// Publish starts metering a track published in a room. forwarded takes the bytes
+// forwarded to the track's subscribers since it was last called.
+func (m *Meter) Publish(track any, companyID, roomID string, forwarded func() uint64) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tracks[track]; !ok {
+		m.tracks[track] = &span{companyID: companyID, roomID: roomID, since: m.now(), forwarded: forwarded}
+	}
+}
+
+// Unpublish stops metering a track
+func (m *Meter) Unpublish(track any) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.tracks[track]; ok {
+		m.accrueTrack(s, m.now())
+		delete(m.tracks, track)
+	}
+}
+
+// accrueTrack adds the time a track was published and the bytes forwarded since it
+// was last accrued. Callers must hold m.mu.
+func (m *Meter) accrueTrack(s *span, now time.Time) {
+	m.accrue(s, now, func(p *pending, seconds float64) { p.trackSeconds += seconds })
+	if s.forwarded != nil {
+		m.pending(s.companyID, s.roomID, day(now)).forwardedBytes += int64(s.forwarded())
+	}
+}
+
+// accrue adds the time since a span was last accrued to each day it covers, then
+// moves the span's start to now. Callers must hold m.mu.
+func (m *Meter) accrue(s *span, now time.Time, add func(p *pending, seconds float64)) {
+	for s.since.Before(now) {
+		end := day(s.since).AddDate(0, 0, 1)
+		if end.After(now) {
+			end = now
+		}
+
+		add(m.pending(s.companyID, s.roomID, day(s.since)), end.Sub(s.since).Seconds())
+		s.since = end
+	}
+}
+
+// pending returns the usage of a room on a day not flushed yet. Callers must hold m.mu.
+func (m *Meter) pending(companyID, roomID string, date time.Time) *pending {
+	key := usageKey{companyID, roomID, date}
+	p, ok := m.usage[key]
+	if !ok {
+		p = &pending{}
+		m.usage[key] = p
+	}
+	return p
+}
+
+// Flush returns the usage accumulated since the last flush, including the time
+// participants and tracks still metered have been in their rooms up to now. Whole
+// seconds are returned, fractions are kept for the next flush.
+func (m *Meter) Flush() []Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for _, s := range m.participants {
+		m.accrue(s, now, func(p *pending, seconds float64) { p.participantSeconds += seconds })
+	}
+	for _, s := range m.tracks {
+		m.accrueTrack(s, now)
+	}
+
+	usage := make([]Usage, 0, len(m.usage))
+	for key, p := range m.usage {
+		participantSeconds := math.Floor(p.participantSeconds)
+		trackSeconds := math.Floor(p.trackSeconds)
+		if participantSeconds > 0 || trackSeconds > 0 || p.forwardedBytes > 0 {
+			usage = append(usage, Usage{
+				CompanyID:          key.companyID,
+				RoomID:             key.roomID,
+				Date:               key.date,
+				ParticipantSeconds: int64(participantSeconds),
+				TrackSeconds:       int64(trackSeconds),
+				ForwardedBytes:     p.forwardedBytes,
+			})
+		}
+
+		p.participantSeconds -= participantSeconds
+		p.trackSeconds -= trackSeconds
+		p.forwardedBytes = 0
+
+		// Remainders of past days are too small to ever be flushed
+		if key.date.Before(day(now)) {
+			delete(m.usage, key)
+		}
+	}
+
+	return usage
+}
+
+// Run flushes the meter every interval and hands the usage to persist until stop
+// is closed, then flushes a last time
+func (m *Meter) Run(interval time.Duration, stop <-chan struct{}, persist func([]Usage)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if usage := m.Flush(); len(usage) > 0 {
+				persist(usage)
+			}
+		case <-stop:
+			if usage := m.Flush(); len(usage) > 0 {
+				persist(usage)
+			}
+			return
+		}
+	}
+}
+
+// day returns the start of the UTC day of t
+func day(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
@@ -0,0 +1,112 @@
+package metering
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestMeter returns a meter with a clock that only moves when advanced
+func newTestMeter(start time.Time) (*Meter, func(time.Duration)) {
+	now := start
+	m := NewMeter()
+	m.now = func() time.Time { return now }
+
+	return m, func(d time.Duration) { now = now.Add(d) }
+}
+
+// byRoom indexes flushed usage by room and date
+func byRoom(usage []Usage) map[string]Usage {
+	rooms := make(map[string]Usage)
+	for _, u := range usage {
+		rooms[u.CompanyID+"/"+u.RoomID+"@"+u.Date.Format("2006-01-02")] = u
+	}
+	return rooms
+}
+
+func TestMeterParticipants(t *testing.T) {
+	m, advance := newTestMeter(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+
+	m.Join("alice", "acme", "lobby")
+	m.Join("bob", "acme", "lobby")
+	m.Join("bob", "acme", "lobby") // joining twice counts once
+	m.Join("carol", "globex", "lobby")
+	advance(90 * time.Second)
+	m.Leave("bob")
+	m.Leave("bob")
+	advance(30 * time.Second)
+
+	usage := byRoom(m.Flush())
+	if u := usage["acme/lobby@2026-10-16"]; u.ParticipantSeconds != 210 {
+		t.Errorf("Expected 210 participant seconds in acme's lobby, got %d", u.ParticipantSeconds)
+	}
+	if u := usage["globex/lobby@2026-10-16"]; u.ParticipantSeconds != 120 {
+		t.Errorf("Expected 120 participant seconds in globex's lobby, got %d", u.ParticipantSeconds)
+	}
+
+	// Participants still in a room keep being metered from the last flush
+	advance(time.Minute)
+	m.Leave("alice")
+	m.Leave("carol")
+	usage = byRoom(m.Flush())
+	if u := usage["acme/lobby@2026-10-16"]; u.ParticipantSeconds != 60 {
+		t.Errorf("Expected 60 more participant seconds, got %d", u.ParticipantSeconds)
+	}
+
+	if usage := m.Flush(); len(usage) != 0 {
+		t.Errorf("Expected no usage once everyone left, got %+v", usage)
+	}
+}
+
+func TestMeterSplitsDays(t *testing.T) {
+	m, advance := newTestMeter(time.Date(2026, 10, 16, 23, 59, 0, 0, time.UTC))
+
+	m.Join("alice", "acme", "lobby")
+	advance(3 * time.Minute)
+
+	usage := byRoom(m.Flush())
+	if u := usage["acme/lobby@2026-10-16"]; u.ParticipantSeconds != 60 {
+		t.Errorf("Expected 60 seconds on the first day, got %d", u.ParticipantSeconds)
+	}
+	if u := usage["acme/lobby@2026-10-17"]; u.ParticipantSeconds != 120 {
+		t.Errorf("Expected 120 seconds on the second day, got %d", u.ParticipantSeconds)
+	}
+}
+
+func TestMeterTracks(t *testing.T) {
+	m, advance := newTestMeter(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+
+	forwarded := uint64(0)
+	take := func() uint64 {
+		n := forwarded
+		forwarded = 0
+		return n
+	}
+
+	m.Publish("camera", "acme", "lobby", take)
+	advance(1500 * time.Millisecond)
+	forwarded = 1000
+
+	u := m.Flush()[0]
+	if u.TrackSeconds != 1 || u.ForwardedBytes != 1000 {
+		t.Errorf("Expected 1 track second and 1000 bytes, got %+v", u)
+	}
+
+	// The half second left over is flushed once it adds up
+	advance(1500 * time.Millisecond)
+	forwarded = 500
+	m.Unpublish("camera")
+	forwarded = 99 // forwarded after unpublishing, not metered
+
+	u = m.Flush()[0]
+	if u.TrackSeconds != 2 || u.ForwardedBytes != 500 {
+		t.Errorf("Expected 2 track seconds and 500 bytes, got %+v", u)
+	}
+}
+
+func TestNilMeter(t *testing.T) {
+	var m *Meter
+	m.Join("alice", "acme", "lobby")
+	m.Leave("alice")
+	m.Publish("camera", "acme", "lobby", nil)
+	m.Unpublish("camera")
+}
@@ -0,0 +1,109 @@
+// Package quota decides whether participants may join a room within the limits of
+// their company's tier and the room's capacity.
+package quota
+
+import (
+	"fmt"
+	"time"
+)
+
+// Limits that can be exceeded, named in errors and the usage API
+const (
+	LimitRoomCapacity = "room_capacity"    // participants in the room
+	LimitParticipants = "max_participants" // participants across the company's rooms
+	LimitRooms        = "max_rooms"        // rooms of the company with participants
+	LimitPublishers   = "max_publishers"   // participants allowed to publish in the room
+)
+
+// Limits are the quotas of a tier. Zero means unlimited.
+type Limits struct {
+	MaxParticipants  int           `json:"max_participants"`
+	MaxRooms         int           `json:"max_rooms"`
+	MaxPublishers    int           `json:"max_publishers"`
+	MaxSessionLength time.Duration `json:"-"` // how long a participant may stay in a room
+}
+
+// tiers are the limits of each company tier
+var tiers = map[string]Limits{
+	"free": {
+		MaxParticipants:  20,
+		MaxRooms:         3,
+		MaxPublishers:    4,
+		MaxSessionLength: time.Hour,
+	},
+	"pro": {
+		MaxParticipants:  500,
+		MaxRooms:         50,
+		MaxPublishers:    25,
+		MaxSessionLength: 8 * time.Hour,
+	},
+	"enterprise": {},
+}
+
+// ForTier returns the limits of a tier. Unknown tiers get the free tier's limits.
+func ForTier(tier string) Limits {
+	if limits, ok := tiers[tier]; ok {
+		return limits
+	}
+	return tiers["free"]
+}
+
+// RoomUsage is what a room uses right now
+type RoomUsage struct {
+	Participants int `json:"participants"`
+	Publishers   int `json:"publishers"`
+}
+
+// Usage is what a company uses right now, with its rooms by room ID
+type Usage struct {
+	Participants int                  `json:"participants"`
+	Rooms        map[string]RoomUsage `json:"rooms"`
+}
+
+// Join describes a participant about to join a room. RoomCapacity is the room's
+// configured maximum number of participants, zero if it has none.
+type Join struct {
+	RoomID       string
+	Publisher    bool
+	RoomCapacity int
+}
+
+// LimitError is returned for joins that would exceed a limit
+type LimitError struct {
+	Limit string // one of the Limit constants
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case LimitRoomCapacity:
+		return fmt.Sprintf("room is full (%d participants)", e.Max)
+	case LimitParticipants:
+		return fmt.Sprintf("company has reached its limit of %d participants", e.Max)
+	case LimitRooms:
+		return fmt.Sprintf("company has reached its limit of %d rooms", e.Max)
+	case LimitPublishers:
+		return fmt.Sprintf("room has reached its limit of %d publishers", e.Max)
+	default:
+		return fmt.Sprintf("%s limit of %d reached", e.Limit, e.Max)
+	}
+}
+
+// Admit checks whether a participant may join given what the company uses now,
+// returning a *LimitError naming the first limit it would exceed
+func (l Limits) Admit(usage Usage, join Join) error {
+	room, roomActive := usage.Rooms[join.RoomID]
+
+	switch {
+	case join.RoomCapacity > 0 && room.Participants >= join.RoomCapacity:
+		return &LimitError{Limit: LimitRoomCapacity, Max: join.RoomCapacity}
+	case l.MaxParticipants > 0 && usage.Participants >= l.MaxParticipants:
+		return &LimitError{Limit: LimitParticipants, Max: l.MaxParticipants}
+	case l.MaxRooms > 0 && !roomActive && len(usage.Rooms) >= l.MaxRooms:
+		return &LimitError{Limit: LimitRooms, Max: l.MaxRooms}
+	case l.MaxPublishers > 0 && join.Publisher && room.Publishers >= l.MaxPublishers:
+		return &LimitError{Limit: LimitPublishers, Max: l.MaxPublishers}
+	}
+
+	return nil
+}
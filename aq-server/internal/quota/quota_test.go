@@ -0,0 +1,67 @@
+package quota
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestForTier(t *testing.T) {
+	if limits := ForTier("enterprise"); limits != (Limits{}) {
+		t.Errorf("Expected enterprise to be unlimited, got %+v", limits)
+	}
+	if ForTier("pro").MaxParticipants <= ForTier("free").MaxParticipants {
+		t.Error("Expected pro to allow more participants than free")
+	}
+	if ForTier("unknown") != ForTier("free") {
+		t.Error("Expected an unknown tier to get the free limits")
+	}
+}
+
+func TestLimitsAdmit(t *testing.T) {
+	limits := Limits{MaxParticipants: 10, MaxRooms: 2, MaxPublishers: 2}
+	usage := Usage{
+		Participants: 5,
+		Rooms: map[string]RoomUsage{
+			"lobby":   {Participants: 3, Publishers: 2},
+			"standup": {Participants: 2, Publishers: 1},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		limits   Limits
+		usage    Usage
+		join     Join
+		expected string
+	}{
+		{"viewer in an active room", limits, usage, Join{RoomID: "lobby"}, ""},
+		{"publisher with room to publish", limits, usage, Join{RoomID: "standup", Publisher: true}, ""},
+		{"room at capacity", limits, usage, Join{RoomID: "lobby", RoomCapacity: 3}, LimitRoomCapacity},
+		{"room below capacity", limits, usage, Join{RoomID: "lobby", RoomCapacity: 4}, ""},
+		{"company at participant limit", Limits{MaxParticipants: 5}, usage, Join{RoomID: "lobby"}, LimitParticipants},
+		{"new room over room limit", limits, usage, Join{RoomID: "webinar"}, LimitRooms},
+		{"publishers at limit", limits, usage, Join{RoomID: "lobby", Publisher: true}, LimitPublishers},
+		{"unlimited", Limits{}, usage, Join{RoomID: "webinar", Publisher: true}, ""},
+		{"first participant", limits, Usage{}, Join{RoomID: "lobby", Publisher: true, RoomCapacity: 1}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.Admit(tt.usage, tt.join)
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Expected the join to be admitted, got %v", err)
+				}
+				return
+			}
+
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("Expected a limit error, got %v", err)
+			}
+			if limitErr.Limit != tt.expected {
+				t.Errorf("Expected the %s limit, got %s", tt.expected, limitErr.Limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+// Package ratelimit limits API requests with in-memory token buckets per credential
+// and per company, in separate budgets.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Budgets that are limited separately
+const (
+	BudgetTokens = "tokens" // minting and revoking room tokens
+	BudgetRooms  = "rooms"  // managing rooms
+	BudgetAPI    = "api"    // everything else under /api/v1
+)
+
+// Credential types callers authenticate with
+const (
+	CredentialAPIKey = "api_key"
+	CredentialToken  = "token"
+)
+
+// Caller identifies who makes a request: an API key or a room token of a company,
+// allowed PerMinute requests per budget, or the limiter's default if zero
+type Caller struct {
+	CompanyID      string
+	CredentialType string
+	CredentialID   string
+	PerMinute      int
+}
+
+// Result is the outcome of a request against the most restrictive of its buckets
+type Result struct {
+	Allowed    bool
+	Limit      int           // requests per minute
+	Remaining  int           // requests that can be made right away
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, if it wasn't
+}
+
+// Usage counts the requests of a caller in a budget over a window
+type Usage struct {
+	CompanyID      string
+	CredentialType string
+	CredentialID   string
+	Budget         string
+	Requests       int
+	WindowStart    time.Time
+	WindowEnd      time.Time
+}
+
+// bucket is a token bucket holding up to a minute's worth of requests
+type bucket struct {
+	perMinute int
+	tokens    float64
+	updated   time.Time
+}
+
+// refill adds the tokens earned since the bucket was last updated
+func (b *bucket) refill(now time.Time, perMinute int) {
+	if perMinute != b.perMinute {
+		// The limit changed, keep the bucket as full relative to it
+		b.tokens = b.tokens * float64(perMinute) / float64(b.perMinute)
+		b.perMinute = perMinute
+	}
+
+	elapsed := now.Sub(b.updated).Minutes()
+	b.tokens = math.Min(float64(b.perMinute), b.tokens+elapsed*float64(b.perMinute))
+	b.updated = now
+}
+
+func (b *bucket) result(allowed bool) Result {
+	perToken := float64(time.Minute) / float64(b.perMinute)
+	r := Result{
+		Allowed:   allowed,
+		Limit:     b.perMinute,
+		Remaining: int(b.tokens),
+		Reset:     time.Duration((float64(b.perMinute) - b.tokens) * perToken),
+	}
+	if !allowed {
+		r.RetryAfter = time.Duration((1 - b.tokens) * perToken)
+	}
+	return r
+}
+
+type bucketKey struct {
+	budget string
+	scope  string // "key:" or "company:" followed by the ID
+}
+
+type usageKey struct {
+	companyID      string
+	credentialType string
+	credentialID   string
+	budget         string
+}
+
+// Limiter limits the requests of callers in budgets. Each budget has a bucket per
+// caller, sized by the caller's limit, and a bucket per company shared by all of the
+// company's callers, sized by the budget's limit.
+type Limiter struct {
+	mu          sync.Mutex
+	perCaller   int            // caller requests per minute unless the caller has a limit
+	budgets     map[string]int // company requests per minute by budget
+	buckets     map[bucketKey]*bucket
+	usage       map[usageKey]int
+	windowStart time.Time
+
+	now func() time.Time
+}
+
+// NewLimiter creates a limiter with the requests per minute a caller without its own
+// limit can make and a company can make in each budget
+func NewLimiter(perCaller int, budgets map[string]int) *Limiter {
+	return &Limiter{
+		perCaller:   perCaller,
+		budgets:     budgets,
+		buckets:     make(map[bucketKey]*bucket),
+		usage:       make(map[usageKey]int),
+		windowStart: time.Now(),
+		now:         time.Now,
+	}
+}
+
+// Allow takes a request from the caller's and the company's bucket of a budget if
+// both have one left. A zero limit doesn't limit at that level.
+func (l *Limiter) Allow(budget string, caller Caller) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	perMinute := caller.PerMinute
+	if perMinute == 0 {
+		perMinute = l.perCaller
+	}
+
+	var buckets []*bucket
+	if perMinute > 0 {
+		buckets = append(buckets, l.bucket(bucketKey{budget, "key:" + caller.CredentialID}, perMinute, now))
+	}
+	if perMinute := l.budgets[budget]; perMinute > 0 {
+		buckets = append(buckets, l.bucket(bucketKey{budget, "company:" + caller.CompanyID}, perMinute, now))
+	}
+	if len(buckets) == 0 {
+		return Result{Allowed: true}
+	}
+
+	// Report the bucket with the fewest requests left
+	tightest := buckets[0]
+	for _, b := range buckets[1:] {
+		if b.tokens < tightest.tokens {
+			tightest = b
+		}
+	}
+	if tightest.tokens < 1 {
+		return tightest.result(false)
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+	l.usage[usageKey{caller.CompanyID, caller.CredentialType, caller.CredentialID, budget}]++
+
+	return tightest.result(true)
+}
+
+// bucket returns a refilled bucket, creating a full one if there is none
+func (l *Limiter) bucket(key bucketKey, perMinute int, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{perMinute: perMinute, tokens: float64(perMinute), updated: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, perMinute)
+	return b
+}
+
+// Flush returns the requests allowed since the last flush and starts a new window.
+// Buckets that have filled up again are dropped, they're recreated full when needed.
+func (l *Limiter) Flush() []Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	usage := make([]Usage, 0, len(l.usage))
+	for key, requests := range l.usage {
+		usage = append(usage, Usage{
+			CompanyID:      key.companyID,
+			CredentialType: key.credentialType,
+			CredentialID:   key.credentialID,
+			Budget:         key.budget,
+			Requests:       requests,
+			WindowStart:    l.windowStart,
+			WindowEnd:      now,
+		})
+	}
+	l.usage = make(map[usageKey]int)
+	l.windowStart = now
+
+	for key, b := range l.buckets {
+		if b.refill(now, b.perMinute); b.tokens >= float64(b.perMinute) {
+			delete(l.buckets, key)
+		}
+	}
+
+	return usage
+}
+
+// Run flushes the limiter every interval and hands the usage to persist until stop
+// is closed, then flushes a last time
+func (l *Limiter) Run(interval time.Duration, stop <-chan struct{}, persist func([]Usage)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if usage := l.Flush(); len(usage) > 0 {
+				persist(usage)
+			}
+		case <-stop:
+			if usage := l.Flush(); len(usage) > 0 {
+				persist(usage)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter with a clock that only moves when advanced
+func newTestLimiter(perCaller int, budgets map[string]int) (*Limiter, func(time.Duration)) {
+	now := time.Unix(1700000000, 0)
+	l := NewLimiter(perCaller, budgets)
+	l.now = func() time.Time { return now }
+	l.windowStart = now
+
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLimiterPerCaller(t *testing.T) {
+	l, advance := newTestLimiter(0, nil)
+	alice := Caller{CompanyID: "acme", CredentialID: "key-1", PerMinute: 3}
+
+	for i := 0; i < 3; i++ {
+		r := l.Allow(BudgetTokens, alice)
+		if !r.Allowed {
+			t.Fatalf("Expected request %d to be allowed", i+1)
+		}
+		if r.Limit != 3 || r.Remaining != 2-i {
+			t.Errorf("Expected limit 3 with %d remaining, got %d with %d", 2-i, r.Limit, r.Remaining)
+		}
+	}
+
+	r := l.Allow(BudgetTokens, alice)
+	if r.Allowed {
+		t.Fatal("Expected the fourth request to be limited")
+	}
+	if r.RetryAfter != 20*time.Second {
+		t.Errorf("Expected to retry after 20s, got %v", r.RetryAfter)
+	}
+	if r.Reset != time.Minute {
+		t.Errorf("Expected the bucket to be full after 1m, got %v", r.Reset)
+	}
+
+	// Budgets are separate
+	if r := l.Allow(BudgetRooms, alice); !r.Allowed {
+		t.Error("Expected another budget to be allowed")
+	}
+
+	advance(20 * time.Second)
+	if r := l.Allow(BudgetTokens, alice); !r.Allowed {
+		t.Error("Expected a request to be allowed after refilling")
+	}
+}
+
+func TestLimiterPerCompany(t *testing.T) {
+	l, _ := newTestLimiter(0, map[string]int{BudgetTokens: 4})
+	first := Caller{CompanyID: "acme", CredentialID: "key-1", PerMinute: 10}
+	second := Caller{CompanyID: "acme", CredentialID: "key-2", PerMinute: 10}
+	other := Caller{CompanyID: "globex", CredentialID: "key-3", PerMinute: 10}
+
+	for i := 0; i < 2; i++ {
+		l.Allow(BudgetTokens, first)
+		l.Allow(BudgetTokens, second)
+	}
+
+	r := l.Allow(BudgetTokens, first)
+	if r.Allowed {
+		t.Fatal("Expected the company's budget to be used up by both keys")
+	}
+	if r.Limit != 4 {
+		t.Errorf("Expected the company limit 4 to be reported, got %d", r.Limit)
+	}
+
+	if r := l.Allow(BudgetTokens, other); !r.Allowed {
+		t.Error("Expected another company to be allowed")
+	}
+
+	// A limited request doesn't use up the caller's own bucket
+	l.budgets[BudgetTokens] = 0
+	if r := l.Allow(BudgetTokens, first); !r.Allowed || r.Remaining != 7 {
+		t.Errorf("Expected the key to have 7 requests left, got %+v", r)
+	}
+}
+
+func TestLimiterDefault(t *testing.T) {
+	l, _ := newTestLimiter(2, nil)
+	token := Caller{CompanyID: "acme", CredentialID: "token-1"}
+
+	l.Allow(BudgetRooms, token)
+	if r := l.Allow(BudgetRooms, token); !r.Allowed || r.Limit != 2 {
+		t.Errorf("Expected the default limit of 2 to allow a second request, got %+v", r)
+	}
+	if r := l.Allow(BudgetRooms, token); r.Allowed {
+		t.Error("Expected a caller without a limit to get the default")
+	}
+
+	unlimited, _ := newTestLimiter(0, nil)
+	for i := 0; i < 100; i++ {
+		if r := unlimited.Allow(BudgetAPI, token); !r.Allowed {
+			t.Fatalf("Expected request %d without limits to be allowed", i+1)
+		}
+	}
+}
+
+func TestLimiterFlush(t *testing.T) {
+	l, advance := newTestLimiter(0, nil)
+	start := l.now()
+	alice := Caller{CompanyID: "acme", CredentialID: "key-1", PerMinute: 2}
+
+	l.Allow(BudgetTokens, alice)
+	l.Allow(BudgetTokens, alice)
+	l.Allow(BudgetTokens, alice) // limited, not counted
+	l.Allow(BudgetRooms, alice)
+	advance(30 * time.Second)
+
+	usage := l.Flush()
+	if len(usage) != 2 {
+		t.Fatalf("Expected usage of 2 budgets, got %d", len(usage))
+	}
+	for _, u := range usage {
+		expected := map[string]int{BudgetTokens: 2, BudgetRooms: 1}[u.Budget]
+		if u.Requests != expected || u.CompanyID != "acme" || u.CredentialID != "key-1" {
+			t.Errorf("Expected %d requests of key-1 in %s, got %+v", expected, u.Budget, u)
+		}
+		if !u.WindowStart.Equal(start) || u.WindowEnd.Sub(u.WindowStart) != 30*time.Second {
+			t.Errorf("Expected a 30s window from %v, got %v to %v", start, u.WindowStart, u.WindowEnd)
+		}
+	}
+
+	if usage := l.Flush(); len(usage) != 0 {
+		t.Errorf("Expected no usage after flushing, got %+v", usage)
+	}
+
+	// Full buckets are dropped, partly used ones are kept
+	if _, ok := l.buckets[bucketKey{BudgetRooms, "key:key-1"}]; ok {
+		t.Error("Expected the refilled bucket to be dropped")
+	}
+	if _, ok := l.buckets[bucketKey{BudgetTokens, "key:key-1"}]; !ok {
+		t.Error("Expected the partly refilled bucket to be kept")
+	}
+}
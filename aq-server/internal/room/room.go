@@ -9,7 +9,7 @@ import (
 // Room represents a video conference room
 type Room struct {
 	ID    string
-	Peers map[*types.ThreadSafeWriter]*types.PeerConnectionState
+	Peers map[*types.PeerConnectionState]struct{} // Keyed by peer, which may have no websocket
 	mu    sync.RWMutex
 }
 
@@ -37,7 +37,7 @@ func (rm *RoomManager) GetOrCreateRoom(roomID string) *Room {
 
 	room := &Room{
 		ID:    roomID,
-		Peers: make(map[*types.ThreadSafeWriter]*types.PeerConnectionState),
+		Peers: make(map[*types.PeerConnectionState]struct{}),
 	}
 	rm.rooms[roomID] = room
 	return room
@@ -52,16 +52,16 @@ func (rm *RoomManager) GetRoom(roomID string) *Room {
 }
 
 // AddPeer adds a peer to a room
-func (rm *RoomManager) AddPeer(roomID string, ws *types.ThreadSafeWriter, pc *types.PeerConnectionState) {
+func (rm *RoomManager) AddPeer(roomID string, pc *types.PeerConnectionState) {
 	room := rm.GetOrCreateRoom(roomID)
 	room.mu.Lock()
 	defer room.mu.Unlock()
 
-	room.Peers[ws] = pc
+	room.Peers[pc] = struct{}{}
 }
 
 // RemovePeer removes a peer from a room
-func (rm *RoomManager) RemovePeer(roomID string, ws *types.ThreadSafeWriter) {
+func (rm *RoomManager) RemovePeer(roomID string, pc *types.PeerConnectionState) {
 	room := rm.GetRoom(roomID)
 	if room == nil {
 		return
@@ -70,7 +70,7 @@ func (rm *RoomManager) RemovePeer(roomID string, ws *types.ThreadSafeWriter) {
 	room.mu.Lock()
 	defer room.mu.Unlock()
 
-	delete(room.Peers, ws)
+	delete(room.Peers, pc)
 
 	// Delete room if empty
 	if len(room.Peers) == 0 {
@@ -81,7 +81,7 @@ func (rm *RoomManager) RemovePeer(roomID string, ws *types.ThreadSafeWriter) {
 }
 
 // GetPeersInRoom returns all peers in a room (excluding the caller if provided)
-func (rm *RoomManager) GetPeersInRoom(roomID string, excludeWS *types.ThreadSafeWriter) []*types.PeerConnectionState {
+func (rm *RoomManager) GetPeersInRoom(roomID string, exclude *types.PeerConnectionState) []*types.PeerConnectionState {
 	room := rm.GetRoom(roomID)
 	if room == nil {
 		return []*types.PeerConnectionState{}
@@ -91,8 +91,8 @@ func (rm *RoomManager) GetPeersInRoom(roomID string, excludeWS *types.ThreadSafe
 	defer room.mu.RUnlock()
 
 	peers := make([]*types.PeerConnectionState, 0, len(room.Peers))
-	for ws, pc := range room.Peers {
-		if excludeWS != nil && ws == excludeWS {
+	for pc := range room.Peers {
+		if exclude != nil && pc == exclude {
 			continue
 		}
 		peers = append(peers, pc)
@@ -14,11 +14,12 @@ import (
 
 // RouteHandlers holds the dependencies for route setup
 type RouteHandlers struct {
-	Logger            logging.LeveledLogger
-	IndexTemplate     *template.Template
-	DispatchKeyFrame  func()
-	GetPeerCount      func() int
-	RoomManager       *room.RoomManager // New: room management
+	Logger           logging.LeveledLogger
+	IndexTemplate    *template.Template
+	DispatchKeyFrame func()
+	GetPeerCount     func() int
+	RoomManager      *room.RoomManager // New: room management
+	WebsocketHandler *handlers.Handler // Signaling handler bound to an SFU engine
 }
 
 // HealthResponse represents the response for the health check endpoint
@@ -37,10 +38,10 @@ type RoomInfo struct {
 
 // RoomsResponse represents the response for the rooms endpoint
 type RoomsResponse struct {
-	Timestamp string     `json:"timestamp"`
-	Rooms     []RoomInfo `json:"rooms"`
-	TotalRooms int       `json:"total_rooms"`
-	TotalPeers int       `json:"total_peers"`
+	Timestamp  string     `json:"timestamp"`
+	Rooms      []RoomInfo `json:"rooms"`
+	TotalRooms int        `json:"total_rooms"`
+	TotalPeers int        `json:"total_peers"`
 }
 
 // Setup registers all HTTP routes
@@ -73,10 +74,10 @@ func Setup(routeHandlers *RouteHandlers) error {
 	// rooms endpoint - list all active rooms
 	http.HandleFunc("/rooms", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		
+
 		var rooms []RoomInfo
 		var totalPeers int
-		
+
 		if routeHandlers.RoomManager != nil {
 			allRooms := routeHandlers.RoomManager.GetAllRooms()
 			for roomID, peerCount := range allRooms {
@@ -87,14 +88,14 @@ func Setup(routeHandlers *RouteHandlers) error {
 				totalPeers += peerCount
 			}
 		}
-		
+
 		response := RoomsResponse{
 			Timestamp:  time.Now().UTC().Format(time.RFC3339),
 			Rooms:      rooms,
 			TotalRooms: len(rooms),
 			TotalPeers: totalPeers,
 		}
-		
+
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(response); err != nil {
 			routeHandlers.Logger.Errorf("Failed to encode rooms response: %v", err)
@@ -102,7 +103,7 @@ func Setup(routeHandlers *RouteHandlers) error {
 	})
 
 	// websocket handler
-	http.HandleFunc("/aq_server/websocket", handlers.WebsocketHandler)
+	http.HandleFunc("/aq_server/websocket", routeHandlers.WebsocketHandler.WebsocketHandler)
 
 	// index.html handler
 	http.HandleFunc("/aq_server/", func(w http.ResponseWriter, r *http.Request) {
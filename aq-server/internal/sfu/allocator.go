@@ -0,0 +1,105 @@
+package sfu
+
+import (
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// defaultAudioBitrate is reserved for an audio track whose bitrate is not measured yet
+	defaultAudioBitrate = 64_000
+
+	// pausedLayer is the allocation of a video track the link can't sustain
+	pausedLayer = -1
+)
+
+// bitrateRequest describes one down track competing for a subscriber's bandwidth
+type bitrateRequest struct {
+	kind     webrtc.RTPCodecType
+	layers   []LayerInfo // Ordered from lowest to highest quality
+	maxLayer int         // Highest layer worth sending for the rendered size
+	muted    bool        // Muted tracks are paused and cost nothing
+}
+
+// allocateBitrate distributes a subscriber's estimated downlink across its tracks
+// and returns the layer index allocated to each request, or pausedLayer.
+//
+// Audio is always served first. Every video track then gets its lowest layer while
+// the budget allows; tracks that don't fit are paused. What is left upgrades the
+// remaining video tracks one layer at a time, round robin, so bandwidth is shared
+// instead of going to the first track. A zero budget means the estimate is unknown
+// and every track gets the highest layer it asked for. Muted tracks are paused.
+func allocateBitrate(budget uint64, requests []bitrateRequest) []int {
+	allocation := make([]int, len(requests))
+
+	if budget == 0 {
+		for i, request := range requests {
+			allocation[i] = request.maxLayer
+			if request.muted {
+				allocation[i] = pausedLayer
+			}
+		}
+		return allocation
+	}
+
+	remaining := int64(budget)
+
+	// Audio has priority and is never paused unless muted
+	for i, request := range requests {
+		if request.muted {
+			allocation[i] = pausedLayer
+			continue
+		}
+
+		if request.kind != webrtc.RTPCodecTypeVideo {
+			allocation[i] = 0
+			remaining -= int64(audioBitrate(request.layers))
+		}
+	}
+
+	// Lowest layer for as many video tracks as fit
+	for i, request := range requests {
+		if request.kind != webrtc.RTPCodecTypeVideo || request.muted {
+			continue
+		}
+
+		allocation[i] = pausedLayer
+		if len(request.layers) == 0 {
+			continue
+		}
+
+		if cost := int64(request.layers[0].Bitrate); cost <= remaining {
+			allocation[i] = 0
+			remaining -= cost
+		}
+	}
+
+	// Upgrade video tracks one step at a time while the budget allows
+	for upgraded := true; upgraded; {
+		upgraded = false
+
+		for i, request := range requests {
+			current := allocation[i]
+			if request.kind != webrtc.RTPCodecTypeVideo || current == pausedLayer || current >= request.maxLayer {
+				continue
+			}
+
+			delta := int64(request.layers[current+1].Bitrate) - int64(request.layers[current].Bitrate)
+			if delta <= remaining {
+				allocation[i]++
+				remaining -= delta
+				upgraded = true
+			}
+		}
+	}
+
+	return allocation
+}
+
+// audioBitrate returns the measured bitrate of an audio track, or a default
+func audioBitrate(layers []LayerInfo) uint64 {
+	if len(layers) == 0 || layers[0].Bitrate == 0 {
+		return defaultAudioBitrate
+	}
+
+	return layers[0].Bitrate
+}
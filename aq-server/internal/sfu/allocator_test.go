@@ -0,0 +1,86 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func simulcastRequest(maxLayer int) bitrateRequest {
+	return bitrateRequest{
+		kind: webrtc.RTPCodecTypeVideo,
+		layers: []LayerInfo{
+			{RID: "q", Bitrate: 150_000},
+			{RID: "h", Bitrate: 500_000},
+			{RID: "f", Bitrate: 1_500_000},
+		},
+		maxLayer: maxLayer,
+	}
+}
+
+func audioRequest(bitrate uint64) bitrateRequest {
+	return bitrateRequest{
+		kind:   webrtc.RTPCodecTypeAudio,
+		layers: []LayerInfo{{Bitrate: bitrate}},
+	}
+}
+
+func mutedRequest(request bitrateRequest) bitrateRequest {
+	request.muted = true
+	return request
+}
+
+func TestAllocateBitrate(t *testing.T) {
+	tests := []struct {
+		name     string
+		budget   uint64
+		requests []bitrateRequest
+		expected []int
+	}{
+		{"unknown estimate is unconstrained", 0, []bitrateRequest{simulcastRequest(2), simulcastRequest(1)}, []int{2, 1}},
+		{"plenty of bandwidth", 10_000_000, []bitrateRequest{audioRequest(40_000), simulcastRequest(2)}, []int{0, 2}},
+		{"respects dimension cap", 10_000_000, []bitrateRequest{simulcastRequest(0)}, []int{0}},
+		{"shares upgrades round robin", 1_300_000, []bitrateRequest{simulcastRequest(2), simulcastRequest(2)}, []int{1, 1}},
+		{"pauses video that doesn't fit", 250_000, []bitrateRequest{audioRequest(40_000), simulcastRequest(2), simulcastRequest(2)}, []int{0, 0, pausedLayer}},
+		{"audio is never paused", 10_000, []bitrateRequest{audioRequest(40_000), simulcastRequest(2)}, []int{0, pausedLayer}},
+		{"unmeasured audio reserves default", 200_000, []bitrateRequest{audioRequest(0), simulcastRequest(2)}, []int{0, pausedLayer}},
+		{"muted tracks are paused for free", 700_000, []bitrateRequest{mutedRequest(audioRequest(40_000)), mutedRequest(simulcastRequest(2)), simulcastRequest(2)}, []int{pausedLayer, pausedLayer, 1}},
+		{"muted tracks are paused when unconstrained", 0, []bitrateRequest{mutedRequest(simulcastRequest(2))}, []int{pausedLayer}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := allocateBitrate(tt.budget, tt.requests)
+			if len(result) != len(tt.expected) {
+				t.Fatalf("Expected %d allocations, got %d", len(tt.expected), len(result))
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("Expected allocation %v, got %v", tt.expected, result)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSignificantChange(t *testing.T) {
+	tests := []struct {
+		previous uint64
+		current  uint64
+		expected bool
+	}{
+		{0, 1_000_000, true},
+		{1_000_000, 0, true},
+		{1_000_000, 1_030_000, false},
+		{1_000_000, 970_000, false},
+		{1_000_000, 1_100_000, true},
+		{1_000_000, 900_000, true},
+	}
+
+	for _, tt := range tests {
+		if result := significantChange(tt.previous, tt.current); result != tt.expected {
+			t.Errorf("Expected significantChange(%d, %d) = %v, got %v", tt.previous, tt.current, tt.expected, result)
+		}
+	}
+}
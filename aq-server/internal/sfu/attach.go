@@ -0,0 +1,65 @@
+package sfu
+
+import (
+	"sort"
+
+	"aq-server/internal/signaling"
+	"aq-server/internal/types"
+)
+
+// Attach forwards the tracks published in a peer's room, or only a participant's
+// when participant isn't empty, over the transceivers the peer offered to receive
+// on, if the peer may subscribe. It is meant for peers that negotiate once, such as
+// WHEP viewers, and must be called between applying their offer and creating the
+// answer. Each track takes a free transceiver of its kind; tracks that don't fit are
+// left out.
+func (e *Engine) Attach(peer *types.PeerConnectionState, participant string) []signaling.TrackInfo {
+	e.listLock.Lock()
+	defer e.listLock.Unlock()
+
+	attached := []signaling.TrackInfo{}
+
+	sub, ok := e.subscribers[peer]
+	if !ok || !peer.Permissions.Subscribe {
+		return attached
+	}
+
+	free := make(map[string]int)
+	for _, transceiver := range peer.PeerConnection.GetTransceivers() {
+		if transceiver.Sender() == nil {
+			free[transceiver.Kind().String()]++
+		}
+	}
+
+	tracks := []*PublishedTrack{}
+	for _, published := range e.tracks.TracksForSubscriber(peer) {
+		if participant == "" || published.Owner.Username == participant {
+			tracks = append(tracks, published)
+		}
+	}
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].ID < tracks[j].ID })
+
+	for _, published := range tracks {
+		kind := published.Kind.String()
+		if free[kind] == 0 {
+			continue
+		}
+
+		downTrack := newDownTrack(published, sub)
+		sender, err := peer.PeerConnection.AddTrack(downTrack)
+		if err != nil {
+			e.logger.Errorf("Failed to attach track %s to %s: %v", published.ID, peer.Username, err)
+			continue
+		}
+		free[kind]--
+
+		sub.choose([]string{published.ID}, true)
+		sub.addDownTrack(downTrack)
+		go downTrack.readRTCP(sender)
+
+		attached = append(attached, published.Info())
+		e.logger.Debugf("Attached track %s from %s to %s in room %s", published.ID, published.Owner.Username, peer.Username, peer.RoomID)
+	}
+
+	return attached
+}
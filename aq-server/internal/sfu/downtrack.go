@@ -0,0 +1,391 @@
+package sfu
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"aq-server/internal/types"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// DownTrack forwards one published track to one subscriber. It implements
+// webrtc.TrackLocal so each subscriber gets its own SSRC, sequence numbers and
+// timestamps, which lets the SFU pick a different simulcast layer per subscriber.
+type DownTrack struct {
+	published  *PublishedTrack
+	subscriber *subscriber
+
+	mu           sync.Mutex
+	bound        bool
+	ssrc         webrtc.SSRC
+	payloadType  webrtc.PayloadType
+	writeStream  webrtc.TrackLocalWriter
+	extensions   map[string]uint8 // Header extension IDs negotiated with the subscriber by URI
+	rtxSSRC      webrtc.SSRC      // 0 if the subscriber didn't negotiate RTX
+	rtxType      webrtc.PayloadType
+	rtxSN        uint16
+	sequencer    *sequencer
+	currentLayer string
+	targetLayer  string
+	paused       bool // Not forwarded because the subscriber's link can't sustain it
+	resuming     bool // Waiting for a keyframe after being paused
+	maxWidth     int
+	maxHeight    int
+	munger       rtpMunger
+
+	detectsKeyframes bool
+}
+
+// newDownTrack creates a down track of a published track for a subscriber
+func newDownTrack(published *PublishedTrack, sub *subscriber) *DownTrack {
+	return &DownTrack{
+		published:  published,
+		subscriber: sub,
+		munger:     rtpMunger{clockRate: published.Codec.ClockRate},
+		sequencer:  newSequencer(sequencerSize),
+
+		detectsKeyframes: detectsKeyframes(published.Codec.MimeType),
+	}
+}
+
+// Bind is called by the PeerConnection once the codec has been negotiated
+func (d *DownTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, ok := matchCodec(d.published.Codec, ctx.CodecParameters())
+	if !ok {
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+
+	extensions := make(map[string]uint8)
+	for _, extension := range ctx.HeaderExtensions() {
+		extensions[extension.URI] = uint8(extension.ID)
+	}
+
+	d.mu.Lock()
+	d.bound = true
+	d.ssrc = ctx.SSRC()
+	d.payloadType = codec.PayloadType
+	d.writeStream = ctx.WriteStream()
+	d.extensions = extensions
+	d.rtxSSRC = 0
+	if rtxType, ok := matchRTXCodec(codec.PayloadType, ctx.CodecParameters()); ok && ctx.SSRCRetransmission() != 0 {
+		d.rtxSSRC = ctx.SSRCRetransmission()
+		d.rtxType = rtxType
+	}
+	d.mu.Unlock()
+
+	// Start with a decodable picture
+	d.published.RequestKeyFrame(d.TargetLayer())
+
+	return codec, nil
+}
+
+// Unbind is called when the sender stops
+func (d *DownTrack) Unbind(webrtc.TrackLocalContext) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.bound = false
+
+	return nil
+}
+
+// ID is the track ID seen by the subscriber, the same as the publisher's
+func (d *DownTrack) ID() string { return d.published.ID }
+
+// RID is always empty: subscribers receive a single encoding
+func (d *DownTrack) RID() string { return "" }
+
+// StreamID is the stream ID seen by the subscriber, the same as the publisher's
+func (d *DownTrack) StreamID() string { return d.published.StreamID }
+
+// Kind returns whether this is an audio or video track
+func (d *DownTrack) Kind() webrtc.RTPCodecType { return d.published.Kind }
+
+// Published returns the track this down track forwards
+func (d *DownTrack) Published() *PublishedTrack { return d.published }
+
+// Subscriber returns the peer receiving this down track
+func (d *DownTrack) Subscriber() *types.PeerConnectionState { return d.subscriber.peer }
+
+// CurrentLayer returns the RID currently forwarded
+func (d *DownTrack) CurrentLayer() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.currentLayer
+}
+
+// TargetLayer returns the RID the down track is switching to
+func (d *DownTrack) TargetLayer() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.targetLayer
+}
+
+// setVideoConstraints records the largest dimensions the subscriber renders the track at
+func (d *DownTrack) setVideoConstraints(width, height int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.maxWidth = width
+	d.maxHeight = height
+}
+
+// bitrateRequest describes what this down track asks of the subscriber's bandwidth
+func (d *DownTrack) bitrateRequest() bitrateRequest {
+	layers := d.published.Layers()
+	muted := d.published.Muted()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return bitrateRequest{
+		kind:     d.published.Kind,
+		layers:   layers,
+		maxLayer: layerCap(layers, d.maxWidth, d.maxHeight),
+		muted:    muted,
+	}
+}
+
+// applyAllocation forwards the allocated layer, or pauses the track if the
+// allocation is pausedLayer. Switching layers or resuming requests a keyframe on
+// the new layer; forwarding switches over when it arrives.
+func (d *DownTrack) applyAllocation(layers []LayerInfo, allocation int) {
+	if d.published.Kind != webrtc.RTPCodecTypeVideo || len(layers) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	paused := allocation == pausedLayer
+	target := d.targetLayer
+	if !paused {
+		target = layers[allocation].RID
+	}
+
+	resumed := d.paused && !paused
+	changed := target != d.targetLayer
+	d.targetLayer = target
+	d.paused = paused
+	if resumed {
+		d.resuming = true
+	}
+	d.mu.Unlock()
+
+	if !paused && (changed || resumed) {
+		d.published.RequestKeyFrame(target)
+	}
+}
+
+// Paused reports whether the track is paused because the subscriber's link can't sustain it
+func (d *DownTrack) Paused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.paused
+}
+
+// writeRTP forwards a packet received on a layer if it is the one this subscriber gets
+func (d *DownTrack) writeRTP(rid string, pkt *rtp.Packet, keyframe bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.bound || d.paused {
+		return
+	}
+
+	if rid != d.currentLayer || d.resuming {
+		// Only switch layers or resume on a keyframe so the subscriber can decode the
+		// new picture. Codecs whose keyframes can't be detected switch right away.
+		if rid != d.targetLayer || (!keyframe && d.detectsKeyframes) {
+			return
+		}
+
+		d.currentLayer = rid
+		d.resuming = false
+		d.munger.switchSource()
+	}
+
+	header := d.rewriteHeader(&pkt.Header)
+	header.SequenceNumber, header.Timestamp = d.munger.update(pkt.SequenceNumber, pkt.Timestamp, time.Now())
+	d.sequencer.push(header.SequenceNumber, header.Timestamp, pkt.SequenceNumber, rid)
+
+	if n, err := d.writeStream.WriteRTP(&header, pkt.Payload); err == nil {
+		d.published.forwarded.Add(uint64(n))
+	}
+}
+
+// rewriteHeader returns a copy of a publisher's packet header for this subscriber.
+// Header extensions both sides negotiated are carried over under the subscriber's
+// IDs; those describing a single hop are dropped. Callers must hold d.mu.
+func (d *DownTrack) rewriteHeader(source *rtp.Header) rtp.Header {
+	header := *source
+	header.SSRC = uint32(d.ssrc)
+	header.PayloadType = uint8(d.payloadType)
+	header.Extension = false
+	header.ExtensionProfile = 0
+	header.Extensions = nil
+
+	for _, sourceID := range source.GetExtensionIDs() {
+		uri, ok := d.published.extensions[sourceID]
+		if !ok || hopByHopExtensions[uri] {
+			continue
+		}
+
+		if id, ok := d.extensions[uri]; ok {
+			_ = header.SetExtension(id, source.GetExtension(sourceID))
+		}
+	}
+
+	return header
+}
+
+// handleNACK answers a NACK from the subscriber with cached packets and asks the
+// publisher for the ones the cache lacks
+func (d *DownTrack) handleNACK(nack *rtcp.TransportLayerNack) {
+	// Map the subscriber's sequence numbers back to the source layers
+	d.mu.Lock()
+	tsOffset := d.munger.tsOffset
+	lost := make([]sequencedPacket, 0, len(nack.Nacks))
+	for _, pair := range nack.Nacks {
+		for _, outSN := range pair.PacketList() {
+			packet, ok := d.sequencer.get(outSN)
+			if !ok {
+				if !d.munger.initialized || d.munger.resync {
+					continue
+				}
+
+				// Never forwarded, most likely lost before reaching us. The timestamp is
+				// only known once the packet is found.
+				packet = sequencedPacket{
+					outSN:    outSN,
+					sourceSN: outSN + d.munger.snOffset,
+					rid:      d.currentLayer,
+				}
+			}
+
+			lost = append(lost, packet)
+		}
+	}
+	d.mu.Unlock()
+
+	missing := make(map[string][]uint16)
+	for _, packet := range lost {
+		cached, ok := d.published.packetFromCache(packet.rid, packet.sourceSN)
+		if !ok {
+			missing[packet.rid] = append(missing[packet.rid], packet.sourceSN)
+			continue
+		}
+
+		if !packet.valid {
+			packet.outTS = cached.Timestamp - tsOffset
+		}
+
+		d.retransmit(cached, packet.outSN, packet.outTS)
+	}
+
+	for rid, sequenceNumbers := range missing {
+		d.published.RequestRetransmission(rid, sequenceNumbers)
+	}
+}
+
+// retransmit resends a cached packet under the sequence number and timestamp it was
+// forwarded with, wrapped in RTX if the subscriber negotiated it
+func (d *DownTrack) retransmit(pkt *rtp.Packet, outSN uint16, outTS uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.bound {
+		return
+	}
+
+	header := d.rewriteHeader(&pkt.Header)
+	header.SequenceNumber = outSN
+	header.Timestamp = outTS
+	payload := pkt.Payload
+
+	if d.rtxSSRC != 0 {
+		// RFC 4588: the original sequence number precedes the original payload
+		payload = make([]byte, 2+len(pkt.Payload))
+		binary.BigEndian.PutUint16(payload, outSN)
+		copy(payload[2:], pkt.Payload)
+
+		header.SSRC = uint32(d.rtxSSRC)
+		header.PayloadType = uint8(d.rtxType)
+		header.SequenceNumber = d.rtxSN
+		d.rtxSN++
+	}
+
+	if n, err := d.writeStream.WriteRTP(&header, payload); err == nil {
+		d.published.forwarded.Add(uint64(n))
+	}
+}
+
+// readRTCP handles feedback sent by the subscriber for this down track
+func (d *DownTrack) readRTCP(sender *webrtc.RTPSender) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		for _, packet := range packets {
+			switch p := packet.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				d.published.RequestKeyFrame(d.CurrentLayer())
+			case *rtcp.TransportLayerNack:
+				d.handleNACK(p)
+			case *rtcp.ReceiverEstimatedMaximumBitrate:
+				d.subscriber.setREMBEstimate(uint64(p.Bitrate))
+			}
+		}
+	}
+}
+
+// matchRTXCodec finds the negotiated RTX payload type protecting a payload type
+func matchRTXCodec(payloadType webrtc.PayloadType, negotiated []webrtc.RTPCodecParameters) (webrtc.PayloadType, bool) {
+	apt := fmt.Sprintf("apt=%d", payloadType)
+
+	for _, codec := range negotiated {
+		if !strings.EqualFold(codec.MimeType, webrtc.MimeTypeRTX) {
+			continue
+		}
+
+		for _, parameter := range strings.Split(codec.SDPFmtpLine, ";") {
+			if strings.TrimSpace(parameter) == apt {
+				return codec.PayloadType, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// matchCodec finds the negotiated codec matching the publisher's codec
+func matchCodec(codec webrtc.RTPCodecParameters, negotiated []webrtc.RTPCodecParameters) (webrtc.RTPCodecParameters, bool) {
+	var fallback *webrtc.RTPCodecParameters
+
+	for i := range negotiated {
+		if !strings.EqualFold(negotiated[i].MimeType, codec.MimeType) {
+			continue
+		}
+		if negotiated[i].SDPFmtpLine == codec.SDPFmtpLine {
+			return negotiated[i], true
+		}
+		if fallback == nil {
+			fallback = &negotiated[i]
+		}
+	}
+
+	if fallback != nil {
+		return *fallback, true
+	}
+
+	return webrtc.RTPCodecParameters{}, false
+}
@@ -0,0 +1,83 @@
+package sfu
+
+import (
+	"errors"
+
+	"aq-server/internal/signaling"
+	"aq-server/internal/types"
+)
+
+var (
+	// ErrTrackNotFound is returned when no published track matches a request
+	ErrTrackNotFound = errors.New("track not found")
+
+	// ErrNotAllowed is returned when a peer's permissions don't allow a request
+	ErrNotAllowed = errors.New("not allowed")
+)
+
+// SetMuted mutes or unmutes tracks published in the actor's room and tells the room.
+// An empty participant means the actor's own tracks and an empty track ID means all
+// of the participant's tracks. Peers can mute and unmute their own tracks; admins can
+// also mute, but not unmute, other participants.
+func (e *Engine) SetMuted(actor *types.PeerConnectionState, participant, trackID string, muted bool) error {
+	e.listLock.RLock()
+	targets := []*PublishedTrack{}
+	remote := false
+	for _, published := range e.tracks.RoomTracks(actor.RoomKey()) {
+		if trackID != "" && published.ID != trackID {
+			continue
+		}
+
+		own := isSamePeer(published.Owner, actor)
+		if (participant == "" && !own) || (participant != "" && published.Owner.Username != participant) {
+			continue
+		}
+
+		targets = append(targets, published)
+		remote = remote || !own
+	}
+	e.listLock.RUnlock()
+
+	if len(targets) == 0 {
+		return ErrTrackNotFound
+	}
+
+	if remote && (!muted || !actor.Permissions.Admin) {
+		return ErrNotAllowed
+	}
+
+	updated := make(map[*types.PeerConnectionState]bool)
+	for _, published := range targets {
+		if !published.setMuted(muted) {
+			continue
+		}
+		updated[published.Owner] = true
+
+		e.reallocate(published)
+
+		event := signaling.TrackMuted{
+			TrackID:     published.ID,
+			Participant: published.Owner.Username,
+		}
+		if !isSamePeer(published.Owner, actor) {
+			event.MutedBy = actor.Username
+		}
+
+		name, action := signaling.TypeTrackUnmuted, "unmuted"
+		if muted {
+			name, action = signaling.TypeTrackMuted, "muted"
+		}
+
+		e.logger.Infof("Peer %s %s track %s of %s in room %s", actor.Username, action, published.ID, published.Owner.Username, actor.RoomID)
+
+		e.listLock.RLock()
+		e.broadcastEvent(actor.RoomKey(), nil, name, event)
+		e.listLock.RUnlock()
+	}
+
+	for owner := range updated {
+		e.broadcastParticipantUpdated(owner)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package sfu
+
+import (
+	"errors"
+	"testing"
+
+	"aq-server/internal/types"
+
+	"github.com/pion/logging"
+)
+
+func TestSetMuted(t *testing.T) {
+	engine := newTestEngine(t, logging.NewDefaultLoggerFactory().NewLogger("sfu-test"))
+
+	host := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "host", RoomID: "room-a", Permissions: types.Permissions{Admin: true}}
+	guest := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "guest", RoomID: "room-a"}
+	outsider := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "outsider", RoomID: "room-b", Permissions: types.Permissions{Admin: true}}
+
+	hostVideo := newTestTrack(host, "host-video")
+	guestVideo := newTestTrack(guest, "guest-video")
+	engine.tracks.Add(hostVideo)
+	engine.tracks.Add(guestVideo)
+
+	if err := engine.SetMuted(guest, "", "guest-video", true); err != nil {
+		t.Fatalf("Expected guest to mute own track, got %v", err)
+	}
+	if !guestVideo.Muted() {
+		t.Error("Expected guest video to be muted")
+	}
+
+	if err := engine.SetMuted(guest, "", "", false); err != nil {
+		t.Fatalf("Expected guest to unmute own tracks, got %v", err)
+	}
+	if guestVideo.Muted() {
+		t.Error("Expected guest video to be unmuted")
+	}
+
+	if err := engine.SetMuted(guest, "host", "", true); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Expected guest muting host to be denied, got %v", err)
+	}
+	if hostVideo.Muted() {
+		t.Error("Expected host video not to be muted by guest")
+	}
+
+	if err := engine.SetMuted(host, "guest", "", true); err != nil {
+		t.Fatalf("Expected host to mute guest, got %v", err)
+	}
+	if !guestVideo.Muted() {
+		t.Error("Expected guest video to be muted by host")
+	}
+
+	if err := engine.SetMuted(host, "guest", "", false); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Expected host unmuting guest to be denied, got %v", err)
+	}
+
+	if err := engine.SetMuted(outsider, "guest", "", true); !errors.Is(err, ErrTrackNotFound) {
+		t.Errorf("Expected host of another room not to find guest tracks, got %v", err)
+	}
+}
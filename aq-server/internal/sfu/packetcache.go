@@ -0,0 +1,122 @@
+package sfu
+
+import (
+	"github.com/pion/rtp"
+)
+
+const (
+	// packetCacheSize is how many packets of a video layer are kept for retransmission
+	packetCacheSize = 1024
+
+	// sequencerSize is how many forwarded packets a down track can map back to their source
+	sequencerSize = 1024
+)
+
+// cachedPacket is a received packet kept for retransmission
+type cachedPacket struct {
+	valid   bool
+	header  rtp.Header
+	payload []byte
+}
+
+// packetCache keeps the most recent packets of a layer by sequence number.
+// Callers serialize access.
+type packetCache struct {
+	packets []cachedPacket
+	highest uint16
+	started bool
+}
+
+// newPacketCache creates a cache holding up to size packets
+func newPacketCache(size int) *packetCache {
+	return &packetCache{packets: make([]cachedPacket, size)}
+}
+
+// push stores a copy of a packet. Packets older than the cache window are dropped.
+func (c *packetCache) push(pkt *rtp.Packet) {
+	sn := pkt.SequenceNumber
+
+	if !c.started {
+		c.started = true
+		c.highest = sn
+	}
+
+	if isNewerSequence(sn, c.highest) {
+		// Invalidate the slots skipped over by a gap so they don't return stale packets
+		if int(sn-c.highest) > len(c.packets) {
+			for i := range c.packets {
+				c.packets[i].valid = false
+			}
+		} else {
+			for missing := c.highest + 1; missing != sn; missing++ {
+				c.packets[int(missing)%len(c.packets)].valid = false
+			}
+		}
+		c.highest = sn
+	} else if c.highest-sn >= uint16(len(c.packets)) {
+		return
+	}
+
+	slot := &c.packets[int(sn)%len(c.packets)]
+	slot.valid = true
+	slot.header = pkt.Header.Clone()
+	slot.payload = append(slot.payload[:0], pkt.Payload...)
+}
+
+// get returns a copy of the cached packet with the given sequence number
+func (c *packetCache) get(sn uint16) (*rtp.Packet, bool) {
+	if !c.started || isNewerSequence(sn, c.highest) || c.highest-sn >= uint16(len(c.packets)) {
+		return nil, false
+	}
+
+	slot := &c.packets[int(sn)%len(c.packets)]
+	if !slot.valid || slot.header.SequenceNumber != sn {
+		return nil, false
+	}
+
+	return &rtp.Packet{
+		Header:  slot.header.Clone(),
+		Payload: append([]byte(nil), slot.payload...),
+	}, true
+}
+
+// sequencedPacket maps a forwarded sequence number back to the packet it came from
+type sequencedPacket struct {
+	valid    bool
+	outSN    uint16
+	outTS    uint32
+	sourceSN uint16
+	rid      string
+}
+
+// sequencer remembers the source of the packets a down track forwarded, so NACKs
+// from the subscriber can be answered across layer switches. Callers serialize access.
+type sequencer struct {
+	packets []sequencedPacket
+}
+
+// newSequencer creates a sequencer remembering up to size packets
+func newSequencer(size int) *sequencer {
+	return &sequencer{packets: make([]sequencedPacket, size)}
+}
+
+// push records a forwarded packet
+func (s *sequencer) push(outSN uint16, outTS uint32, sourceSN uint16, rid string) {
+	s.packets[int(outSN)%len(s.packets)] = sequencedPacket{
+		valid:    true,
+		outSN:    outSN,
+		outTS:    outTS,
+		sourceSN: sourceSN,
+		rid:      rid,
+	}
+}
+
+// get returns the packet forwarded with the given sequence number
+func (s *sequencer) get(outSN uint16) (sequencedPacket, bool) {
+	packet := s.packets[int(outSN)%len(s.packets)]
+	if !packet.valid || packet.outSN != outSN {
+		return sequencedPacket{}, false
+	}
+
+	return packet, true
+}
@@ -0,0 +1,116 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+func newTestPacket(sn uint16) *rtp.Packet {
+	return &rtp.Packet{
+		Header:  rtp.Header{SequenceNumber: sn, Timestamp: uint32(sn) * 3000},
+		Payload: []byte{byte(sn), byte(sn >> 8)},
+	}
+}
+
+func TestPacketCacheGet(t *testing.T) {
+	cache := newPacketCache(8)
+
+	for sn := uint16(65530); sn != 4; sn++ {
+		cache.push(newTestPacket(sn))
+	}
+
+	pkt, ok := cache.get(65535)
+	if !ok {
+		t.Fatal("Expected packet 65535 to be cached")
+	}
+	if pkt.SequenceNumber != 65535 || pkt.Payload[0] != 0xff {
+		t.Errorf("Expected packet 65535, got %d", pkt.SequenceNumber)
+	}
+
+	if _, ok := cache.get(65530); ok {
+		t.Error("Expected packet 65530 to be evicted")
+	}
+
+	if _, ok := cache.get(10); ok {
+		t.Error("Expected packet 10 not to be cached yet")
+	}
+}
+
+func TestPacketCacheCopiesPackets(t *testing.T) {
+	cache := newPacketCache(8)
+
+	pkt := newTestPacket(1)
+	cache.push(pkt)
+	pkt.Payload[0] = 0xaa
+
+	cached, ok := cache.get(1)
+	if !ok {
+		t.Fatal("Expected packet 1 to be cached")
+	}
+	if cached.Payload[0] != 1 {
+		t.Errorf("Expected cached payload to be copied, got %x", cached.Payload[0])
+	}
+}
+
+func TestPacketCacheGap(t *testing.T) {
+	cache := newPacketCache(8)
+
+	cache.push(newTestPacket(1))
+	cache.push(newTestPacket(2))
+	cache.push(newTestPacket(5))
+
+	if _, ok := cache.get(3); ok {
+		t.Error("Expected lost packet 3 not to be cached")
+	}
+
+	// A late packet within the window is cached
+	cache.push(newTestPacket(3))
+	if _, ok := cache.get(3); !ok {
+		t.Error("Expected late packet 3 to be cached")
+	}
+
+	// A jump past the window drops everything
+	cache.push(newTestPacket(100))
+	if _, ok := cache.get(5); ok {
+		t.Error("Expected packet 5 to be dropped")
+	}
+	if _, ok := cache.get(100); !ok {
+		t.Error("Expected packet 100 to be cached")
+	}
+}
+
+func TestSequencer(t *testing.T) {
+	seq := newSequencer(4)
+
+	seq.push(10, 1000, 500, "h")
+	packet, ok := seq.get(10)
+	if !ok {
+		t.Fatal("Expected packet 10 to be found")
+	}
+	if packet.sourceSN != 500 || packet.rid != "h" || packet.outTS != 1000 {
+		t.Errorf("Expected source 500 on layer h, got %d on layer %q", packet.sourceSN, packet.rid)
+	}
+
+	seq.push(14, 2000, 504, "h")
+	if _, ok := seq.get(10); ok {
+		t.Error("Expected packet 10 to be overwritten")
+	}
+}
+
+func TestMatchRTXCodec(t *testing.T) {
+	negotiated := []webrtc.RTPCodecParameters{
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, PayloadType: 9},
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeRTX, SDPFmtpLine: "apt=96"}, PayloadType: 97},
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeRTX, SDPFmtpLine: "apt=9"}, PayloadType: 10},
+	}
+
+	if payloadType, ok := matchRTXCodec(9, negotiated); !ok || payloadType != 10 {
+		t.Errorf("Expected RTX payload type 10, got %d", payloadType)
+	}
+
+	if _, ok := matchRTXCodec(102, negotiated); ok {
+		t.Error("Expected no RTX payload type for 102")
+	}
+}
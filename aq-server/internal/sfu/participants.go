@@ -0,0 +1,87 @@
+package sfu
+
+import (
+	"sort"
+
+	"aq-server/internal/quota"
+	"aq-server/internal/signaling"
+	"aq-server/internal/types"
+)
+
+// participantInfo describes a peer and the tracks it publishes. Callers must hold
+// the list lock.
+func (e *Engine) participantInfo(peer *types.PeerConnectionState) signaling.Participant {
+	participant := signaling.Participant{
+		Participant: peer.Username,
+		UserType:    peer.UserType,
+		Tracks:      []signaling.TrackInfo{},
+	}
+
+	for _, published := range e.tracks.RoomTracks(peer.RoomKey()) {
+		if isSamePeer(published.Owner, peer) {
+			participant.Tracks = append(participant.Tracks, published.Info())
+		}
+	}
+
+	sort.Slice(participant.Tracks, func(i, j int) bool {
+		return participant.Tracks[i].TrackID < participant.Tracks[j].TrackID
+	})
+
+	return participant
+}
+
+// roomSnapshot describes everyone already in a peer's room. Callers must hold the
+// list lock.
+func (e *Engine) roomSnapshot(peer *types.PeerConnectionState) signaling.RoomSnapshot {
+	snapshot := signaling.RoomSnapshot{
+		RoomID:       peer.RoomID,
+		Participants: []signaling.Participant{},
+	}
+
+	for _, other := range e.roomManager.GetPeersInRoom(peer.RoomKey(), peer) {
+		if isSamePeer(other, peer) {
+			continue
+		}
+
+		snapshot.Participants = append(snapshot.Participants, e.participantInfo(other))
+	}
+
+	sort.Slice(snapshot.Participants, func(i, j int) bool {
+		return snapshot.Participants[i].Participant < snapshot.Participants[j].Participant
+	})
+
+	return snapshot
+}
+
+// broadcastParticipantUpdated tells the other peers in a room that a peer's
+// published tracks changed
+func (e *Engine) broadcastParticipantUpdated(peer *types.PeerConnectionState) {
+	e.listLock.RLock()
+	defer e.listLock.RUnlock()
+
+	e.broadcastEvent(peer.RoomKey(), peer, signaling.TypeParticipantUpdated, e.participantInfo(peer))
+}
+
+// Usage counts the participants of a company in the engine, in total and by room.
+// Participants that may publish count as the room's publishers.
+func (e *Engine) Usage(companyID string) quota.Usage {
+	e.listLock.RLock()
+	defer e.listLock.RUnlock()
+
+	usage := quota.Usage{Rooms: make(map[string]quota.RoomUsage)}
+	for _, peer := range e.peers {
+		if peer.CompanyID != companyID {
+			continue
+		}
+
+		room := usage.Rooms[peer.RoomID]
+		room.Participants++
+		if peer.Permissions.Publish {
+			room.Publishers++
+		}
+		usage.Rooms[peer.RoomID] = room
+		usage.Participants++
+	}
+
+	return usage
+}
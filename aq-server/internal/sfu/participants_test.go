@@ -0,0 +1,99 @@
+package sfu
+
+import (
+	"testing"
+
+	"aq-server/internal/quota"
+	"aq-server/internal/types"
+
+	"github.com/pion/logging"
+)
+
+func TestRoomSnapshot(t *testing.T) {
+	engine := newTestEngine(t, logging.NewDefaultLoggerFactory().NewLogger("sfu-test"))
+
+	alice := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "alice", RoomID: "room-a", UserType: "host"}
+	bob := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "bob", RoomID: "room-a", UserType: "guest"}
+	carol := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "carol", RoomID: "room-b", UserType: "guest"}
+
+	for _, peer := range []*types.PeerConnectionState{alice, bob, carol} {
+		engine.roomManager.AddPeer(peer.RoomKey(), peer)
+	}
+
+	engine.tracks.Add(newTestTrack(alice, "alice-video"))
+	engine.tracks.Add(newTestTrack(carol, "carol-video"))
+
+	snapshot := engine.roomSnapshot(bob)
+	if snapshot.RoomID != "room-a" {
+		t.Errorf("Expected room-a, got %s", snapshot.RoomID)
+	}
+	if len(snapshot.Participants) != 1 {
+		t.Fatalf("Expected 1 participant, got %d", len(snapshot.Participants))
+	}
+
+	participant := snapshot.Participants[0]
+	if participant.Participant != "alice" || participant.UserType != "host" {
+		t.Errorf("Expected alice as host, got %s as %s", participant.Participant, participant.UserType)
+	}
+	if len(participant.Tracks) != 1 || participant.Tracks[0].TrackID != "alice-video" {
+		t.Errorf("Expected alice to publish alice-video, got %+v", participant.Tracks)
+	}
+
+	if tracks := engine.participantInfo(bob).Tracks; len(tracks) != 0 {
+		t.Errorf("Expected bob to publish nothing, got %d tracks", len(tracks))
+	}
+}
+
+func TestRoomSnapshotSeparatesCompanies(t *testing.T) {
+	engine := newTestEngine(t, logging.NewDefaultLoggerFactory().NewLogger("sfu-test"))
+
+	alice := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "alice", RoomID: "lobby", CompanyID: "acme"}
+	bob := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "bob", RoomID: "lobby", CompanyID: "acme"}
+	mallory := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "mallory", RoomID: "lobby", CompanyID: "globex"}
+
+	for _, peer := range []*types.PeerConnectionState{alice, bob, mallory} {
+		engine.roomManager.AddPeer(peer.RoomKey(), peer)
+	}
+
+	engine.tracks.Add(newTestTrack(alice, "alice-video"))
+	engine.tracks.Add(newTestTrack(mallory, "mallory-video"))
+
+	snapshot := engine.roomSnapshot(bob)
+	if len(snapshot.Participants) != 1 || snapshot.Participants[0].Participant != "alice" {
+		t.Fatalf("Expected only alice in acme's lobby, got %+v", snapshot.Participants)
+	}
+
+	if tracks := engine.tracks.TracksForSubscriber(mallory); len(tracks) != 0 {
+		t.Errorf("Expected globex's lobby to have no tracks for mallory, got %d", len(tracks))
+	}
+}
+
+func TestEngineUsage(t *testing.T) {
+	engine := newTestEngine(t, logging.NewDefaultLoggerFactory().NewLogger("sfu-test"))
+
+	alice := newTestPeer(t, "alice", "lobby")
+	viewer := newTestPeer(t, "viewer", "lobby")
+	viewer.Permissions.Publish = false
+	bob := newTestPeer(t, "bob", "standup")
+	other := newTestPeer(t, "mallory", "lobby")
+	other.CompanyID = "globex"
+
+	for _, peer := range []*types.PeerConnectionState{alice, viewer, bob} {
+		peer.CompanyID = "acme"
+		engine.Join(peer)
+	}
+	engine.Join(other)
+
+	usage := engine.Usage("acme")
+	if usage.Participants != 3 || len(usage.Rooms) != 2 {
+		t.Fatalf("Expected 3 participants in 2 rooms, got %+v", usage)
+	}
+	if lobby := usage.Rooms["lobby"]; lobby != (quota.RoomUsage{Participants: 2, Publishers: 1}) {
+		t.Errorf("Expected 2 participants and 1 publisher in the lobby, got %+v", lobby)
+	}
+
+	engine.Leave(bob)
+	if usage := engine.Usage("acme"); usage.Participants != 2 || len(usage.Rooms) != 1 {
+		t.Errorf("Expected 2 participants in 1 room after leaving, got %+v", usage)
+	}
+}
@@ -0,0 +1,112 @@
+package sfu
+
+import (
+	"aq-server/internal/types"
+)
+
+// TrackRegistry records which peer published each forwarded track, keyed by room.
+// It has no lock of its own; callers must hold the engine list lock.
+type TrackRegistry struct {
+	rooms map[string]map[string]*PublishedTrack
+}
+
+// NewTrackRegistry creates an empty track registry
+func NewTrackRegistry() *TrackRegistry {
+	return &TrackRegistry{
+		rooms: make(map[string]map[string]*PublishedTrack),
+	}
+}
+
+// Add registers a published track in its room. Track IDs are chosen by clients, so a
+// track whose ID another peer's track in the room already has isn't registered; Add
+// reports whether the track was.
+func (r *TrackRegistry) Add(published *PublishedTrack) bool {
+	tracks, exists := r.rooms[published.RoomID]
+	if !exists {
+		tracks = make(map[string]*PublishedTrack)
+		r.rooms[published.RoomID] = tracks
+	}
+
+	if registered, taken := tracks[published.ID]; taken && !isSamePeer(registered.Owner, published.Owner) {
+		return false
+	}
+
+	tracks[published.ID] = published
+	return true
+}
+
+// Remove unregisters a track, deleting the room entry once it has no tracks left
+func (r *TrackRegistry) Remove(roomID, trackID string) {
+	tracks, exists := r.rooms[roomID]
+	if !exists {
+		return
+	}
+
+	delete(tracks, trackID)
+
+	if len(tracks) == 0 {
+		delete(r.rooms, roomID)
+	}
+}
+
+// Get returns a track by room and ID, or nil if it is not registered
+func (r *TrackRegistry) Get(roomID, trackID string) *PublishedTrack {
+	return r.rooms[roomID][trackID]
+}
+
+// RoomTracks returns all tracks published in a room
+func (r *TrackRegistry) RoomTracks(roomID string) []*PublishedTrack {
+	tracks := r.rooms[roomID]
+
+	result := make([]*PublishedTrack, 0, len(tracks))
+	for _, track := range tracks {
+		result = append(result, track)
+	}
+
+	return result
+}
+
+// RoomIDs returns the rooms with published tracks
+func (r *TrackRegistry) RoomIDs() []string {
+	roomIDs := make([]string, 0, len(r.rooms))
+	for roomID := range r.rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+
+	return roomIDs
+}
+
+// TracksForSubscriber returns the tracks a peer should receive: every track in
+// its own room except the ones it published itself
+func (r *TrackRegistry) TracksForSubscriber(peer *types.PeerConnectionState) map[string]*PublishedTrack {
+	result := make(map[string]*PublishedTrack)
+
+	for trackID, track := range r.rooms[peer.RoomKey()] {
+		if isSamePeer(track.Owner, peer) {
+			continue
+		}
+		result[trackID] = track
+	}
+
+	return result
+}
+
+// Count returns the total number of registered tracks across all rooms
+func (r *TrackRegistry) Count() int {
+	count := 0
+	for _, tracks := range r.rooms {
+		count += len(tracks)
+	}
+
+	return count
+}
+
+// isSamePeer reports whether two peer states refer to the same participant.
+// Peers are identified by their websocket, matching the rest of the SFU.
+func isSamePeer(a, b *types.PeerConnectionState) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	return a == b || (a.Websocket != nil && a.Websocket == b.Websocket)
+}
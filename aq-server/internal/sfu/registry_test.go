@@ -0,0 +1,101 @@
+package sfu
+
+import (
+	"testing"
+
+	"aq-server/internal/types"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func newTestTrack(owner *types.PeerConnectionState, id string) *PublishedTrack {
+	return &PublishedTrack{
+		ID:         id,
+		StreamID:   "stream-" + id,
+		Kind:       webrtc.RTPCodecTypeVideo,
+		Owner:      owner,
+		RoomID:     owner.RoomKey(),
+		layers:     make(map[string]*layer),
+		downTracks: make(map[*DownTrack]struct{}),
+	}
+}
+
+func TestTracksForSubscriberIsRoomScoped(t *testing.T) {
+	registry := NewTrackRegistry()
+
+	alice := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "alice", RoomID: "room-a"}
+	bob := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "bob", RoomID: "room-a"}
+	carol := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "carol", RoomID: "room-b"}
+
+	registry.Add(newTestTrack(alice, "alice-video"))
+	registry.Add(newTestTrack(carol, "carol-video"))
+
+	bobTracks := registry.TracksForSubscriber(bob)
+	if len(bobTracks) != 1 {
+		t.Fatalf("Expected bob to receive 1 track, got %d", len(bobTracks))
+	}
+	if _, ok := bobTracks["alice-video"]; !ok {
+		t.Error("Expected bob to receive alice-video")
+	}
+
+	if tracks := registry.TracksForSubscriber(alice); len(tracks) != 0 {
+		t.Errorf("Expected alice not to receive her own track, got %d tracks", len(tracks))
+	}
+
+	if tracks := registry.TracksForSubscriber(carol); len(tracks) != 0 {
+		t.Errorf("Expected carol to receive no tracks from room-a, got %d tracks", len(tracks))
+	}
+}
+
+func TestTrackRegistryOwnerMatchesByWebsocket(t *testing.T) {
+	registry := NewTrackRegistry()
+
+	ws := &types.ThreadSafeWriter{}
+	owner := &types.PeerConnectionState{Websocket: ws, RoomID: "room-a"}
+	registry.Add(newTestTrack(owner, "video"))
+
+	// Another state sharing the owner's websocket is still the owner
+	ownerCopy := *owner
+	if tracks := registry.TracksForSubscriber(&ownerCopy); len(tracks) != 0 {
+		t.Errorf("Expected copy of owner to receive no tracks, got %d", len(tracks))
+	}
+}
+
+func TestTrackRegistryRemove(t *testing.T) {
+	registry := NewTrackRegistry()
+
+	owner := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, RoomID: "room-a"}
+	registry.Add(newTestTrack(owner, "video"))
+	registry.Add(newTestTrack(owner, "audio"))
+
+	if registry.Count() != 2 {
+		t.Errorf("Expected 2 tracks, got %d", registry.Count())
+	}
+
+	registry.Remove("room-a", "video")
+	if registry.Get("room-a", "video") != nil {
+		t.Error("Expected video track to be removed")
+	}
+
+	registry.Remove("room-a", "audio")
+	if len(registry.rooms) != 0 {
+		t.Errorf("Expected empty room to be deleted, got %d rooms", len(registry.rooms))
+	}
+}
+
+func TestTrackRegistryKeepsOwnerOfTrackID(t *testing.T) {
+	registry := NewTrackRegistry()
+
+	alice := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "alice", RoomID: "room-a"}
+	mallory := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "mallory", RoomID: "room-a"}
+
+	if !registry.Add(newTestTrack(alice, "video")) {
+		t.Fatal("Expected alice's track to be registered")
+	}
+	if registry.Add(newTestTrack(mallory, "video")) {
+		t.Error("Expected mallory's track with the ID of alice's not to be registered")
+	}
+	if published := registry.Get("room-a", "video"); published == nil || published.Owner != alice {
+		t.Errorf("Expected the track to still be alice's, got %+v", published)
+	}
+}
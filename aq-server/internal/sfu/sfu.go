@@ -1,138 +1,637 @@
 package sfu
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
+	"aq-server/internal/metering"
 	"aq-server/internal/room"
+	"aq-server/internal/signaling"
 	"aq-server/internal/types"
+	"aq-server/internal/webhook"
 
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
 	"github.com/pion/logging"
 	"github.com/pion/rtcp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v4"
 )
 
-// SFUContext holds the state needed by SFU functions
-type SFUContext struct {
-	Logger          logging.LeveledLogger
-	ListLock        sync.RWMutex
-	PeerConnections *[]types.PeerConnectionState
-	TrackLocals     *map[string]*webrtc.TrackLocalStaticRTP
-	RoomManager     *room.RoomManager // New: room management
+// Bandwidth estimation bounds for each subscriber's downlink, in bits per second
+const (
+	initialBitrate = 1_000_000
+	minBitrate     = 30_000
+	maxBitrate     = 20_000_000
+)
+
+// Engine is a single SFU instance. It owns the peers, published tracks and rooms
+// it forwards media between, so several engines can run in one process.
+type Engine struct {
+	logger      logging.LeveledLogger
+	api         *webrtc.API
+	listLock    sync.RWMutex
+	pcLock      sync.Mutex                                       // Serializes PeerConnection creation to pair estimators
+	newEstimate chan cc.BandwidthEstimator                       // Estimator of the PeerConnection being created
+	estimators  map[*webrtc.PeerConnection]cc.BandwidthEstimator // Estimators of PeerConnections not joined yet
+	peers       []*types.PeerConnectionState
+	subscribers map[*types.PeerConnectionState]*subscriber
+	publishers  map[*types.PeerConnectionState]*publisher
+	tracks      *TrackRegistry      // Published tracks with their owners, keyed by room
+	roomManager *room.RoomManager   // Room membership
+	meter       *metering.Meter     // Meters participants and tracks, nil if not metered
+	webhooks    *webhook.Dispatcher // Notifies companies of room events, nil if not notified
+	done        chan struct{}       // Closed when the engine is closed
+	closeOnce   sync.Once
 }
 
-var sfuCtx *SFUContext
+// NewEngine creates an SFU engine. A new room manager is created if none is given.
+func NewEngine(logger logging.LeveledLogger, roomManager *room.RoomManager) (*Engine, error) {
+	if roomManager == nil {
+		roomManager = room.NewRoomManager()
+	}
 
-// InitContext initializes the SFU context
-func InitContext(ctx *SFUContext) {
-	sfuCtx = ctx
+	newEstimate := make(chan cc.BandwidthEstimator, 1)
+	api, err := newAPI(newEstimate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Engine{
+		logger:      logger,
+		api:         api,
+		newEstimate: newEstimate,
+		estimators:  make(map[*webrtc.PeerConnection]cc.BandwidthEstimator),
+		peers:       []*types.PeerConnectionState{},
+		subscribers: make(map[*types.PeerConnectionState]*subscriber),
+		publishers:  make(map[*types.PeerConnectionState]*publisher),
+		tracks:      NewTrackRegistry(),
+		roomManager: roomManager,
+		done:        make(chan struct{}),
+	}, nil
 }
 
-// GetPeerCount returns the number of active peer connections
-func GetPeerCount() int {
-	if sfuCtx == nil {
-		return 0
+// newAPI creates the WebRTC API used for every PeerConnection of an engine.
+// The RID header extensions are registered so publishers can send simulcast, and
+// a send-side bandwidth estimator is created per PeerConnection and handed over on
+// newEstimate so forwarding can adapt to each subscriber's downlink.
+func newAPI(newEstimate chan<- cc.BandwidthEstimator) (*webrtc.API, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, fmt.Errorf("failed to register codecs: %w", err)
+	}
+
+	for _, extension := range []string{
+		sdp.SDESMidURI,
+		sdp.SDESRTPStreamIDURI,
+		sdp.SDESRepairRTPStreamIDURI,
+	} {
+		if err := mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: extension}, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, fmt.Errorf("failed to register header extension %s: %w", extension, err)
+		}
+	}
+
+	// Audio levels drive active speaker detection
+	if err := mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.AudioLevelURI}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, fmt.Errorf("failed to register header extension %s: %w", sdp.AudioLevelURI, err)
 	}
 
-	sfuCtx.ListLock.RLock()
-	defer sfuCtx.ListLock.RUnlock()
+	interceptorRegistry := &interceptor.Registry{}
+
+	congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(initialBitrate),
+			gcc.SendSideBWEMinBitrate(minBitrate),
+			gcc.SendSideBWEMaxBitrate(maxBitrate),
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+		)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create congestion controller: %w", err)
+	}
+	congestionController.OnNewPeerConnection(func(_ string, estimator cc.BandwidthEstimator) {
+		newEstimate <- estimator
+	})
+	interceptorRegistry.Add(congestionController)
 
-	return len(*sfuCtx.PeerConnections)
+	if err := webrtc.ConfigureTWCCHeaderExtensionSender(mediaEngine, interceptorRegistry); err != nil {
+		return nil, fmt.Errorf("failed to register TWCC header extension: %w", err)
+	}
+
+	// The default interceptors minus NACK handling: down tracks answer NACKs from
+	// their packet cache and only ask publishers for what it lacks
+	mediaEngine.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
+	mediaEngine.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "pli"}, webrtc.RTPCodecTypeVideo)
+
+	if err := webrtc.ConfigureRTCPReports(interceptorRegistry); err != nil {
+		return nil, fmt.Errorf("failed to register RTCP reports: %w", err)
+	}
+
+	if err := webrtc.ConfigureSimulcastExtensionHeaders(mediaEngine); err != nil {
+		return nil, fmt.Errorf("failed to register simulcast header extensions: %w", err)
+	}
+
+	if err := webrtc.ConfigureTWCCSender(mediaEngine, interceptorRegistry); err != nil {
+		return nil, fmt.Errorf("failed to register TWCC feedback: %w", err)
+	}
+
+	return webrtc.NewAPI(
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
+	), nil
+}
+
+// NewPeerConnection creates a PeerConnection configured for this engine. Its
+// bandwidth estimator is kept until the peer joins.
+func (e *Engine) NewPeerConnection(configuration webrtc.Configuration) (*webrtc.PeerConnection, error) {
+	e.pcLock.Lock()
+	defer e.pcLock.Unlock()
+
+	pc, err := e.api.NewPeerConnection(configuration)
+
+	// The estimator is created even if the PeerConnection later fails
+	var estimator cc.BandwidthEstimator
+	select {
+	case estimator = <-e.newEstimate:
+	default:
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Forget estimators of PeerConnections that were closed without joining
+	for candidate := range e.estimators {
+		if candidate.ConnectionState() == webrtc.PeerConnectionStateClosed {
+			delete(e.estimators, candidate)
+		}
+	}
+
+	if estimator != nil {
+		e.estimators[pc] = estimator
+	}
+
+	return pc, nil
+}
+
+// SetMeter meters the usage of the engine's participants and tracks. Set it before
+// peers join.
+func (e *Engine) SetMeter(meter *metering.Meter) {
+	e.meter = meter
+}
+
+// SetWebhooks notifies companies of rooms starting and finishing, participants joining
+// and leaving, and tracks being published. Set it before peers join.
+func (e *Engine) SetWebhooks(webhooks *webhook.Dispatcher) {
+	e.webhooks = webhooks
+}
+
+// RoomManager returns the room manager used by this engine
+func (e *Engine) RoomManager() *room.RoomManager {
+	return e.roomManager
+}
+
+// PeerCount returns the number of active peer connections
+func (e *Engine) PeerCount() int {
+	e.listLock.RLock()
+	defer e.listLock.RUnlock()
+
+	return len(e.peers)
+}
+
+// Join adds a peer to the engine and to its room. Call SignalPeerConnections
+// afterwards to start forwarding the room's tracks to it.
+func (e *Engine) Join(peer *types.PeerConnectionState) {
+	e.pcLock.Lock()
+	estimator, ok := e.estimators[peer.PeerConnection]
+	delete(e.estimators, peer.PeerConnection)
+	e.pcLock.Unlock()
+
+	sub := newSubscriber(peer)
+	if ok {
+		sub.setEstimator(estimator)
+	}
+
+	e.listLock.Lock()
+	e.peers = append(e.peers, peer)
+	e.subscribers[peer] = sub
+	e.publishers[peer] = newPublisher()
+	e.listLock.Unlock()
+
+	e.meter.Join(peer, peer.CompanyID, peer.RoomID)
+
+	e.roomManager.AddPeer(peer.RoomKey(), peer)
+	count := e.roomManager.GetRoomPeerCount(peer.RoomKey())
+	e.logger.Infof("Peer %s added to room %s (total: %d)", peer.Username, peer.RoomID, count)
+
+	if count == 1 {
+		e.webhooks.Notify(peer.CompanyID, webhook.EventRoomStarted, map[string]any{"room_id": peer.RoomID})
+	}
+	e.webhooks.Notify(peer.CompanyID, webhook.EventParticipantJoined, participantData(peer))
+
+	// Tell the peer who is already here and what it can subscribe to, and tell
+	// everyone else about the peer
+	e.listLock.RLock()
+	if peer.Websocket != nil {
+		e.sendEvent(peer, signaling.TypeRoomSnapshot, e.roomSnapshot(peer))
+	}
+	e.broadcastEvent(peer.RoomKey(), peer, signaling.TypeParticipantJoined, e.participantInfo(peer))
+	e.listLock.RUnlock()
+}
+
+// Leave removes a peer from the engine and its room, then renegotiates the remaining peers
+func (e *Engine) Leave(peer *types.PeerConnectionState) {
+	e.listLock.Lock()
+	joined := false
+	for i := range e.peers {
+		if e.peers[i] == peer {
+			e.peers = append(e.peers[:i], e.peers[i+1:]...)
+			joined = true
+			break
+		}
+	}
+	if sub, ok := e.subscribers[peer]; ok {
+		sub.close()
+		delete(e.subscribers, peer)
+	}
+	delete(e.publishers, peer)
+	if joined {
+		e.broadcastEvent(peer.RoomKey(), peer, signaling.TypeParticipantLeft, signaling.Participant{
+			Participant: peer.Username,
+			UserType:    peer.UserType,
+		})
+	}
+	e.listLock.Unlock()
+
+	e.meter.Leave(peer)
+	e.roomManager.RemovePeer(peer.RoomKey(), peer)
+
+	if joined {
+		e.webhooks.Notify(peer.CompanyID, webhook.EventParticipantLeft, participantData(peer))
+		if e.roomManager.GetRoomPeerCount(peer.RoomKey()) == 0 {
+			e.webhooks.Notify(peer.CompanyID, webhook.EventRoomFinished, map[string]any{"room_id": peer.RoomID})
+		}
+	}
+
+	e.SignalPeerConnections()
+}
+
+// Close closes every peer connection and websocket owned by the engine
+func (e *Engine) Close() {
+	e.closeOnce.Do(func() { close(e.done) })
+
+	e.listLock.Lock()
+	defer e.listLock.Unlock()
+
+	for _, peer := range e.peers {
+		if peer.Websocket != nil {
+			peer.Websocket.Close()
+		}
+		if err := peer.PeerConnection.Close(); err != nil {
+			e.logger.Warnf("Error closing peer connection: %v", err)
+		}
+		e.roomManager.RemovePeer(peer.RoomKey(), peer)
+	}
+
+	for _, sub := range e.subscribers {
+		sub.close()
+	}
+
+	e.peers = []*types.PeerConnectionState{}
+	e.subscribers = make(map[*types.PeerConnectionState]*subscriber)
+	e.publishers = make(map[*types.PeerConnectionState]*publisher)
 }
 
 // DispatchKeyFrame sends a keyframe to all PeerConnections, used everytime a new user joins the call.
-func DispatchKeyFrame() {
-	if sfuCtx == nil {
+func (e *Engine) DispatchKeyFrame() {
+	e.listLock.Lock()
+	defer e.listLock.Unlock()
+
+	for _, peer := range e.peers {
+		for _, receiver := range peer.PeerConnection.GetReceivers() {
+			// Simulcast receivers have one track per layer
+			for _, track := range receiver.Tracks() {
+				_ = peer.PeerConnection.WriteRTCP([]rtcp.Packet{
+					&rtcp.PictureLossIndication{
+						MediaSSRC: uint32(track.SSRC()),
+					},
+				})
+			}
+		}
+	}
+}
+
+// Publish registers a track published by owner and fires renegotiation for all PeerConnections.
+// The track is only forwarded to other peers in the owner's room. Further simulcast
+// layers of an already published track are added to it without renegotiation.
+func (e *Engine) Publish(owner *types.PeerConnectionState, t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) *PublishedTrack { // nolint
+	if owner == nil {
+		return nil
+	}
+
+	if !owner.Permissions.Publish {
+		e.logger.Warnf("Ignoring track %s of peer %s, which may not publish", t.ID(), owner.Username)
+		return nil
+	}
+
+	e.listLock.Lock()
+
+	if published := e.tracks.Get(owner.RoomKey(), t.ID()); published != nil {
+		// Another peer's track must not be taken over by publishing one with its ID
+		if !isSamePeer(published.Owner, owner) {
+			e.listLock.Unlock()
+			e.logger.Warnf("Ignoring track %s of peer %s, another peer in room %s publishes a track with its ID", t.ID(), owner.Username, owner.RoomID)
+			return nil
+		}
+
+		published.addLayer(t)
+		e.listLock.Unlock()
+
+		e.logger.Infof("Peer %s added layer %q to track %s in room %s", owner.Username, t.RID(), t.ID(), owner.RoomID)
+		e.reallocate(published)
+
+		return published
+	}
+
+	declaration, ok := trackDeclaration{}, false
+	if pub, found := e.publishers[owner]; found {
+		declaration, ok = pub.declared[t.ID()]
+	}
+	if !ok || declaration.source.Kind() != t.Kind() {
+		declaration = trackDeclaration{source: defaultSource(t.Kind())}
+	}
+
+	published := newPublishedTrack(owner, t, receiver, declaration)
+	published.addLayer(t)
+	e.tracks.Add(published)
+	e.listLock.Unlock()
+
+	e.meter.Publish(published, owner.CompanyID, owner.RoomID, published.takeForwarded)
+	e.webhooks.Notify(owner.CompanyID, webhook.EventTrackPublished, trackData(published))
+
+	e.logger.Infof("Peer %s published %s track %s (layer %q) in room %s", owner.Username, published.Source, published.ID, t.RID(), owner.RoomID)
+	e.broadcastTrackEvent(owner, signaling.TypeTrackAvailable, published.Info())
+	e.broadcastParticipantUpdated(owner)
+	e.SignalPeerConnections()
+
+	return published
+}
+
+// Unpublish removes a layer published by owner. Once a track has no layers left it
+// is removed and all PeerConnections are renegotiated.
+func (e *Engine) Unpublish(owner *types.PeerConnectionState, t *webrtc.TrackRemote) {
+	if owner == nil || t == nil {
 		return
 	}
 
-	sfuCtx.ListLock.Lock()
-	defer sfuCtx.ListLock.Unlock()
+	e.listLock.Lock()
 
-	// Safety check: return if no peers
-	if len(*sfuCtx.PeerConnections) == 0 {
+	// Only the publisher may unregister its own track
+	published := e.tracks.Get(owner.RoomKey(), t.ID())
+	if published == nil || !isSamePeer(published.Owner, owner) {
+		e.listLock.Unlock()
 		return
 	}
 
-	for i := range *sfuCtx.PeerConnections {
-		for _, receiver := range (*sfuCtx.PeerConnections)[i].PeerConnection.GetReceivers() {
-			if receiver.Track() == nil {
-				continue
-			}
+	if published.removeLayer(t.RID()) > 0 {
+		e.listLock.Unlock()
+		e.reallocate(published)
+		return
+	}
 
-			_ = (*sfuCtx.PeerConnections)[i].PeerConnection.WriteRTCP([]rtcp.Packet{
-				&rtcp.PictureLossIndication{
-					MediaSSRC: uint32(receiver.Track().SSRC()),
-				},
-			})
-		}
+	e.tracks.Remove(owner.RoomKey(), published.ID)
+	if pub, ok := e.publishers[owner]; ok {
+		delete(pub.declared, published.ID)
 	}
+	e.listLock.Unlock()
+
+	e.meter.Unpublish(published)
+	e.webhooks.Notify(owner.CompanyID, webhook.EventTrackUnpublished, trackData(published))
+
+	e.broadcastTrackEvent(owner, signaling.TypeTrackUnavailable, signaling.TrackInfo{TrackID: published.ID, Participant: owner.Username})
+	e.broadcastParticipantUpdated(owner)
+
+	e.SignalPeerConnections()
 }
 
-// AddTrack adds a track to the list and fires renegotiation for all PeerConnections.
-func AddTrack(t *webrtc.TrackRemote) *webrtc.TrackLocalStaticRTP { // nolint
-	if sfuCtx == nil {
-		return nil
+// DeclareTrack records the source and name of a track a peer is about to publish.
+// A receiving transceiver is added to the peer's PeerConnection when it has none left
+// for the track, then the peer is renegotiated.
+func (e *Engine) DeclareTrack(peer *types.PeerConnectionState, trackID string, source TrackSource, name string) error {
+	kind := source.Kind()
+	if kind == 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidTrackSource, source)
+	}
+	if trackID == "" {
+		return errors.New("track ID is required")
+	}
+	if !peer.Permissions.Publish {
+		return ErrNotAllowed
 	}
 
-	sfuCtx.ListLock.Lock()
-	defer func() {
-		sfuCtx.ListLock.Unlock()
-		SignalPeerConnections()
-	}()
+	e.listLock.Lock()
+	pub, ok := e.publishers[peer]
+	if !ok {
+		e.listLock.Unlock()
+		return errors.New("peer has not joined")
+	}
 
-	// Create a new TrackLocal with the same codec as our incoming
-	trackLocal, err := webrtc.NewTrackLocalStaticRTP(t.Codec().RTPCodecCapability, t.ID(), t.StreamID())
-	if err != nil {
-		sfuCtx.Logger.Errorf("Failed to create TrackLocal: %v", err)
-		return nil // Return nil instead of panicking
+	if _, redeclared := pub.declared[trackID]; !redeclared {
+		pub.claimed[kind]++
+	}
+	pub.declared[trackID] = trackDeclaration{source: source, name: name}
+	needed := pub.claimed[kind]
+	e.listLock.Unlock()
+
+	receiving := 0
+	for _, transceiver := range peer.PeerConnection.GetTransceivers() {
+		direction := transceiver.Direction()
+		if transceiver.Kind() == kind && (direction == webrtc.RTPTransceiverDirectionRecvonly || direction == webrtc.RTPTransceiverDirectionSendrecv) {
+			receiving++
+		}
 	}
 
-	(*sfuCtx.TrackLocals)[t.ID()] = trackLocal
+	if receiving < needed {
+		if _, err := peer.PeerConnection.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		}); err != nil {
+			return fmt.Errorf("failed to add transceiver: %w", err)
+		}
+	}
+
+	e.logger.Infof("Peer %s declared %s track %s in room %s", peer.Username, source, trackID, peer.RoomID)
+	e.RequestNegotiation(peer)
 
-	return trackLocal
+	return nil
 }
 
-// RemoveTrack removes a track from the list and fires renegotiation for all PeerConnections.
-func RemoveTrack(t *webrtc.TrackLocalStaticRTP) {
-	if sfuCtx == nil {
+// Subscribe starts forwarding tracks to a peer and renegotiates it. Tracks not
+// published yet are forwarded once they are.
+func (e *Engine) Subscribe(peer *types.PeerConnectionState, trackIDs []string) {
+	e.setSubscriptions(peer, trackIDs, true)
+}
+
+// Unsubscribe stops forwarding tracks to a peer and renegotiates it
+func (e *Engine) Unsubscribe(peer *types.PeerConnectionState, trackIDs []string) {
+	e.setSubscriptions(peer, trackIDs, false)
+}
+
+// setSubscriptions records a peer's explicit choice for tracks
+func (e *Engine) setSubscriptions(peer *types.PeerConnectionState, trackIDs []string, subscribed bool) {
+	e.listLock.RLock()
+	sub, ok := e.subscribers[peer]
+	e.listLock.RUnlock()
+
+	if !ok || len(trackIDs) == 0 {
 		return
 	}
 
-	sfuCtx.ListLock.Lock()
-	defer func() {
-		sfuCtx.ListLock.Unlock()
-		SignalPeerConnections()
-	}()
+	sub.choose(trackIDs, subscribed)
+	e.SignalPeerConnections()
+}
+
+// RequestNegotiation renegotiates a peer even if no forwarded track changed, e.g.
+// after its transceivers changed or its offer was rolled back
+func (e *Engine) RequestNegotiation(peer *types.PeerConnectionState) {
+	e.listLock.RLock()
+	sub, ok := e.subscribers[peer]
+	e.listLock.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	sub.setNegotiationNeeded(true)
+	e.SignalPeerConnections()
+}
+
+// RestartICE renegotiates a peer with new ICE credentials, e.g. after its network
+// changed. The restart waits for any offer/answer exchange in progress.
+func (e *Engine) RestartICE(peer *types.PeerConnectionState) {
+	e.listLock.RLock()
+	sub, ok := e.subscribers[peer]
+	e.listLock.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	e.logger.Infof("Restarting ICE for peer %s in room %s", peer.Username, peer.RoomID)
+	sub.requestICERestart()
+	e.SignalPeerConnections()
+}
 
-	delete(*sfuCtx.TrackLocals, t.ID())
+// Resume brings a peer whose signaling channel was replaced up to date: it gets a
+// fresh room snapshot, and an offer restarting ICE replaces any offer it missed
+func (e *Engine) Resume(peer *types.PeerConnectionState) {
+	e.listLock.Lock()
+	if peer.PeerConnection.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		if err := peer.PeerConnection.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err != nil {
+			e.logger.Errorf("Failed to roll back offer of resumed peer %s: %v", peer.Username, err)
+		}
+	}
+	if peer.Websocket != nil {
+		e.sendEvent(peer, signaling.TypeRoomSnapshot, e.roomSnapshot(peer))
+	}
+	e.listLock.Unlock()
+
+	e.RestartICE(peer)
 }
 
-// SignalPeerConnections updates each PeerConnection so that it is getting all the expected media tracks.
-func SignalPeerConnections() { // nolint
-	if sfuCtx == nil {
+// ResumeNegotiation renegotiates a peer whose changes were held back while an
+// offer/answer exchange was in progress
+func (e *Engine) ResumeNegotiation(peer *types.PeerConnectionState) {
+	e.listLock.RLock()
+	sub, ok := e.subscribers[peer]
+	e.listLock.RUnlock()
+
+	if ok && sub.isNegotiationNeeded() {
+		e.SignalPeerConnections()
+	}
+}
+
+// SetVideoConstraints records the dimensions a peer renders a track at, so the
+// smallest sufficient simulcast layer is forwarded to it
+func (e *Engine) SetVideoConstraints(peer *types.PeerConnectionState, trackID string, width, height int) {
+	e.listLock.RLock()
+	sub, ok := e.subscribers[peer]
+	e.listLock.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	downTrack := sub.downTrack(trackID)
+	if downTrack == nil {
 		return
 	}
 
-	sfuCtx.ListLock.Lock()
+	downTrack.setVideoConstraints(width, height)
+	sub.allocate()
+}
+
+// reallocate reselects layers for every subscriber of a track whose layers changed
+func (e *Engine) reallocate(published *PublishedTrack) {
+	published.mu.RLock()
+	subscribers := make([]*subscriber, 0, len(published.downTracks))
+	for downTrack := range published.downTracks {
+		subscribers = append(subscribers, downTrack.subscriber)
+	}
+	published.mu.RUnlock()
+
+	for _, sub := range subscribers {
+		sub.allocate()
+	}
+}
+
+// SignalPeerConnections subscribes each PeerConnection to the tracks of its room
+// and renegotiates so that it is getting all the expected media tracks.
+func (e *Engine) SignalPeerConnections() { // nolint
+	e.listLock.Lock()
 	defer func() {
-		sfuCtx.ListLock.Unlock()
-		DispatchKeyFrame()
+		e.listLock.Unlock()
+		e.DispatchKeyFrame()
 	}()
 
 	attemptSync := func() (tryAgain bool) {
 		// Use index-based loop with bounds checking to safely remove elements
-		for i := 0; i < len(*sfuCtx.PeerConnections); {
-			currentPeer := (*sfuCtx.PeerConnections)[i]
-			sfuCtx.Logger.Infof("[SignalPeerConnections] Processing peer %d/%d: %s in room %s", i+1, len(*sfuCtx.PeerConnections), currentPeer.Username, currentPeer.RoomID)
+		for i := 0; i < len(e.peers); {
+			currentPeer := e.peers[i]
+			e.logger.Infof("[SignalPeerConnections] Processing peer %d/%d: %s in room %s", i+1, len(e.peers), currentPeer.Username, currentPeer.RoomID)
 
 			if currentPeer.PeerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
 				// Remove closed connection and restart from beginning
-				*sfuCtx.PeerConnections = append((*sfuCtx.PeerConnections)[:i], (*sfuCtx.PeerConnections)[i+1:]...)
+				e.peers = append(e.peers[:i], e.peers[i+1:]...)
 				return true // We modified the slice, start from the beginning
 			}
 
+			// Peers without a signaling channel can't be renegotiated
+			if currentPeer.Websocket == nil {
+				i++
+				continue
+			}
+
+			sub, ok := e.subscribers[currentPeer]
+			if !ok {
+				i++
+				continue
+			}
+
+			// Tracks this peer should receive: same room only, never its own, and only
+			// those it subscribed to if it may subscribe at all
+			wantedTracks := map[string]*PublishedTrack{}
+			if currentPeer.Permissions.Subscribe {
+				wantedTracks = e.tracks.TracksForSubscriber(currentPeer)
+			}
+			for trackID := range wantedTracks {
+				if !sub.wants(trackID) {
+					delete(wantedTracks, trackID)
+				}
+			}
+
 			// map of sender we already are sending, so we don't double send
 			existingSenders := map[string]bool{}
 
@@ -141,100 +640,82 @@ func SignalPeerConnections() { // nolint
 					continue
 				}
 
-				existingSenders[sender.Track().ID()] = true
+				trackID := sender.Track().ID()
+				existingSenders[trackID] = true
 
-				// If we have a RTPSender that doesn't map to an existing track, remove it
-				if _, ok := (*sfuCtx.TrackLocals)[sender.Track().ID()]; !ok {
+				// If we have a RTPSender that doesn't map to a track in this peer's room, remove it.
+				// A track republished under the same ID gets a fresh sender.
+				downTrack, isDownTrack := sender.Track().(*DownTrack)
+				if published, ok := wantedTracks[trackID]; !ok || !isDownTrack || downTrack.published != published {
 					if err := currentPeer.PeerConnection.RemoveTrack(sender); err != nil {
-						sfuCtx.Logger.Errorf("Failed to remove track: %v", err)
+						e.logger.Errorf("Failed to remove track: %v", err)
 						return true
 					}
+					sub.removeDownTrack(trackID)
+					sub.setNegotiationNeeded(true)
+					delete(existingSenders, trackID)
+
+					e.sendEvent(currentPeer, signaling.TypeTrackUnpublished, signaling.TrackInfo{TrackID: trackID})
 				}
 			}
 
-			// Don't receive videos we are sending, make sure we don't have loopback
-			for _, receiver := range currentPeer.PeerConnection.GetReceivers() {
-				if receiver.Track() == nil {
+			// Add tracks published by other peers in the SAME ROOM
+			for trackID, published := range wantedTracks {
+				if _, ok := existingSenders[trackID]; ok {
 					continue
 				}
 
-				existingSenders[receiver.Track().ID()] = true
-			}
+				downTrack := newDownTrack(published, sub)
+				sender, err := currentPeer.PeerConnection.AddTrack(downTrack)
+				if err != nil {
+					e.logger.Debugf("Failed to add track: %v", err)
+					return true
+				}
+				sub.addDownTrack(downTrack)
+				sub.setNegotiationNeeded(true)
+				go downTrack.readRTCP(sender)
 
-			// Add all tracks from peers in the SAME ROOM
-			// Check if there are other peers in the same room by looking at the global peer list
-			var hasRoomPeers bool
+				// Tell the subscriber what the track is before it shows up in the offer
+				e.sendEvent(currentPeer, signaling.TypeTrackPublished, published.Info())
 
-			for j := range *sfuCtx.PeerConnections {
-				otherPeer := (*sfuCtx.PeerConnections)[j]
-				// Check if this is a different peer in the same room
-				if otherPeer.Websocket != currentPeer.Websocket && otherPeer.RoomID == currentPeer.RoomID {
-					hasRoomPeers = true
-					sfuCtx.Logger.Debugf("Found peer %s in room %s for peer %s", otherPeer.Username, currentPeer.RoomID, currentPeer.Username)
-				}
+				existingSenders[trackID] = true
+				e.logger.Debugf("Forwarding track %s from %s to %s in room %s", trackID, published.Owner.Username, currentPeer.Username, currentPeer.RoomID)
 			}
 
-			// Add tracks only from peers in the SAME ROOM
-			// We add ALL tracks from TrackLocals that are in the same room
-			if hasRoomPeers {
-				// Create a list of room peer websocket pointers for quick lookup
-				roomPeerWebsockets := make(map[*types.ThreadSafeWriter]bool)
-				for j := range *sfuCtx.PeerConnections {
-					otherPeer := (*sfuCtx.PeerConnections)[j]
-					if otherPeer.Websocket != currentPeer.Websocket && otherPeer.RoomID == currentPeer.RoomID {
-						roomPeerWebsockets[otherPeer.Websocket] = true
-					}
-				}
-
-				// Add all tracks from these room peers
-				// Since we don't track ownership, we need to add all tracks and let WebRTC handle duplicates
-				for trackID, track := range *sfuCtx.TrackLocals {
-					if _, ok := existingSenders[trackID]; !ok {
-						// Add track
-						if _, err := currentPeer.PeerConnection.AddTrack(track); err != nil {
-							sfuCtx.Logger.Debugf("Failed to add track: %v", err)
-							return true
-						}
-						existingSenders[trackID] = true
-					}
-				}
+			// Only renegotiate peers whose tracks or transceivers changed
+			if !sub.isNegotiationNeeded() {
+				i++
+				continue
 			}
 
 			// Only create offer if signaling state is stable
-			// (can't create offer if we're waiting for answer to previous offer)
+			// (can't create offer if we're waiting for answer to previous offer).
+			// The peer stays flagged and is renegotiated once the exchange completes.
 			if currentPeer.PeerConnection.SignalingState() != webrtc.SignalingStateStable {
 				// Skip this peer, it's in the middle of an offer/answer exchange
-				sfuCtx.Logger.Infof("[SignalPeerConnections] Skipping peer %s - signalingState=%v (not stable)", currentPeer.Username, currentPeer.PeerConnection.SignalingState())
+				e.logger.Infof("[SignalPeerConnections] Skipping peer %s - signalingState=%v (not stable)", currentPeer.Username, currentPeer.PeerConnection.SignalingState())
 				i++
 				continue
 			}
 
 			// Create and send offer
-			sfuCtx.Logger.Infof("[SignalPeerConnections] Creating offer for peer %s (senders=%d)", currentPeer.Username, len(existingSenders))
-			offer, err := currentPeer.PeerConnection.CreateOffer(nil)
+			e.logger.Infof("[SignalPeerConnections] Creating offer for peer %s (senders=%d)", currentPeer.Username, len(existingSenders))
+			offer, err := currentPeer.PeerConnection.CreateOffer(sub.offerOptions())
 			if err != nil {
-				sfuCtx.Logger.Errorf("Failed to create offer: %v", err)
+				e.logger.Errorf("Failed to create offer: %v", err)
 				return true
 			}
 
 			if err = currentPeer.PeerConnection.SetLocalDescription(offer); err != nil {
-				sfuCtx.Logger.Errorf("Failed to set local description: %v", err)
-				return true
-			}
-
-			offerString, err := json.Marshal(offer)
-			if err != nil {
-				sfuCtx.Logger.Errorf("Failed to marshal offer to json: %v", err)
+				e.logger.Errorf("Failed to set local description: %v", err)
 				return true
 			}
 
-			if err = currentPeer.Websocket.WriteJSON(&types.WebsocketMessage{
-				Event: "offer",
-				Data:  string(offerString),
-			}); err != nil {
-				sfuCtx.Logger.Errorf("Failed to write offer: %v", err)
+			if err = currentPeer.Websocket.WriteEvent(signaling.TypeOffer, offer); err != nil {
+				e.logger.Errorf("Failed to write offer: %v", err)
 				return true
 			}
+			sub.offerSent()
 
 			i++ // Only increment if we didn't remove the element
 		}
@@ -244,10 +725,10 @@ func SignalPeerConnections() { // nolint
 
 	for syncAttempt := 0; ; syncAttempt++ {
 		if syncAttempt == 25 {
-			// Release the lock and attempt a sync in 3 seconds. We might be blocking a RemoveTrack or AddTrack
+			// Release the lock and attempt a sync in 3 seconds. We might be blocking a Unpublish or Publish
 			go func() {
 				time.Sleep(time.Second * 3)
-				SignalPeerConnections()
+				e.SignalPeerConnections()
 			}()
 
 			return
@@ -259,40 +740,82 @@ func SignalPeerConnections() { // nolint
 	}
 }
 
-// BroadcastChat sends a chat message to all connected peers in the same room.
-func BroadcastChat(msg types.ChatMessage, sender *types.ThreadSafeWriter) {
-	if sfuCtx == nil {
-		return
+// sendEvent writes an event with a JSON payload to a peer
+func (e *Engine) sendEvent(peer *types.PeerConnectionState, event string, payload any) {
+	if err := peer.Websocket.WriteEvent(event, payload); err != nil {
+		e.logger.Errorf("Failed to write %s: %v", event, err)
+	}
+}
+
+// broadcastEvent writes an event to every peer in a room but except, which may be
+// nil. Callers must hold the list lock.
+func (e *Engine) broadcastEvent(roomID string, except *types.PeerConnectionState, event string, payload any) {
+	for _, peer := range e.peers {
+		if peer.RoomKey() != roomID || peer.Websocket == nil || (except != nil && isSamePeer(peer, except)) {
+			continue
+		}
+
+		e.sendEvent(peer, event, payload)
 	}
+}
 
-	sfuCtx.ListLock.RLock()
-	defer sfuCtx.ListLock.RUnlock()
+// broadcastTrackEvent tells the other peers in the owner's room about a track
+func (e *Engine) broadcastTrackEvent(owner *types.PeerConnectionState, event string, info signaling.TrackInfo) {
+	e.listLock.RLock()
+	defer e.listLock.RUnlock()
+
+	e.broadcastEvent(owner.RoomKey(), owner, event, info)
+}
+
+// BroadcastChat sends a chat message to all connected peers in the same room,
+// stamped with the time it's sent unless it has one. It returns ErrNotAllowed if the
+// sender may not chat.
+func (e *Engine) BroadcastChat(msg types.ChatMessage, sender *types.ThreadSafeWriter) error {
+	e.listLock.RLock()
+	defer e.listLock.RUnlock()
+
+	// Version 1 clients show the time of day, version 2 clients get a timestamp
+	sent := time.Now()
+	if msg.Time == "" {
+		msg.Time = sent.Format("15:04:05")
+	}
 
 	// Find the sender's room
-	var senderRoom string
-	for i := range *sfuCtx.PeerConnections {
-		if (*sfuCtx.PeerConnections)[i].Websocket == sender {
-			senderRoom = (*sfuCtx.PeerConnections)[i].RoomID
+	var senderRoom, senderName string
+	for _, peer := range e.peers {
+		if peer.Websocket == sender {
+			if !peer.Permissions.Chat {
+				return ErrNotAllowed
+			}
+
+			senderRoom, senderName = peer.RoomKey(), peer.Username
 			break
 		}
 	}
 
 	// Broadcast only to peers in the same room
-	for i := range *sfuCtx.PeerConnections {
-		peer := (*sfuCtx.PeerConnections)[i]
-
+	for _, peer := range e.peers {
 		// Don't send the message back to the sender
-		if peer.Websocket == sender {
+		if peer.Websocket == sender || peer.Websocket == nil {
 			continue
 		}
 
 		// Only send to peers in the same room
-		if peer.RoomID != senderRoom {
+		if peer.RoomKey() != senderRoom {
 			continue
 		}
 
-		if err := peer.Websocket.WriteJSON(msg); err != nil {
-			sfuCtx.Logger.Errorf("Failed to send chat message: %v", err)
+		// Version 1 clients get the original chat message
+		var err error
+		if peer.Websocket.Version() < signaling.Version2 {
+			err = peer.Websocket.WriteJSON(msg)
+		} else {
+			err = peer.Websocket.WriteEvent(signaling.TypeChat, signaling.NewChat(senderName, msg.Message, sent))
+		}
+		if err != nil {
+			e.logger.Errorf("Failed to send chat message: %v", err)
 		}
 	}
+
+	return nil
 }
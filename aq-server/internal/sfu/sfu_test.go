@@ -0,0 +1,200 @@
+package sfu
+
+import (
+	"errors"
+	"testing"
+
+	"aq-server/internal/types"
+
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+)
+
+func newTestEngine(t *testing.T, logger logging.LeveledLogger) *Engine {
+	t.Helper()
+
+	engine, err := NewEngine(logger, nil)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	return engine
+}
+
+func newTestPeer(t *testing.T, username, roomID string) *types.PeerConnectionState {
+	t.Helper()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create PeerConnection: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+
+	return &types.PeerConnectionState{
+		PeerConnection: pc,
+		Username:       username,
+		RoomID:         roomID,
+		Permissions:    types.DefaultPermissions(),
+	}
+}
+
+func TestEnginesAreIndependent(t *testing.T) {
+	logger := logging.NewDefaultLoggerFactory().NewLogger("sfu-test")
+
+	first := newTestEngine(t, logger)
+	second := newTestEngine(t, logger)
+
+	first.Join(newTestPeer(t, "alice", "room-a"))
+	first.Join(newTestPeer(t, "bob", "room-a"))
+	second.Join(newTestPeer(t, "carol", "room-a"))
+
+	if first.PeerCount() != 2 {
+		t.Errorf("Expected first engine to have 2 peers, got %d", first.PeerCount())
+	}
+
+	if second.PeerCount() != 1 {
+		t.Errorf("Expected second engine to have 1 peer, got %d", second.PeerCount())
+	}
+
+	if count := second.RoomManager().GetRoomPeerCount("room-a"); count != 1 {
+		t.Errorf("Expected second engine room to have 1 peer, got %d", count)
+	}
+}
+
+func TestEngineLeave(t *testing.T) {
+	engine := newTestEngine(t, logging.NewDefaultLoggerFactory().NewLogger("sfu-test"))
+
+	peer := newTestPeer(t, "alice", "room-a")
+	engine.Join(peer)
+	engine.Leave(peer)
+
+	if engine.PeerCount() != 0 {
+		t.Errorf("Expected 0 peers after leave, got %d", engine.PeerCount())
+	}
+
+	if count := engine.RoomManager().GetRoomPeerCount("room-a"); count != 0 {
+		t.Errorf("Expected empty room after leave, got %d peers", count)
+	}
+}
+
+func TestDeclareTrackAddsTransceivers(t *testing.T) {
+	engine := newTestEngine(t, logging.NewDefaultLoggerFactory().NewLogger("sfu-test"))
+
+	peer := newTestPeer(t, "alice", "room-a")
+	engine.Join(peer)
+
+	countVideo := func() int {
+		count := 0
+		for _, transceiver := range peer.PeerConnection.GetTransceivers() {
+			if transceiver.Kind() == webrtc.RTPCodecTypeVideo {
+				count++
+			}
+		}
+		return count
+	}
+
+	if err := engine.DeclareTrack(peer, "camera", SourceCamera, "Camera"); err != nil {
+		t.Fatalf("Failed to declare camera: %v", err)
+	}
+	if err := engine.DeclareTrack(peer, "screen", SourceScreen, "Slides"); err != nil {
+		t.Fatalf("Failed to declare screen: %v", err)
+	}
+	if count := countVideo(); count != 2 {
+		t.Errorf("Expected 2 video transceivers, got %d", count)
+	}
+
+	// Declaring the same track again doesn't need another transceiver
+	if err := engine.DeclareTrack(peer, "screen", SourceScreen, "Slides v2"); err != nil {
+		t.Fatalf("Failed to redeclare screen: %v", err)
+	}
+	if count := countVideo(); count != 2 {
+		t.Errorf("Expected 2 video transceivers after redeclaring, got %d", count)
+	}
+
+	if err := engine.DeclareTrack(peer, "other", TrackSource("projector"), ""); !errors.Is(err, ErrInvalidTrackSource) {
+		t.Errorf("Expected ErrInvalidTrackSource, got %v", err)
+	}
+}
+
+func TestDeclareTrackWithoutPublish(t *testing.T) {
+	engine := newTestEngine(t, logging.NewDefaultLoggerFactory().NewLogger("sfu-test"))
+
+	viewer := newTestPeer(t, "viewer", "room-a")
+	viewer.Permissions.Publish = false
+	engine.Join(viewer)
+
+	if err := engine.DeclareTrack(viewer, "camera", SourceCamera, ""); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Expected ErrNotAllowed, got %v", err)
+	}
+}
+
+func TestBroadcastChatWithoutChat(t *testing.T) {
+	engine := newTestEngine(t, logging.NewDefaultLoggerFactory().NewLogger("sfu-test"))
+
+	silenced := &types.PeerConnectionState{Websocket: &types.ThreadSafeWriter{}, Username: "silenced", RoomID: "room-a"}
+	engine.peers = append(engine.peers, silenced)
+
+	err := engine.BroadcastChat(types.ChatMessage{Event: "chat", Message: "hello"}, silenced.Websocket)
+	if !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Expected ErrNotAllowed, got %v", err)
+	}
+}
+
+func TestAttachFillsOfferedTransceivers(t *testing.T) {
+	engine := newTestEngine(t, logging.NewDefaultLoggerFactory().NewLogger("sfu-test"))
+
+	alice := newTestPeer(t, "alice", "room-a")
+	bob := newTestPeer(t, "bob", "room-a")
+	engine.Join(alice)
+	engine.Join(bob)
+
+	engine.tracks.Add(newTestTrack(alice, "alice-camera"))
+	engine.tracks.Add(newTestTrack(alice, "alice-screen"))
+	engine.tracks.Add(newTestTrack(bob, "bob-camera"))
+
+	// A viewer offering two video transceivers
+	newViewer := func(username string) *types.PeerConnectionState {
+		offerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatalf("Failed to create PeerConnection: %v", err)
+		}
+		t.Cleanup(func() { _ = offerer.Close() })
+
+		for i := 0; i < 2; i++ {
+			if _, err := offerer.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionRecvonly,
+			}); err != nil {
+				t.Fatalf("Failed to add transceiver: %v", err)
+			}
+		}
+
+		offer, err := offerer.CreateOffer(nil)
+		if err != nil {
+			t.Fatalf("Failed to create offer: %v", err)
+		}
+
+		viewer := newTestPeer(t, username, "room-a")
+		engine.Join(viewer)
+		if err := viewer.PeerConnection.SetRemoteDescription(offer); err != nil {
+			t.Fatalf("Failed to set remote description: %v", err)
+		}
+
+		return viewer
+	}
+
+	attached := engine.Attach(newViewer("room-viewer"), "")
+	if len(attached) != 2 || attached[0].TrackID != "alice-camera" || attached[1].TrackID != "alice-screen" {
+		t.Errorf("Expected the first two tracks on two transceivers, got %+v", attached)
+	}
+
+	attached = engine.Attach(newViewer("bob-viewer"), "bob")
+	if len(attached) != 1 || attached[0].TrackID != "bob-camera" {
+		t.Errorf("Expected only bob's track, got %+v", attached)
+	}
+
+	blind := newViewer("blind-viewer")
+	blind.Permissions.Subscribe = false
+	if attached = engine.Attach(blind, ""); len(attached) != 0 {
+		t.Errorf("Expected no tracks for a viewer that may not subscribe, got %+v", attached)
+	}
+}
@@ -0,0 +1,253 @@
+package sfu
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// layerRanks orders the RIDs commonly used by browsers and SDKs for simulcast
+var layerRanks = map[string]int{
+	"q": 0, "l": 0, "low": 0, "0": 0,
+	"h": 1, "m": 1, "mid": 1, "1": 1,
+	"f": 2, "high": 2, "2": 2,
+}
+
+// layerLess orders layers from lowest to highest quality. Well-known RIDs are
+// ranked by name, anything else by measured bitrate.
+func layerLess(a, b LayerInfo) bool {
+	rankA, knownA := layerRanks[strings.ToLower(a.RID)]
+	rankB, knownB := layerRanks[strings.ToLower(b.RID)]
+	if knownA && knownB && rankA != rankB {
+		return rankA < rankB
+	}
+
+	if a.Bitrate != b.Bitrate {
+		return a.Bitrate < b.Bitrate
+	}
+
+	return a.RID < b.RID
+}
+
+// selectLayer picks the layer a subscriber should receive. layers must be ordered
+// from lowest to highest quality. The smallest layer that covers the requested
+// dimensions is preferred, then lowered until it fits within the bitrate budget.
+// A zero budget or zero dimensions mean unconstrained.
+func selectLayer(layers []LayerInfo, budget uint64, maxWidth, maxHeight int) string {
+	if len(layers) == 0 {
+		return ""
+	}
+
+	selected := layerCap(layers, maxWidth, maxHeight)
+
+	if budget > 0 {
+		for selected > 0 && layers[selected].Bitrate > budget {
+			selected--
+		}
+	}
+
+	return layers[selected].RID
+}
+
+// layerCap returns the index of the smallest layer covering the requested
+// dimensions, or the highest layer if none does or the dimensions are zero
+func layerCap(layers []LayerInfo, maxWidth, maxHeight int) int {
+	if maxWidth > 0 || maxHeight > 0 {
+		for i, l := range layers {
+			if l.Width == 0 && l.Height == 0 {
+				continue // Dimensions not known yet
+			}
+			if l.Width >= maxWidth && l.Height >= maxHeight {
+				return i
+			}
+		}
+	}
+
+	return len(layers) - 1
+}
+
+// detectsKeyframes reports whether isKeyframe understands the given codec
+func detectsKeyframes(mimeType string) bool {
+	for _, supported := range []string{webrtc.MimeTypeVP8, webrtc.MimeTypeVP9, webrtc.MimeTypeH264} {
+		if strings.EqualFold(mimeType, supported) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isKeyframe reports whether an RTP payload starts a keyframe for the given codec
+func isKeyframe(mimeType string, payload []byte) bool {
+	switch {
+	case strings.EqualFold(mimeType, webrtc.MimeTypeVP8):
+		offset, ok := vp8PayloadOffset(payload)
+		return ok && payload[offset]&0x01 == 0
+	case strings.EqualFold(mimeType, webrtc.MimeTypeVP9):
+		// Not inter-predicted (P=0) and beginning of a frame (B=1)
+		return len(payload) > 0 && payload[0]&0x40 == 0 && payload[0]&0x08 != 0
+	case strings.EqualFold(mimeType, webrtc.MimeTypeH264):
+		return h264IsKeyframe(payload)
+	default:
+		return false
+	}
+}
+
+// vp8PayloadOffset returns the offset of the VP8 frame after the payload descriptor,
+// or false if the packet does not start the first partition of a frame
+func vp8PayloadOffset(payload []byte) (int, bool) {
+	if len(payload) < 1 {
+		return 0, false
+	}
+
+	// Start of partition with partition index 0
+	if payload[0]&0x10 == 0 || payload[0]&0x07 != 0 {
+		return 0, false
+	}
+
+	offset := 1
+	if payload[0]&0x80 != 0 {
+		if len(payload) < 2 {
+			return 0, false
+		}
+		extension := payload[1]
+		offset++
+
+		if extension&0x80 != 0 { // PictureID present
+			if len(payload) <= offset {
+				return 0, false
+			}
+			if payload[offset]&0x80 != 0 {
+				offset += 2
+			} else {
+				offset++
+			}
+		}
+		if extension&0x40 != 0 { // TL0PICIDX present
+			offset++
+		}
+		if extension&0x30 != 0 { // TID/KEYIDX present
+			offset++
+		}
+	}
+
+	if len(payload) <= offset {
+		return 0, false
+	}
+
+	return offset, true
+}
+
+// vp8KeyframeSize extracts the frame size from a VP8 keyframe
+func vp8KeyframeSize(mimeType string, payload []byte) (int, int, bool) {
+	if !strings.EqualFold(mimeType, webrtc.MimeTypeVP8) {
+		return 0, 0, false
+	}
+
+	offset, ok := vp8PayloadOffset(payload)
+	if !ok || len(payload) < offset+10 {
+		return 0, 0, false
+	}
+
+	frame := payload[offset:]
+	if frame[0]&0x01 != 0 || frame[3] != 0x9d || frame[4] != 0x01 || frame[5] != 0x2a {
+		return 0, 0, false
+	}
+
+	width := int(frame[6]) | int(frame[7])<<8
+	height := int(frame[8]) | int(frame[9])<<8
+
+	return width & 0x3fff, height & 0x3fff, true
+}
+
+// h264IsKeyframe looks for an IDR slice or SPS in single, STAP-A and FU-A packets
+func h264IsKeyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	switch nalType := payload[0] & 0x1f; nalType {
+	case 5, 7:
+		return true
+	case 24: // STAP-A
+		for offset := 1; offset+2 < len(payload); {
+			size := int(payload[offset])<<8 | int(payload[offset+1])
+			offset += 2
+			if offset >= len(payload) {
+				break
+			}
+			if unitType := payload[offset] & 0x1f; unitType == 5 || unitType == 7 {
+				return true
+			}
+			offset += size
+		}
+	case 28: // FU-A
+		if len(payload) < 2 {
+			return false
+		}
+		unitType := payload[1] & 0x1f
+		return payload[1]&0x80 != 0 && (unitType == 5 || unitType == 7)
+	}
+
+	return false
+}
+
+// rtpMunger rewrites sequence numbers and timestamps so a subscriber sees one
+// continuous stream while the SFU switches between source layers
+type rtpMunger struct {
+	clockRate   uint32
+	initialized bool
+	resync      bool
+	snOffset    uint16
+	tsOffset    uint32
+	lastSN      uint16
+	lastTS      uint32
+	lastWrite   time.Time
+}
+
+// switchSource makes the next packet continue the outgoing stream from a new source
+func (m *rtpMunger) switchSource() {
+	m.resync = true
+}
+
+// update returns the outgoing sequence number and timestamp for an incoming packet
+func (m *rtpMunger) update(sn uint16, ts uint32, now time.Time) (uint16, uint32) {
+	if !m.initialized {
+		m.initialized = true
+		m.resync = false
+		m.lastSN = sn - 1
+		m.lastTS = ts
+		m.lastWrite = now
+	}
+
+	if m.resync {
+		m.resync = false
+
+		// Continue right after the last packet sent, advancing the timestamp by the
+		// wall clock time elapsed since then
+		elapsed := uint32(now.Sub(m.lastWrite).Seconds() * float64(m.clockRate))
+		if elapsed == 0 {
+			elapsed = 1
+		}
+
+		m.snOffset = sn - m.lastSN - 1
+		m.tsOffset = ts - (m.lastTS + elapsed)
+	}
+
+	outSN := sn - m.snOffset
+	outTS := ts - m.tsOffset
+
+	if isNewerSequence(outSN, m.lastSN) {
+		m.lastSN = outSN
+		m.lastTS = outTS
+		m.lastWrite = now
+	}
+
+	return outSN, outTS
+}
+
+// isNewerSequence compares RTP sequence numbers accounting for wrap-around
+func isNewerSequence(a, b uint16) bool {
+	return a != b && a-b < 0x8000
+}
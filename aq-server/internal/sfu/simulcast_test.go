@@ -0,0 +1,146 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestLayerOrdering(t *testing.T) {
+	layers := []LayerInfo{
+		{RID: "f", Bitrate: 100},
+		{RID: "q", Bitrate: 900},
+		{RID: "h", Bitrate: 500},
+	}
+
+	if !layerLess(layers[1], layers[2]) || !layerLess(layers[2], layers[0]) {
+		t.Error("Expected well-known RIDs to be ordered q < h < f regardless of bitrate")
+	}
+
+	if !layerLess(LayerInfo{RID: "a", Bitrate: 100}, LayerInfo{RID: "b", Bitrate: 200}) {
+		t.Error("Expected unknown RIDs to be ordered by bitrate")
+	}
+}
+
+func TestSelectLayer(t *testing.T) {
+	layers := []LayerInfo{
+		{RID: "q", Bitrate: 150_000, Width: 320, Height: 180},
+		{RID: "h", Bitrate: 500_000, Width: 640, Height: 360},
+		{RID: "f", Bitrate: 1_500_000, Width: 1280, Height: 720},
+	}
+
+	tests := []struct {
+		name      string
+		budget    uint64
+		maxWidth  int
+		maxHeight int
+		expected  string
+	}{
+		{name: "unconstrained", expected: "f"},
+		{name: "bandwidth limited", budget: 600_000, expected: "h"},
+		{name: "below lowest layer", budget: 50_000, expected: "q"},
+		{name: "small viewport", maxWidth: 320, maxHeight: 180, expected: "q"},
+		{name: "viewport between layers", maxWidth: 400, maxHeight: 225, expected: "h"},
+		{name: "viewport larger than top layer", maxWidth: 1920, maxHeight: 1080, expected: "f"},
+		{name: "viewport and bandwidth", budget: 200_000, maxWidth: 1280, maxHeight: 720, expected: "q"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := selectLayer(layers, tt.budget, tt.maxWidth, tt.maxHeight); result != tt.expected {
+				t.Errorf("Expected layer %s, got %s", tt.expected, result)
+			}
+		})
+	}
+
+	if result := selectLayer(nil, 0, 0, 0); result != "" {
+		t.Errorf("Expected empty layer for no layers, got %s", result)
+	}
+}
+
+func TestIsKeyframe(t *testing.T) {
+	tests := []struct {
+		name     string
+		mimeType string
+		payload  []byte
+		expected bool
+	}{
+		{name: "vp8 keyframe", mimeType: webrtc.MimeTypeVP8, payload: []byte{0x10, 0x00, 0x00, 0x00}, expected: true},
+		{name: "vp8 interframe", mimeType: webrtc.MimeTypeVP8, payload: []byte{0x10, 0x01, 0x00, 0x00}, expected: false},
+		{name: "vp8 continuation", mimeType: webrtc.MimeTypeVP8, payload: []byte{0x00, 0x00}, expected: false},
+		{name: "vp8 extended keyframe", mimeType: webrtc.MimeTypeVP8, payload: []byte{0x90, 0x80, 0x81, 0x02, 0x00}, expected: true},
+		{name: "h264 idr", mimeType: webrtc.MimeTypeH264, payload: []byte{0x65, 0x00}, expected: true},
+		{name: "h264 non-idr", mimeType: webrtc.MimeTypeH264, payload: []byte{0x41, 0x00}, expected: false},
+		{name: "h264 stap-a with sps", mimeType: webrtc.MimeTypeH264, payload: []byte{0x78, 0x00, 0x02, 0x67, 0x42}, expected: true},
+		{name: "h264 fu-a idr start", mimeType: webrtc.MimeTypeH264, payload: []byte{0x7c, 0x85, 0x00}, expected: true},
+		{name: "h264 fu-a idr middle", mimeType: webrtc.MimeTypeH264, payload: []byte{0x7c, 0x05, 0x00}, expected: false},
+		{name: "vp9 keyframe", mimeType: webrtc.MimeTypeVP9, payload: []byte{0x08}, expected: true},
+		{name: "vp9 interframe", mimeType: webrtc.MimeTypeVP9, payload: []byte{0x48}, expected: false},
+		{name: "opus", mimeType: webrtc.MimeTypeOpus, payload: []byte{0x00}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := isKeyframe(tt.mimeType, tt.payload); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestVP8KeyframeSize(t *testing.T) {
+	// Descriptor, frame tag, start code, 640x360
+	payload := []byte{0x10, 0x00, 0x00, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0x68, 0x01}
+
+	width, height, ok := vp8KeyframeSize(webrtc.MimeTypeVP8, payload)
+	if !ok {
+		t.Fatal("Expected frame size to be found")
+	}
+
+	if width != 640 || height != 360 {
+		t.Errorf("Expected 640x360, got %dx%d", width, height)
+	}
+}
+
+func TestRTPMungerLayerSwitch(t *testing.T) {
+	munger := rtpMunger{clockRate: 90000}
+	start := time.Now()
+
+	sn, ts := munger.update(1000, 50000, start)
+	if sn != 1000 || ts != 50000 {
+		t.Errorf("Expected first packet to pass through, got sn=%d ts=%d", sn, ts)
+	}
+
+	sn, _ = munger.update(1001, 53000, start.Add(33*time.Millisecond))
+	if sn != 1001 {
+		t.Errorf("Expected sn 1001, got %d", sn)
+	}
+
+	// Switch to a layer with unrelated sequence numbers and timestamps
+	munger.switchSource()
+	sn, ts = munger.update(40000, 7000000, start.Add(100*time.Millisecond))
+	if sn != 1002 {
+		t.Errorf("Expected sequence to continue at 1002, got %d", sn)
+	}
+	if expected := uint32(53000 + 6030); ts != expected {
+		t.Errorf("Expected timestamp %d, got %d", expected, ts)
+	}
+
+	sn, _ = munger.update(40001, 7003000, start.Add(133*time.Millisecond))
+	if sn != 1003 {
+		t.Errorf("Expected sn 1003 after switch, got %d", sn)
+	}
+}
+
+func TestRTPMungerWrapAround(t *testing.T) {
+	munger := rtpMunger{clockRate: 90000}
+	now := time.Now()
+
+	munger.update(65535, 0, now)
+	munger.switchSource()
+
+	if sn, _ := munger.update(10, 3000, now); sn != 0 {
+		t.Errorf("Expected sequence to wrap to 0, got %d", sn)
+	}
+}
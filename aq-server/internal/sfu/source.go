@@ -0,0 +1,62 @@
+package sfu
+
+import (
+	"errors"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// TrackSource is what a published track captures
+type TrackSource string
+
+// Track sources a publisher can declare
+const (
+	SourceCamera      TrackSource = "camera"
+	SourceMicrophone  TrackSource = "microphone"
+	SourceScreen      TrackSource = "screen"
+	SourceScreenAudio TrackSource = "screen_audio"
+)
+
+// ErrInvalidTrackSource is returned when a publisher declares an unknown source
+var ErrInvalidTrackSource = errors.New("invalid track source")
+
+// Kind returns whether the source produces audio or video, or 0 if it is unknown
+func (s TrackSource) Kind() webrtc.RTPCodecType {
+	switch s {
+	case SourceCamera, SourceScreen:
+		return webrtc.RTPCodecTypeVideo
+	case SourceMicrophone, SourceScreenAudio:
+		return webrtc.RTPCodecTypeAudio
+	default:
+		return 0
+	}
+}
+
+// defaultSource is assumed for tracks published without a declaration
+func defaultSource(kind webrtc.RTPCodecType) TrackSource {
+	if kind == webrtc.RTPCodecTypeAudio {
+		return SourceMicrophone
+	}
+
+	return SourceCamera
+}
+
+// trackDeclaration is what a publisher declared about a track before sending it
+type trackDeclaration struct {
+	source TrackSource
+	name   string
+}
+
+// publisher holds the tracks a peer declared with publish_track
+type publisher struct {
+	declared map[string]trackDeclaration // By track ID
+	claimed  map[webrtc.RTPCodecType]int // Declarations made per kind, each needs a receiving transceiver
+}
+
+// newPublisher creates the declaration state of a peer
+func newPublisher() *publisher {
+	return &publisher{
+		declared: make(map[string]trackDeclaration),
+		claimed:  make(map[webrtc.RTPCodecType]int),
+	}
+}
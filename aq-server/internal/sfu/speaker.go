@@ -0,0 +1,178 @@
+package sfu
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"aq-server/internal/signaling"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// audioLevelWindow is how far back loudness is averaged
+	audioLevelWindow = time.Second
+
+	// speakingThreshold is the loudest average level, in -dBov, still considered silent
+	speakingThreshold = 60
+
+	// minSpeakingSamples is how many packets the window needs to count as speech
+	minSpeakingSamples = 10
+
+	// silentLevel is the level of a packet without the extension, in -dBov
+	silentLevel = 127
+)
+
+// levelSample is one audio level observation
+type levelSample struct {
+	at    time.Time
+	level uint8 // -dBov, 0 is the loudest
+	voice bool
+}
+
+// audioLevelMeter keeps a sliding window of the audio levels a publisher reports
+// in the ssrc-audio-level header extension. Callers serialize access.
+type audioLevelMeter struct {
+	samples []levelSample
+}
+
+// observe records the level of a packet received at now
+func (m *audioLevelMeter) observe(level uint8, voice bool, now time.Time) {
+	m.trim(now)
+	m.samples = append(m.samples, levelSample{at: now, level: level, voice: voice})
+}
+
+// trim drops the samples that left the window
+func (m *audioLevelMeter) trim(now time.Time) {
+	expired := 0
+	for expired < len(m.samples) && now.Sub(m.samples[expired].at) > audioLevelWindow {
+		expired++
+	}
+
+	if expired > 0 {
+		m.samples = append(m.samples[:0], m.samples[expired:]...)
+	}
+}
+
+// loudness returns the average level over the window as a linear amplitude between
+// 0 and 1, and whether it is loud and long enough to be speech
+func (m *audioLevelMeter) loudness(now time.Time) (float64, bool) {
+	m.trim(now)
+
+	if len(m.samples) == 0 {
+		return 0, false
+	}
+
+	total, voiced := 0, 0
+	for _, sample := range m.samples {
+		total += int(sample.level)
+		if sample.voice {
+			voiced++
+		}
+	}
+
+	average := float64(total) / float64(len(m.samples))
+	speaking := average <= speakingThreshold && len(m.samples) >= minSpeakingSamples && voiced > 0
+
+	return math.Pow(10, -average/20), speaking
+}
+
+// parseAudioLevel reads the ssrc-audio-level extension of a packet
+func parseAudioLevel(pkt *rtp.Packet, extensionID uint8) (uint8, bool) {
+	payload := pkt.GetExtension(extensionID)
+	if payload == nil {
+		return silentLevel, false
+	}
+
+	extension := rtp.AudioLevelExtension{}
+	if err := extension.Unmarshal(payload); err != nil {
+		return silentLevel, false
+	}
+
+	return extension.Level, extension.Voice
+}
+
+// StartSpeakerDetection emits the ranked active speakers of each room to its peers,
+// at most once per interval, until the engine is closed
+func (e *Engine) StartSpeakerDetection(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		speaking := make(map[string]bool) // Rooms whose last event had speakers
+
+		for {
+			select {
+			case <-e.done:
+				return
+			case now := <-ticker.C:
+				e.emitActiveSpeakers(speaking, now)
+			}
+		}
+	}()
+}
+
+// emitActiveSpeakers sends the active speakers of every room where someone is
+// speaking, and an empty list once to rooms that just went quiet
+func (e *Engine) emitActiveSpeakers(speaking map[string]bool, now time.Time) {
+	e.listLock.RLock()
+	defer e.listLock.RUnlock()
+
+	rooms := make(map[string][]signaling.SpeakerLevel)
+	for _, roomID := range e.tracks.RoomIDs() {
+		rooms[roomID] = activeSpeakers(e.tracks.RoomTracks(roomID), now)
+	}
+
+	// Rooms whose last speakers unpublished still get an empty list
+	for roomID := range speaking {
+		if _, ok := rooms[roomID]; !ok {
+			rooms[roomID] = []signaling.SpeakerLevel{}
+		}
+	}
+
+	for roomID, speakers := range rooms {
+		if len(speakers) == 0 && !speaking[roomID] {
+			continue
+		}
+
+		if len(speakers) > 0 {
+			speaking[roomID] = true
+		} else {
+			delete(speaking, roomID)
+		}
+
+		e.broadcastEvent(roomID, nil, signaling.TypeActiveSpeakers, signaling.ActiveSpeakers{Speakers: speakers})
+	}
+}
+
+// activeSpeakers ranks the speaking audio tracks of a room, loudest first
+func activeSpeakers(tracks []*PublishedTrack, now time.Time) []signaling.SpeakerLevel {
+	speakers := []signaling.SpeakerLevel{}
+
+	for _, published := range tracks {
+		level, speaking := published.AudioLevel(now)
+		if !speaking {
+			continue
+		}
+
+		speaker := signaling.SpeakerLevel{TrackID: published.ID, Level: level}
+		if published.Owner != nil {
+			speaker.Participant = published.Owner.Username
+		}
+		speakers = append(speakers, speaker)
+	}
+
+	sort.Slice(speakers, func(i, j int) bool {
+		if speakers[i].Level != speakers[j].Level {
+			return speakers[i].Level > speakers[j].Level
+		}
+		return speakers[i].TrackID < speakers[j].TrackID
+	})
+
+	return speakers
+}
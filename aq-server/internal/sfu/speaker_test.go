@@ -0,0 +1,93 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+
+	"aq-server/internal/types"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestAudioLevelMeter(t *testing.T) {
+	start := time.Now()
+	meter := audioLevelMeter{}
+
+	// Loud speech for half a second
+	for i := 0; i < 25; i++ {
+		meter.observe(30, true, start.Add(time.Duration(i)*20*time.Millisecond))
+	}
+
+	level, speaking := meter.loudness(start.Add(500 * time.Millisecond))
+	if !speaking {
+		t.Error("Expected meter to detect speech")
+	}
+	if level <= 0 || level >= 1 {
+		t.Errorf("Expected level between 0 and 1, got %f", level)
+	}
+
+	// The window slides past the speech
+	if _, speaking := meter.loudness(start.Add(3 * time.Second)); speaking {
+		t.Error("Expected speech to leave the window")
+	}
+
+	// Background noise is not speech
+	quiet := audioLevelMeter{}
+	for i := 0; i < 25; i++ {
+		quiet.observe(90, true, start.Add(time.Duration(i)*20*time.Millisecond))
+	}
+	if _, speaking := quiet.loudness(start.Add(500 * time.Millisecond)); speaking {
+		t.Error("Expected quiet audio not to be speech")
+	}
+}
+
+func TestParseAudioLevel(t *testing.T) {
+	extension := rtp.AudioLevelExtension{Level: 42, Voice: true}
+	payload, err := extension.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal audio level: %v", err)
+	}
+
+	pkt := &rtp.Packet{}
+	if err := pkt.SetExtension(3, payload); err != nil {
+		t.Fatalf("Failed to set extension: %v", err)
+	}
+
+	level, voice := parseAudioLevel(pkt, 3)
+	if level != 42 || !voice {
+		t.Errorf("Expected level 42 with voice, got %d (voice=%v)", level, voice)
+	}
+
+	if level, _ := parseAudioLevel(pkt, 4); level != silentLevel {
+		t.Errorf("Expected silent level without the extension, got %d", level)
+	}
+}
+
+func TestActiveSpeakersRanking(t *testing.T) {
+	now := time.Now()
+
+	newSpeaker := func(name string, level uint8) *PublishedTrack {
+		owner := &types.PeerConnectionState{Username: name, RoomID: "room-a"}
+		published := newTestTrack(owner, name+"-audio")
+		published.Kind = webrtc.RTPCodecTypeAudio
+		published.audioLevelID = 1
+		for i := 0; i < 25; i++ {
+			published.audioLevel.observe(level, true, now.Add(-time.Duration(i)*20*time.Millisecond))
+		}
+		return published
+	}
+
+	speakers := activeSpeakers([]*PublishedTrack{
+		newSpeaker("alice", 40),
+		newSpeaker("bob", 20),
+		newSpeaker("carol", 100),
+	}, now)
+
+	if len(speakers) != 2 {
+		t.Fatalf("Expected 2 speakers, got %d", len(speakers))
+	}
+	if speakers[0].Participant != "bob" || speakers[1].Participant != "alice" {
+		t.Errorf("Expected bob then alice, got %s then %s", speakers[0].Participant, speakers[1].Participant)
+	}
+}
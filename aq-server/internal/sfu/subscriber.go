@@ -0,0 +1,242 @@
+package sfu
+
+import (
+	"sort"
+	"sync"
+
+	"aq-server/internal/types"
+
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/webrtc/v4"
+)
+
+// subscriber holds the forwarding state of one receiving peer
+type subscriber struct {
+	peer *types.PeerConnectionState
+
+	mu           sync.Mutex
+	twccEstimate uint64 // Downlink estimates in bits per second, 0 if unknown
+	rembEstimate uint64
+	downTracks   map[string]*DownTrack
+
+	autoSubscribe     bool            // Receive tracks nobody chose for explicitly
+	choices           map[string]bool // Explicit subscribe (true) or unsubscribe (false) by track ID
+	negotiationNeeded bool            // An offer is due even if no track changed
+	iceRestart        bool            // The next offer restarts ICE
+}
+
+// newSubscriber creates the forwarding state for a peer
+func newSubscriber(peer *types.PeerConnectionState) *subscriber {
+	return &subscriber{
+		peer:              peer,
+		downTracks:        make(map[string]*DownTrack),
+		autoSubscribe:     !peer.ManualSubscribe,
+		choices:           make(map[string]bool),
+		negotiationNeeded: true,
+	}
+}
+
+// wants reports whether the subscriber should receive a track
+func (s *subscriber) wants(trackID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if subscribed, ok := s.choices[trackID]; ok {
+		return subscribed
+	}
+
+	return s.autoSubscribe
+}
+
+// choose records an explicit subscribe or unsubscribe for tracks
+func (s *subscriber) choose(trackIDs []string, subscribed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, trackID := range trackIDs {
+		s.choices[trackID] = subscribed
+	}
+}
+
+// setNegotiationNeeded records whether an offer is due
+func (s *subscriber) setNegotiationNeeded(needed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.negotiationNeeded = needed
+}
+
+// requestICERestart makes the next offer restart ICE
+func (s *subscriber) requestICERestart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.negotiationNeeded = true
+	s.iceRestart = true
+}
+
+// offerOptions returns the options of the next offer
+func (s *subscriber) offerOptions() *webrtc.OfferOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &webrtc.OfferOptions{ICERestart: s.iceRestart}
+}
+
+// offerSent records that the due offer was sent
+func (s *subscriber) offerSent() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.negotiationNeeded = false
+	s.iceRestart = false
+}
+
+// isNegotiationNeeded reports whether an offer is due
+func (s *subscriber) isNegotiationNeeded() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.negotiationNeeded
+}
+
+// addDownTrack starts forwarding a published track to this subscriber
+func (s *subscriber) addDownTrack(downTrack *DownTrack) {
+	s.mu.Lock()
+	s.downTracks[downTrack.ID()] = downTrack
+	s.mu.Unlock()
+
+	downTrack.published.addDownTrack(downTrack)
+	s.allocate()
+}
+
+// removeDownTrack stops forwarding a published track to this subscriber
+func (s *subscriber) removeDownTrack(trackID string) {
+	s.mu.Lock()
+	downTrack, ok := s.downTracks[trackID]
+	delete(s.downTracks, trackID)
+	s.mu.Unlock()
+
+	if ok {
+		downTrack.published.removeDownTrack(downTrack)
+		s.allocate()
+	}
+}
+
+// downTrack returns the down track forwarding a track ID, or nil
+func (s *subscriber) downTrack(trackID string) *DownTrack {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.downTracks[trackID]
+}
+
+// close stops forwarding every track to this subscriber
+func (s *subscriber) close() {
+	s.mu.Lock()
+	downTracks := s.downTracks
+	s.downTracks = make(map[string]*DownTrack)
+	s.mu.Unlock()
+
+	for _, downTrack := range downTracks {
+		downTrack.published.removeDownTrack(downTrack)
+	}
+}
+
+// setEstimator attaches the TWCC bandwidth estimator of the peer's connection
+func (s *subscriber) setEstimator(estimator cc.BandwidthEstimator) {
+	s.mu.Lock()
+	s.twccEstimate = uint64(estimator.GetTargetBitrate())
+	s.mu.Unlock()
+
+	estimator.OnTargetBitrateChange(func(bitrate int) {
+		s.setTWCCEstimate(uint64(bitrate))
+	})
+
+	s.allocate()
+}
+
+// setTWCCEstimate records a new estimate from transport-wide congestion control
+func (s *subscriber) setTWCCEstimate(bitrate uint64) {
+	s.mu.Lock()
+	changed := significantChange(s.twccEstimate, bitrate)
+	if changed {
+		s.twccEstimate = bitrate
+	}
+	s.mu.Unlock()
+
+	if changed {
+		s.allocate()
+	}
+}
+
+// setREMBEstimate records a new estimate reported by the subscriber in a REMB
+func (s *subscriber) setREMBEstimate(bitrate uint64) {
+	s.mu.Lock()
+	changed := significantChange(s.rembEstimate, bitrate)
+	if changed {
+		s.rembEstimate = bitrate
+	}
+	s.mu.Unlock()
+
+	if changed {
+		s.allocate()
+	}
+}
+
+// estimate returns the downlink estimate in bits per second, 0 if unknown.
+// TWCC is preferred; REMB is used for subscribers that don't send TWCC feedback.
+// Callers must hold s.mu.
+func (s *subscriber) estimate() uint64 {
+	if s.twccEstimate > 0 {
+		return s.twccEstimate
+	}
+
+	return s.rembEstimate
+}
+
+// allocate distributes the downlink estimate across the down tracks, selecting
+// the simulcast layer of each video track and pausing those that don't fit
+func (s *subscriber) allocate() {
+	s.mu.Lock()
+	estimate := s.estimate()
+	downTracks := make([]*DownTrack, 0, len(s.downTracks))
+	for _, downTrack := range s.downTracks {
+		downTracks = append(downTracks, downTrack)
+	}
+	s.mu.Unlock()
+
+	if len(downTracks) == 0 {
+		return
+	}
+
+	// Deterministic order so the same tracks keep their bandwidth between allocations
+	sort.Slice(downTracks, func(i, j int) bool {
+		return downTracks[i].ID() < downTracks[j].ID()
+	})
+
+	requests := make([]bitrateRequest, len(downTracks))
+	for i, downTrack := range downTracks {
+		requests[i] = downTrack.bitrateRequest()
+	}
+
+	allocation := allocateBitrate(estimate, requests)
+	for i, downTrack := range downTracks {
+		downTrack.applyAllocation(requests[i].layers, allocation[i])
+	}
+}
+
+// significantChange reports whether an estimate moved enough to reallocate.
+// Small fluctuations are ignored so layers don't flap.
+func significantChange(previous, current uint64) bool {
+	if previous == 0 || current == 0 {
+		return previous != current
+	}
+
+	diff := previous - current
+	if current > previous {
+		diff = current - previous
+	}
+
+	return diff*20 > previous // More than 5%
+}
@@ -0,0 +1,56 @@
+package sfu
+
+import (
+	"testing"
+
+	"aq-server/internal/types"
+)
+
+func TestSubscriberWants(t *testing.T) {
+	auto := newSubscriber(&types.PeerConnectionState{})
+	auto.choose([]string{"screen"}, false)
+
+	if !auto.wants("camera") {
+		t.Error("Expected auto-subscriber to want camera")
+	}
+	if auto.wants("screen") {
+		t.Error("Expected auto-subscriber not to want unsubscribed screen")
+	}
+
+	manual := newSubscriber(&types.PeerConnectionState{ManualSubscribe: true})
+	manual.choose([]string{"screen"}, true)
+
+	if manual.wants("camera") {
+		t.Error("Expected manual subscriber not to want camera")
+	}
+	if !manual.wants("screen") {
+		t.Error("Expected manual subscriber to want subscribed screen")
+	}
+
+	manual.choose([]string{"screen"}, false)
+	if manual.wants("screen") {
+		t.Error("Expected manual subscriber not to want screen after unsubscribing")
+	}
+}
+
+func TestSubscriberICERestart(t *testing.T) {
+	sub := newSubscriber(&types.PeerConnectionState{})
+	sub.offerSent()
+
+	if sub.offerOptions().ICERestart {
+		t.Error("Expected offers not to restart ICE by default")
+	}
+
+	sub.requestICERestart()
+	if !sub.isNegotiationNeeded() {
+		t.Error("Expected an ICE restart to need negotiation")
+	}
+	if !sub.offerOptions().ICERestart {
+		t.Error("Expected the next offer to restart ICE")
+	}
+
+	sub.offerSent()
+	if sub.isNegotiationNeeded() || sub.offerOptions().ICERestart {
+		t.Error("Expected the ICE restart to be cleared once the offer was sent")
+	}
+}
@@ -0,0 +1,345 @@
+package sfu
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"aq-server/internal/signaling"
+	"aq-server/internal/types"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// PublishedTrack is a track received from a single publisher and fanned out to the
+// subscribers in its room. Simulcast tracks carry one layer per RID; other tracks
+// have a single layer with an empty RID.
+type PublishedTrack struct {
+	ID       string
+	StreamID string
+	Kind     webrtc.RTPCodecType
+	Codec    webrtc.RTPCodecParameters
+	Owner    *types.PeerConnectionState
+	RoomID   string
+	Source   TrackSource
+	Name     string
+
+	mu         sync.RWMutex
+	layers     map[string]*layer
+	downTracks map[*DownTrack]struct{}
+	muted      bool             // Not forwarded while muted
+	extensions map[uint8]string // Header extension URIs by the IDs negotiated with the publisher
+
+	audioLevelID uint8 // ID of the ssrc-audio-level extension, 0 if not negotiated
+	audioLevel   audioLevelMeter
+
+	forwarded atomic.Uint64 // Bytes forwarded to subscribers since the meter took them
+}
+
+// LayerInfo describes one encoding of a published track
+type LayerInfo struct {
+	RID     string `json:"rid"`
+	Bitrate uint64 `json:"bitrate"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+}
+
+// layer is a single received encoding of a published track
+type layer struct {
+	rid    string
+	ssrc   webrtc.SSRC
+	meter  bitrateMeter
+	width  int
+	height int
+	cache  *packetCache // Recent packets for retransmission, nil for audio
+}
+
+// newPublishedTrack creates a published track for the first remote track received
+func newPublishedTrack(owner *types.PeerConnectionState, t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver, declaration trackDeclaration) *PublishedTrack {
+	extensions := make(map[uint8]string)
+	audioLevelID := uint8(0)
+	if receiver != nil {
+		for _, extension := range receiver.GetParameters().HeaderExtensions {
+			extensions[uint8(extension.ID)] = extension.URI
+			if extension.URI == sdp.AudioLevelURI && t.Kind() == webrtc.RTPCodecTypeAudio {
+				audioLevelID = uint8(extension.ID)
+			}
+		}
+	}
+
+	return &PublishedTrack{
+		ID:         t.ID(),
+		StreamID:   t.StreamID(),
+		Kind:       t.Kind(),
+		Codec:      t.Codec(),
+		Owner:      owner,
+		RoomID:     owner.RoomKey(),
+		Source:     declaration.source,
+		Name:       declaration.name,
+		layers:     make(map[string]*layer),
+		downTracks: make(map[*DownTrack]struct{}),
+		extensions: extensions,
+
+		audioLevelID: audioLevelID,
+	}
+}
+
+// addLayer registers the encoding carried by a remote track
+func (p *PublishedTrack) addLayer(t *webrtc.TrackRemote) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l := &layer{
+		rid:  t.RID(),
+		ssrc: t.SSRC(),
+	}
+	if p.Kind == webrtc.RTPCodecTypeVideo {
+		l.cache = newPacketCache(packetCacheSize)
+	}
+
+	p.layers[t.RID()] = l
+}
+
+// takeForwarded returns the bytes forwarded to subscribers since it was last called
+func (p *PublishedTrack) takeForwarded() uint64 {
+	return p.forwarded.Swap(0)
+}
+
+// removeLayer unregisters an encoding and returns how many layers remain
+func (p *PublishedTrack) removeLayer(rid string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.layers, rid)
+
+	return len(p.layers)
+}
+
+// Info describes the track to subscribers
+func (p *PublishedTrack) Info() signaling.TrackInfo {
+	info := signaling.TrackInfo{
+		TrackID:  p.ID,
+		StreamID: p.StreamID,
+		Kind:     p.Kind.String(),
+		Source:   string(p.Source),
+		Name:     p.Name,
+		Muted:    p.Muted(),
+	}
+	if p.Owner != nil {
+		info.Participant = p.Owner.Username
+	}
+
+	return info
+}
+
+// AudioLevel returns the recent loudness of an audio track between 0 and 1, and
+// whether its publisher is speaking
+func (p *PublishedTrack) AudioLevel(now time.Time) (float64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.audioLevelID == 0 || p.muted {
+		return 0, false
+	}
+
+	return p.audioLevel.loudness(now)
+}
+
+// Muted reports whether the track is muted
+func (p *PublishedTrack) Muted() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.muted
+}
+
+// setMuted mutes or unmutes the track and reports whether that changed anything
+func (p *PublishedTrack) setMuted(muted bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	changed := p.muted != muted
+	p.muted = muted
+
+	return changed
+}
+
+// IsSimulcast reports whether the track is received as several encodings
+func (p *PublishedTrack) IsSimulcast() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for rid := range p.layers {
+		if rid != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Layers returns the received encodings ordered from lowest to highest quality
+func (p *PublishedTrack) Layers() []LayerInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	layers := make([]LayerInfo, 0, len(p.layers))
+	for _, l := range p.layers {
+		layers = append(layers, LayerInfo{
+			RID:     l.rid,
+			Bitrate: l.meter.Bitrate(),
+			Width:   l.width,
+			Height:  l.height,
+		})
+	}
+
+	sort.Slice(layers, func(i, j int) bool {
+		return layerLess(layers[i], layers[j])
+	})
+
+	return layers
+}
+
+// WriteRTP fans a packet received on the given layer out to every subscriber
+func (p *PublishedTrack) WriteRTP(rid string, pkt *rtp.Packet) {
+	keyframe := p.Kind == webrtc.RTPCodecTypeVideo && isKeyframe(p.Codec.MimeType, pkt.Payload)
+
+	now := time.Now()
+
+	p.mu.Lock()
+	if p.audioLevelID != 0 {
+		level, voice := parseAudioLevel(pkt, p.audioLevelID)
+		p.audioLevel.observe(level, voice, now)
+	}
+
+	muted := p.muted
+	l, ok := p.layers[rid]
+	if ok {
+		l.meter.Add(len(pkt.Payload), now)
+		if l.cache != nil {
+			l.cache.push(pkt)
+		}
+		if keyframe {
+			if width, height, found := vp8KeyframeSize(p.Codec.MimeType, pkt.Payload); found {
+				l.width, l.height = width, height
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	if !ok || muted {
+		return
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for downTrack := range p.downTracks {
+		downTrack.writeRTP(rid, pkt, keyframe)
+	}
+}
+
+// RequestKeyFrame asks the publisher for a keyframe on the given layer
+func (p *PublishedTrack) RequestKeyFrame(rid string) {
+	p.mu.RLock()
+	l, ok := p.layers[rid]
+	p.mu.RUnlock()
+
+	if !ok || p.Owner == nil || p.Owner.PeerConnection == nil {
+		return
+	}
+
+	_ = p.Owner.PeerConnection.WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{
+			MediaSSRC: uint32(l.ssrc),
+		},
+	})
+}
+
+// hopByHopExtensions are header extensions that only describe the link they were
+// received on, so they are never forwarded
+var hopByHopExtensions = map[string]bool{
+	sdp.TransportCCURI:           true,
+	sdp.ABSSendTimeURI:           true,
+	sdp.SDESMidURI:               true,
+	sdp.SDESRTPStreamIDURI:       true,
+	sdp.SDESRepairRTPStreamIDURI: true,
+}
+
+// packetFromCache returns a copy of a packet received on a layer if it is still cached
+func (p *PublishedTrack) packetFromCache(rid string, sn uint16) (*rtp.Packet, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	l, ok := p.layers[rid]
+	if !ok || l.cache == nil {
+		return nil, false
+	}
+
+	return l.cache.get(sn)
+}
+
+// RequestRetransmission asks the publisher to resend packets of a layer
+func (p *PublishedTrack) RequestRetransmission(rid string, sequenceNumbers []uint16) {
+	p.mu.RLock()
+	l, ok := p.layers[rid]
+	p.mu.RUnlock()
+
+	if !ok || len(sequenceNumbers) == 0 || p.Owner == nil || p.Owner.PeerConnection == nil {
+		return
+	}
+
+	_ = p.Owner.PeerConnection.WriteRTCP([]rtcp.Packet{
+		&rtcp.TransportLayerNack{
+			MediaSSRC: uint32(l.ssrc),
+			Nacks:     rtcp.NackPairsFromSequenceNumbers(sequenceNumbers),
+		},
+	})
+}
+
+// addDownTrack starts forwarding to a subscriber
+func (p *PublishedTrack) addDownTrack(downTrack *DownTrack) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.downTracks[downTrack] = struct{}{}
+}
+
+// removeDownTrack stops forwarding to a subscriber
+func (p *PublishedTrack) removeDownTrack(downTrack *DownTrack) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.downTracks, downTrack)
+}
+
+// bitrateMeter measures the bitrate of a packet stream over one second windows
+type bitrateMeter struct {
+	windowStart time.Time
+	bytes       uint64
+	bitrate     uint64
+}
+
+// Add accounts for n bytes received at now. Callers serialize access.
+func (m *bitrateMeter) Add(n int, now time.Time) {
+	if m.windowStart.IsZero() {
+		m.windowStart = now
+	}
+
+	m.bytes += uint64(n)
+
+	if elapsed := now.Sub(m.windowStart); elapsed >= time.Second {
+		m.bitrate = m.bytes * 8 * uint64(time.Second) / uint64(elapsed)
+		m.bytes = 0
+		m.windowStart = now
+	}
+}
+
+// Bitrate returns the bitrate of the last complete window in bits per second
+func (m *bitrateMeter) Bitrate() uint64 {
+	return m.bitrate
+}
@@ -0,0 +1,25 @@
+package sfu
+
+import "aq-server/internal/types"
+
+// participantData describes a participant in webhook events
+func participantData(peer *types.PeerConnectionState) map[string]any {
+	return map[string]any{
+		"room_id":     peer.RoomID,
+		"participant": peer.Username,
+		"user_type":   peer.UserType,
+		"token_id":    peer.TokenID,
+	}
+}
+
+// trackData describes a published track in webhook events
+func trackData(published *PublishedTrack) map[string]any {
+	return map[string]any{
+		"room_id":     published.Owner.RoomID,
+		"participant": published.Owner.Username,
+		"track_id":    published.ID,
+		"kind":        published.Kind.String(),
+		"source":      string(published.Source),
+		"name":        published.Name,
+	}
+}
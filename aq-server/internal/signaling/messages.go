@@ -0,0 +1,164 @@
+package signaling
+
+import (
+	"fmt"
+	"time"
+)
+
+// Client requests
+const (
+	TypeCandidate        = "candidate"         // webrtc.ICECandidateInit, both directions
+	TypeOffer            = "offer"             // webrtc.SessionDescription, both directions
+	TypeAnswer           = "answer"            // webrtc.SessionDescription, both directions
+	TypeChat             = "chat"              // ChatRequest, or Chat from the server
+	TypePublishTrack     = "publish_track"     // PublishTrack
+	TypeSubscribe        = "subscribe"         // Subscription
+	TypeUnsubscribe      = "unsubscribe"       // Subscription
+	TypeMute             = "mute"              // MuteRequest
+	TypeUnmute           = "unmute"            // MuteRequest
+	TypeVideoConstraints = "video_constraints" // VideoConstraints
+)
+
+// Server messages
+const (
+	TypeAck                = "ack"   // No data, answers the request with the same ID
+	TypeError              = "error" // Error, answers the request with the same ID if any
+	TypeSession            = "session"
+	TypeRoomSnapshot       = "room_snapshot"
+	TypeParticipantJoined  = "participant_joined"
+	TypeParticipantLeft    = "participant_left"
+	TypeParticipantUpdated = "participant_updated"
+	TypeTrackAvailable     = "track_available"
+	TypeTrackUnavailable   = "track_unavailable"
+	TypeTrackPublished     = "track_published"
+	TypeTrackUnpublished   = "track_unpublished"
+	TypeTrackMuted         = "track_muted"
+	TypeTrackUnmuted       = "track_unmuted"
+	TypeActiveSpeakers     = "active_speakers"
+)
+
+// ErrorCode classifies why a request failed
+type ErrorCode string
+
+const (
+	ErrorBadRequest    ErrorCode = "bad_request"    // The message or its data is malformed
+	ErrorUnknownType   ErrorCode = "unknown_type"   // The message type doesn't exist
+	ErrorNotFound      ErrorCode = "not_found"      // The request refers to a missing track or participant
+	ErrorNotAllowed    ErrorCode = "not_allowed"    // The sender may not make the request
+	ErrorInternal      ErrorCode = "internal"       // The server failed to carry out the request
+	ErrorRoomFull      ErrorCode = "room_full"      // Joining would exceed the room's capacity
+	ErrorQuotaExceeded ErrorCode = "quota_exceeded" // Joining would exceed a limit of the company's tier
+)
+
+// Error is the data of an error message
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Limit   string    `json:"limit,omitempty"` // The limit a room_full or quota_exceeded error hit
+	Max     int       `json:"max,omitempty"`   // The value of that limit
+}
+
+// NewError creates an error with a formatted message
+func NewError(code ErrorCode, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// ChatRequest is sent by a client to chat with its room
+type ChatRequest struct {
+	Message string `json:"message"`
+}
+
+// Chat is a chat message relayed to the room. Time is when the server relayed it, in
+// RFC 3339 and UTC.
+type Chat struct {
+	From    string `json:"from"`
+	Message string `json:"message"`
+	Time    string `json:"time"`
+}
+
+// NewChat creates a chat message of a participant relayed at sent
+func NewChat(from, message string, sent time.Time) Chat {
+	return Chat{From: from, Message: message, Time: sent.UTC().Format(time.RFC3339)}
+}
+
+// VideoConstraints is sent by a subscriber with the size it renders a track at
+type VideoConstraints struct {
+	TrackID string `json:"track_id"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+}
+
+// PublishTrack is sent by a publisher to declare a track before negotiating it
+type PublishTrack struct {
+	TrackID string `json:"track_id"`
+	Source  string `json:"source"` // "camera", "microphone", "screen", "screen_audio"
+	Name    string `json:"name,omitempty"`
+}
+
+// TrackInfo describes a track forwarded to a subscriber
+type TrackInfo struct {
+	TrackID     string `json:"track_id"`
+	StreamID    string `json:"stream_id,omitempty"`
+	Kind        string `json:"kind,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Participant string `json:"participant,omitempty"`
+	Muted       bool   `json:"muted,omitempty"`
+}
+
+// Participant describes a peer in a room and the tracks it publishes
+type Participant struct {
+	Participant string      `json:"participant"`
+	UserType    string      `json:"user_type,omitempty"`
+	Tracks      []TrackInfo `json:"tracks,omitempty"`
+}
+
+// RoomSnapshot is sent on join with everyone already in the room
+type RoomSnapshot struct {
+	RoomID       string        `json:"room_id"`
+	Participants []Participant `json:"participants"`
+}
+
+// MuteRequest is sent to mute or unmute tracks. Without a participant it applies to
+// the sender's own tracks; without a track ID to all of the participant's tracks.
+type MuteRequest struct {
+	TrackID     string `json:"track_id,omitempty"`
+	Participant string `json:"participant,omitempty"`
+}
+
+// TrackMuted is broadcast when a track is muted or unmuted
+type TrackMuted struct {
+	TrackID     string `json:"track_id"`
+	Participant string `json:"participant"`
+	MutedBy     string `json:"muted_by,omitempty"` // Set when a host muted someone else
+}
+
+// Subscription is sent by a subscriber to start or stop receiving tracks
+type Subscription struct {
+	TrackIDs []string `json:"track_ids"`
+}
+
+// SpeakerLevel is one entry of the active speakers of a room
+type SpeakerLevel struct {
+	Participant string  `json:"participant"`
+	TrackID     string  `json:"track_id"`
+	Level       float64 `json:"level"` // Linear loudness between 0 and 1
+}
+
+// ActiveSpeakers lists who is speaking in a room, loudest first
+type ActiveSpeakers struct {
+	Speakers []SpeakerLevel `json:"speakers"`
+}
+
+// Session is sent when a websocket attaches to a session. A client that reconnects
+// with the session ID within the grace period resumes where it left off.
+type Session struct {
+	SessionID   string `json:"session_id"`
+	GracePeriod int    `json:"grace_period"` // Seconds
+	Resumed     bool   `json:"resumed"`
+	Version     int    `json:"version"` // Negotiated protocol version
+}
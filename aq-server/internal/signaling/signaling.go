@@ -0,0 +1,141 @@
+// Package signaling defines the websocket signaling protocol spoken between clients
+// and the SFU. It is shared by the server and by Go clients such as tests.
+//
+// Version 1 is the original protocol: every message is {"event", "data"} where data
+// is a JSON document encoded as a string, and requests are never answered.
+// Version 2 carries data as JSON, lets clients tag requests with an ID and answers
+// each tagged request with an ack or an error. The version is negotiated with the
+// websocket subprotocol; clients that don't ask for one speak version 1.
+package signaling
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Version1 is the legacy {"event", "data"} protocol
+	Version1 = 1
+
+	// Version2 is the typed protocol with request IDs, acks and errors
+	Version2 = 2
+
+	// CurrentVersion is the newest version the server speaks
+	CurrentVersion = Version2
+
+	// subprotocolPrefix prefixes the version in the websocket subprotocol, e.g. "aq.v2"
+	subprotocolPrefix = "aq.v"
+)
+
+// Subprotocols lists the websocket subprotocols of the supported versions, preferred first
+var Subprotocols = []string{Subprotocol(Version2), Subprotocol(Version1)}
+
+// Subprotocol returns the websocket subprotocol that requests a version
+func Subprotocol(version int) string {
+	return fmt.Sprintf("%s%d", subprotocolPrefix, version)
+}
+
+// Negotiate picks the newest supported version among the subprotocols a client
+// offered. A client that offered none speaks version 1; one that only offered
+// unsupported versions can't be served.
+func Negotiate(offered []string) (int, bool) {
+	if len(offered) == 0 {
+		return Version1, true
+	}
+
+	best := 0
+	for _, protocol := range offered {
+		var version int
+		if !strings.HasPrefix(protocol, subprotocolPrefix) {
+			continue
+		}
+		if _, err := fmt.Sscanf(strings.TrimPrefix(protocol, subprotocolPrefix), "%d", &version); err != nil {
+			continue
+		}
+		if version >= Version1 && version <= CurrentVersion && version > best {
+			best = version
+		}
+	}
+
+	return best, best != 0
+}
+
+// Message is a decoded signaling message of any version
+type Message struct {
+	Type string          `json:"type"`
+	ID   string          `json:"id,omitempty"`   // Set by the client to correlate the response
+	Data json.RawMessage `json:"data,omitempty"` // Payload, see the type constants
+}
+
+// LegacyMessage is the version 1 envelope
+type LegacyMessage struct {
+	Event string `json:"event"`
+	Data  string `json:"data"`
+}
+
+// Encode serializes a message with its payload in the given protocol version
+func Encode(version int, typ, id string, payload any) ([]byte, error) {
+	var data []byte
+	if payload != nil {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return nil, fmt.Errorf("failed to marshal %s: %w", typ, err)
+		}
+	}
+
+	if version < Version2 {
+		return json.Marshal(LegacyMessage{Event: typ, Data: string(data)})
+	}
+
+	return json.Marshal(Message{Type: typ, ID: id, Data: data})
+}
+
+// Decode parses a message in the given protocol version
+func Decode(version int, raw []byte) (Message, error) {
+	if version >= Version2 {
+		message := Message{}
+		if err := json.Unmarshal(raw, &message); err != nil {
+			return Message{}, err
+		}
+		if message.Type == "" {
+			return Message{}, fmt.Errorf("message has no type")
+		}
+
+		return message, nil
+	}
+
+	legacy := LegacyMessage{}
+	if err := json.Unmarshal(raw, &legacy); err != nil {
+		return Message{}, err
+	}
+
+	message := Message{Type: legacy.Event}
+	switch {
+	case legacy.Data == "":
+	case legacy.Event == TypeChat:
+		// Legacy chat data is the plain text of the message
+		data, err := json.Marshal(ChatRequest{Message: legacy.Data})
+		if err != nil {
+			return Message{}, err
+		}
+		message.Data = data
+	default:
+		message.Data = json.RawMessage(legacy.Data)
+	}
+
+	return message, nil
+}
+
+// Unmarshal decodes the payload of a message. A message without data leaves v untouched.
+func (m Message) Unmarshal(v any) error {
+	if len(m.Data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(m.Data, v); err != nil {
+		return NewError(ErrorBadRequest, "invalid %s data: %v", m.Type, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name     string
+		offered  []string
+		expected int
+		ok       bool
+	}{
+		{"no subprotocol is version 1", nil, Version1, true},
+		{"picks the newest", []string{"aq.v1", "aq.v2"}, Version2, true},
+		{"skips unsupported versions", []string{"aq.v9", "aq.v1"}, Version1, true},
+		{"ignores other protocols", []string{"chat", "aq.v2"}, Version2, true},
+		{"rejects only unsupported", []string{"aq.v9", "chat"}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, ok := Negotiate(tt.offered)
+			if version != tt.expected || ok != tt.ok {
+				t.Errorf("Expected (%d, %v), got (%d, %v)", tt.expected, tt.ok, version, ok)
+			}
+		})
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, version := range []int{Version1, Version2} {
+		raw, err := Encode(version, TypeSubscribe, "r1", Subscription{TrackIDs: []string{"a"}})
+		if err != nil {
+			t.Fatalf("Failed to encode version %d: %v", version, err)
+		}
+
+		message, err := Decode(version, raw)
+		if err != nil {
+			t.Fatalf("Failed to decode version %d: %v", version, err)
+		}
+
+		if message.Type != TypeSubscribe {
+			t.Errorf("Expected type %s in version %d, got %s", TypeSubscribe, version, message.Type)
+		}
+
+		subscription := Subscription{}
+		if err := message.Unmarshal(&subscription); err != nil {
+			t.Fatalf("Failed to unmarshal version %d data: %v", version, err)
+		}
+		if len(subscription.TrackIDs) != 1 || subscription.TrackIDs[0] != "a" {
+			t.Errorf("Expected track IDs [a] in version %d, got %v", version, subscription.TrackIDs)
+		}
+	}
+}
+
+func TestEncodeVersion1(t *testing.T) {
+	raw, err := Encode(Version1, TypeTrackUnpublished, "ignored", TrackInfo{TrackID: "a"})
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	legacy := LegacyMessage{}
+	if err := json.Unmarshal(raw, &legacy); err != nil {
+		t.Fatalf("Failed to unmarshal legacy message: %v", err)
+	}
+
+	if legacy.Event != TypeTrackUnpublished || legacy.Data != `{"track_id":"a"}` {
+		t.Errorf("Expected data encoded as a string, got %+v", legacy)
+	}
+}
+
+func TestDecodeVersion1Chat(t *testing.T) {
+	message, err := Decode(Version1, []byte(`{"event":"chat","data":"Hello, world!"}`))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	chat := ChatRequest{}
+	if err := message.Unmarshal(&chat); err != nil {
+		t.Fatalf("Failed to unmarshal chat: %v", err)
+	}
+
+	if chat.Message != "Hello, world!" {
+		t.Errorf("Expected plain text chat, got %q", chat.Message)
+	}
+}
+
+func TestDecodeVersion2(t *testing.T) {
+	if _, err := Decode(Version2, []byte(`{"id":"r1"}`)); err == nil {
+		t.Error("Expected a message without type to be rejected")
+	}
+
+	message, err := Decode(Version2, []byte(`{"type":"mute","id":"r2","data":{"track_id":5}}`))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	if message.ID != "r2" {
+		t.Errorf("Expected request ID r2, got %q", message.ID)
+	}
+
+	var signalingErr *Error
+	if err := message.Unmarshal(&MuteRequest{}); !errors.As(err, &signalingErr) || signalingErr.Code != ErrorBadRequest {
+		t.Errorf("Expected a %s error for invalid data, got %v", ErrorBadRequest, err)
+	}
+}
+
+func TestNewChat(t *testing.T) {
+	sent := time.Date(2026, 10, 16, 14, 30, 45, 0, time.FixedZone("UTC+3", 3*60*60))
+
+	chat := NewChat("alice", "hello", sent)
+	if chat.From != "alice" || chat.Message != "hello" {
+		t.Errorf("Expected alice saying hello, got %+v", chat)
+	}
+	if chat.Time != "2026-10-16T11:30:45Z" {
+		t.Errorf("Expected the time it was sent in UTC, got %s", chat.Time)
+	}
+}
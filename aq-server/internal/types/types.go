@@ -2,16 +2,18 @@ package types
 
 import (
 	"sync"
+	"time"
+
+	"aq-server/internal/signaling"
 
 	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v4"
 )
 
-type WebsocketMessage struct {
-	Event string `json:"event"`
-	Data  string `json:"data"`
-}
+// WebsocketMessage is the envelope of signaling protocol version 1
+type WebsocketMessage = signaling.LegacyMessage
 
+// ChatMessage is a chat message relayed to signaling protocol version 1 clients
 type ChatMessage struct {
 	Event   string `json:"event"`
 	Message string `json:"message"`
@@ -21,15 +23,76 @@ type ChatMessage struct {
 
 type PeerConnectionState struct {
 	PeerConnection *webrtc.PeerConnection
-	Websocket      *ThreadSafeWriter
-	Username       string // New: username of the peer
-	RoomID         string // New: room ID this peer belongs to
-	UserType       string // New: user type (host, guest, presenter)
+	Websocket      *ThreadSafeWriter // nil for peers without signaling, e.g. WHIP publishers
+	Username       string            // New: username of the peer
+	RoomID         string            // New: room ID this peer belongs to
+	UserType       string            // New: user type (host, guest, presenter)
+	CompanyID      string            // Company that issued the peer's token
+	TokenID        string            // Stored token the peer joined with
+	RecordID       string            // Row of the peer's stay in the sessions table, if recorded
+
+	ManualSubscribe bool        // Only receive tracks subscribed to explicitly
+	Permissions     Permissions // What the peer's token allows it to do
+}
+
+// RoomKey identifies the peer's room among the rooms of all companies, which may
+// use the same room IDs
+func (p *PeerConnectionState) RoomKey() string {
+	if p.CompanyID == "" {
+		return p.RoomID
+	}
+
+	return p.CompanyID + "/" + p.RoomID
+}
+
+// Permissions are what a participant's token allows it to do
+type Permissions struct {
+	Publish   bool `json:"publish"`   // Publish tracks, over the websocket or WHIP
+	Subscribe bool `json:"subscribe"` // Receive tracks, over the websocket or WHEP
+	Chat      bool `json:"chat"`      // Send chat messages
+	Admin     bool `json:"admin"`     // Moderate other participants
+}
+
+// Permission names
+const (
+	PermissionPublish   = "publish"
+	PermissionSubscribe = "subscribe"
+	PermissionChat      = "chat"
+	PermissionAdmin     = "admin"
+)
+
+// DefaultPermissions are the permissions of tokens that don't set them: everything
+// but moderating other participants
+func DefaultPermissions() Permissions {
+	return Permissions{Publish: true, Subscribe: true, Chat: true}
+}
+
+// Allows reports whether the named permission is granted
+func (p Permissions) Allows(permission string) bool {
+	switch permission {
+	case PermissionPublish:
+		return p.Publish
+	case PermissionSubscribe:
+		return p.Subscribe
+	case PermissionChat:
+		return p.Chat
+	case PermissionAdmin:
+		return p.Admin
+	default:
+		return false
+	}
 }
 
 type ThreadSafeWriter struct {
 	*websocket.Conn
 	sync.Mutex
+
+	version int // Signaling protocol version spoken on Conn, version 1 if unset
+}
+
+// NewThreadSafeWriter wraps a websocket speaking the given signaling protocol version
+func NewThreadSafeWriter(conn *websocket.Conn, version int) *ThreadSafeWriter {
+	return &ThreadSafeWriter{Conn: conn, version: version}
 }
 
 func (t *ThreadSafeWriter) WriteJSON(v any) error {
@@ -38,3 +101,85 @@ func (t *ThreadSafeWriter) WriteJSON(v any) error {
 
 	return t.Conn.WriteJSON(v)
 }
+
+// Version returns the signaling protocol version spoken on the websocket
+func (t *ThreadSafeWriter) Version() int {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.version < signaling.Version1 {
+		return signaling.Version1
+	}
+	return t.version
+}
+
+// WriteEvent sends a server message in the protocol version of the websocket
+func (t *ThreadSafeWriter) WriteEvent(event string, payload any) error {
+	return t.write(event, "", payload)
+}
+
+// WriteResponse answers the request with the given ID with an ack, or with an error
+// if err is not nil. Version 1 clients get no responses, version 2 clients get
+// errors even for requests without an ID.
+func (t *ThreadSafeWriter) WriteResponse(requestID string, err *signaling.Error) error {
+	if t.Version() < signaling.Version2 {
+		return nil
+	}
+
+	if err != nil {
+		return t.write(signaling.TypeError, requestID, err)
+	}
+	if requestID == "" {
+		return nil
+	}
+
+	return t.write(signaling.TypeAck, requestID, nil)
+}
+
+// write encodes and sends a message
+func (t *ThreadSafeWriter) write(typ, id string, payload any) error {
+	t.Lock()
+	defer t.Unlock()
+
+	message, err := signaling.Encode(t.version, typ, id, payload)
+	if err != nil {
+		return err
+	}
+
+	return t.Conn.WriteMessage(websocket.TextMessage, message)
+}
+
+// Replace swaps the underlying websocket, e.g. when a client resumes its session
+// over a new connection, and returns the previous one
+func (t *ThreadSafeWriter) Replace(conn *websocket.Conn, version int) *websocket.Conn {
+	t.Lock()
+	defer t.Unlock()
+
+	previous := t.Conn
+	t.Conn = conn
+	t.version = version
+
+	return previous
+}
+
+// Close closes the current underlying websocket
+func (t *ThreadSafeWriter) Close() error {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.Conn.Close()
+}
+
+// CloseWithReason tells the client why the websocket is closed, then closes it
+func (t *ThreadSafeWriter) CloseWithReason(code int, reason string) error {
+	t.Lock()
+	defer t.Unlock()
+
+	message := websocket.FormatCloseMessage(code, reason)
+	if err := t.Conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second)); err != nil {
+		_ = t.Conn.Close()
+		return err
+	}
+
+	return t.Conn.Close()
+}
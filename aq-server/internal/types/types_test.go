@@ -4,6 +4,8 @@ import (
 	"sync"
 	"testing"
 
+	"aq-server/internal/signaling"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -74,3 +76,67 @@ func TestPeerConnectionState(t *testing.T) {
 		t.Error("Expected Websocket to be nil")
 	}
 }
+
+func TestPeerConnectionStateRoomKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		companyID string
+		expected  string
+	}{
+		{"without company", "", "lobby"},
+		{"with company", "acme", "acme/lobby"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pcs := PeerConnectionState{RoomID: "lobby", CompanyID: tt.companyID}
+			if key := pcs.RoomKey(); key != tt.expected {
+				t.Errorf("Expected room key %s, got %s", tt.expected, key)
+			}
+		})
+	}
+}
+
+func TestPermissionsAllows(t *testing.T) {
+	permissions := Permissions{Subscribe: true, Chat: true}
+
+	tests := []struct {
+		permission string
+		expected   bool
+	}{
+		{PermissionPublish, false},
+		{PermissionSubscribe, true},
+		{PermissionChat, true},
+		{PermissionAdmin, false},
+		{"record", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.permission, func(t *testing.T) {
+			if allowed := permissions.Allows(tt.permission); allowed != tt.expected {
+				t.Errorf("Expected Allows(%s) to be %v, got %v", tt.permission, tt.expected, allowed)
+			}
+		})
+	}
+}
+
+func TestThreadSafeWriterReplace(t *testing.T) {
+	first, second := &websocket.Conn{}, &websocket.Conn{}
+	tsw := &ThreadSafeWriter{Conn: first}
+
+	if tsw.Version() != signaling.Version1 {
+		t.Errorf("Expected an unset version to be %d, got %d", signaling.Version1, tsw.Version())
+	}
+
+	if previous := tsw.Replace(second, signaling.Version2); previous != first {
+		t.Errorf("Expected Replace to return the previous connection, got %p", previous)
+	}
+
+	if tsw.Conn != second {
+		t.Errorf("Expected the new connection to be current, got %p", tsw.Conn)
+	}
+
+	if tsw.Version() != signaling.Version2 {
+		t.Errorf("Expected version %d after Replace, got %d", signaling.Version2, tsw.Version())
+	}
+}
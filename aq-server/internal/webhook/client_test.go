@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestIsPublic(t *testing.T) {
+	for address, expected := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if public := IsPublic(netip.MustParseAddr(address)); public != expected {
+			t.Errorf("Expected %s to be public: %v, got %v", address, expected, public)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	rc := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	if _, err := NewClient(time.Second).Post(server.URL, "application/json", nil); err == nil {
+		t.Error("Expected the client to refuse connecting to a loopback address")
+	}
+	if len(rc.requests) != 0 {
+		t.Errorf("Expected no request, got %d", len(rc.requests))
+	}
+}
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	rc := &receiver{status: http.StatusOK}
+	target := httptest.NewServer(rc)
+	defer target.Close()
+	server := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer server.Close()
+
+	// Connect to the loopback test servers, keeping the redirect policy
+	client := NewClient(time.Second)
+	client.Transport = server.Client().Transport
+
+	response, err := client.Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("Expected the redirect as the response, got %v", err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusFound || len(rc.requests) != 0 {
+		t.Errorf("Expected the redirect not to be followed, got %d and %d requests", response.StatusCode, len(rc.requests))
+	}
+}
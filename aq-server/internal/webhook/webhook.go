@@ -0,0 +1,385 @@
+// Package webhook notifies companies of events in their rooms. Events are queued in
+// memory, stored as a delivery per subscribed webhook and sent as signed JSON in the
+// background, so slow webhooks never hold up storing new events; failed deliveries
+// are retried with exponential backoff, also after a restart.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"aq-server/internal/database"
+
+	"github.com/google/uuid"
+	"github.com/pion/logging"
+)
+
+// Event types
+const (
+	EventRoomStarted       = "room.started"
+	EventRoomFinished      = "room.finished"
+	EventParticipantJoined = "participant.joined"
+	EventParticipantLeft   = "participant.left"
+	EventTrackPublished    = "track.published"
+	EventTrackUnpublished  = "track.unpublished"
+	EventTest              = "webhook.test" // Sent by Test only, webhooks can't subscribe to it
+)
+
+// EventTypes are the event types webhooks can subscribe to
+var EventTypes = []string{
+	EventRoomStarted,
+	EventRoomFinished,
+	EventParticipantJoined,
+	EventParticipantLeft,
+	EventTrackPublished,
+	EventTrackUnpublished,
+}
+
+const (
+	queueSize        = 1024 // Events queued until they're stored, more are stored right away
+	dueBatchSize     = 100  // Deliveries attempted per interval at most
+	concurrency      = 8    // Deliveries attempted at once
+	maxErrorLength   = 1024 // Longest error kept in a delivery's log
+	maxResponseDrain = 64 << 10
+)
+
+// Headers of deliveries
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Event is something that happened in a company's room
+type Event struct {
+	ID        string
+	Type      string
+	CompanyID string
+	Time      time.Time
+	Data      map[string]any
+}
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	CreatedAt time.Time      `json:"created_at"`
+	Data      map[string]any `json:"data"`
+}
+
+// Sign returns the signature of a delivery sent at timestamp (Unix seconds): the hex
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed with the webhook's secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// secretPrefix marks webhook secrets, followed by secretBytes random bytes in hex
+const (
+	secretPrefix = "whsec_"
+	secretBytes  = 24
+)
+
+// NewSecret generates a secret to sign a webhook's deliveries with
+func NewSecret() (string, error) {
+	random := make([]byte, secretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+
+	return secretPrefix + hex.EncodeToString(random), nil
+}
+
+// Store reads webhooks and stores their deliveries, in the database unless replaced
+type Store struct {
+	Webhooks func(companyID string) ([]database.Webhook, error)                 // Active webhooks of a company
+	Create   func(deliveries []database.WebhookDelivery) error                  // Stores new deliveries
+	Due      func(now time.Time, limit int) ([]database.WebhookDelivery, error) // Pending deliveries due, with their webhooks
+	Update   func(delivery *database.WebhookDelivery) error                     // Records an attempt
+}
+
+// NewStore returns a store backed by the database
+func NewStore() Store {
+	return Store{
+		Webhooks: database.ListActiveWebhooks,
+		Create:   database.CreateWebhookDeliveries,
+		Due:      database.ListDueWebhookDeliveries,
+		Update:   database.UpdateWebhookDelivery,
+	}
+}
+
+// Dispatcher delivers events to the webhooks of their companies. Notify may be called
+// on a nil dispatcher, which discards events.
+type Dispatcher struct {
+	MaxAttempts int           // Attempts before a delivery fails
+	MinBackoff  time.Duration // Wait after the first failed attempt, doubled after each further one
+	MaxBackoff  time.Duration // Longest wait between attempts
+
+	store  Store
+	client *http.Client
+	logger logging.LeveledLogger
+	events chan Event
+	now    func() time.Time
+}
+
+// NewDispatcher creates a dispatcher that sends deliveries with client
+func NewDispatcher(store Store, client *http.Client, logger logging.LeveledLogger) *Dispatcher {
+	return &Dispatcher{
+		MaxAttempts: 10,
+		MinBackoff:  10 * time.Second,
+		MaxBackoff:  time.Hour,
+		store:       store,
+		client:      client,
+		logger:      logger,
+		events:      make(chan Event, queueSize),
+		now:         time.Now,
+	}
+}
+
+// Notify queues an event of a company. Events of peers without a company are dropped.
+// While the queue is full, events are stored right away instead, so none get lost.
+func (d *Dispatcher) Notify(companyID, eventType string, data map[string]any) {
+	if d == nil || companyID == "" {
+		return
+	}
+
+	e := Event{ID: uuid.NewString(), Type: eventType, CompanyID: companyID, Time: d.now(), Data: data}
+	select {
+	case d.events <- e:
+	default:
+		d.enqueue(e)
+	}
+}
+
+// Run stores queued events as deliveries while attempting the deliveries that are
+// due every interval in the background, until stop is closed. Events still queued
+// then are stored, to be delivered once the server runs again.
+func (d *Dispatcher) Run(interval time.Duration, stop <-chan struct{}) {
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		d.deliver(interval, stop)
+	}()
+
+	for {
+		select {
+		case e := <-d.events:
+			d.enqueue(e)
+		case <-stop:
+			for {
+				select {
+				case e := <-d.events:
+					d.enqueue(e)
+				default:
+					<-delivered
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver attempts the deliveries that are due every interval, until stop is closed
+func (d *Dispatcher) deliver(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.deliverDue()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// enqueue stores a delivery of an event for each webhook subscribed to it
+func (d *Dispatcher) enqueue(e Event) {
+	webhooks, err := d.store.Webhooks(e.CompanyID)
+	if err != nil {
+		d.logger.Errorf("Failed to look up webhooks of company %s: %v", e.CompanyID, err)
+		return
+	}
+
+	deliveries := []database.WebhookDelivery{}
+	for i := range webhooks {
+		if !webhooks[i].Subscribes(e.Type) {
+			continue
+		}
+
+		delivery, err := newDelivery(&webhooks[i], e)
+		if err != nil {
+			d.logger.Errorf("Failed to encode %s event: %v", e.Type, err)
+			return
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	if err := d.store.Create(deliveries); err != nil {
+		d.logger.Errorf("Failed to store deliveries of %s event: %v", e.Type, err)
+	}
+}
+
+// newDelivery creates a pending delivery of an event to a webhook, due right away
+func newDelivery(webhook *database.Webhook, e Event) (*database.WebhookDelivery, error) {
+	data := e.Data
+	if data == nil {
+		data = map[string]any{}
+	}
+	payload, err := json.Marshal(Payload{ID: e.ID, Type: e.Type, CreatedAt: e.Time.UTC(), Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	return &database.WebhookDelivery{
+		ID:            uuid.NewString(),
+		WebhookID:     webhook.ID,
+		CompanyID:     webhook.CompanyID,
+		EventID:       e.ID,
+		EventType:     e.Type,
+		Payload:       payload,
+		Status:        database.DeliveryPending,
+		NextAttemptAt: e.Time,
+	}, nil
+}
+
+// deliverDue attempts the deliveries that are due, a few at a time
+func (d *Dispatcher) deliverDue() {
+	deliveries, err := d.store.Due(d.now(), dueBatchSize)
+	if err != nil {
+		d.logger.Errorf("Failed to look up due webhook deliveries: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			d.attempt(delivery, true)
+			if err := d.store.Update(delivery); err != nil {
+				d.logger.Errorf("Failed to record webhook delivery %s: %v", delivery.ID, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// attempt sends a delivery to its webhook once and records the outcome on it. A
+// failed delivery stays pending for another attempt after a backoff if retry is set
+// and it has attempts left.
+func (d *Dispatcher) attempt(delivery *database.WebhookDelivery, retry bool) {
+	started := d.now()
+	status, err := d.send(delivery)
+
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.DurationMs = d.now().Sub(started).Milliseconds()
+	delivery.LastError = ""
+
+	switch {
+	case err == nil:
+		delivered := d.now()
+		delivery.Status = database.DeliveryDelivered
+		delivery.DeliveredAt = &delivered
+		return
+	case retry && delivery.Attempts < d.MaxAttempts:
+		delivery.Status = database.DeliveryPending
+		delivery.NextAttemptAt = d.now().Add(d.backoff(delivery.Attempts))
+	default:
+		delivery.Status = database.DeliveryFailed
+	}
+
+	delivery.LastError = err.Error()
+	if len(delivery.LastError) > maxErrorLength {
+		delivery.LastError = delivery.LastError[:maxErrorLength]
+	}
+	d.logger.Warnf("Webhook delivery %s of %s event failed (attempt %d): %v", delivery.ID, delivery.EventType, delivery.Attempts, err)
+}
+
+// backoff is how long to wait after a delivery's attempts failed
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.MinBackoff
+	for i := 1; i < attempts && wait < d.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, d.MaxBackoff)
+}
+
+// send posts a delivery's payload to its webhook, returning the response status. Any
+// status but 2xx fails the delivery.
+func (d *Dispatcher) send(delivery *database.WebhookDelivery) (int, error) {
+	if delivery.Webhook == nil {
+		return 0, fmt.Errorf("webhook %s not found", delivery.WebhookID)
+	}
+
+	timestamp := d.now().Unix()
+	request, err := http.NewRequest(http.MethodPost, delivery.Webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "aq-server-webhooks")
+	request.Header.Set(HeaderEvent, delivery.EventType)
+	request.Header.Set(HeaderDelivery, delivery.ID)
+	request.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(HeaderSignature, Sign(delivery.Webhook.Secret, timestamp, delivery.Payload))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, maxResponseDrain))
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Errorf("webhook responded with %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// Test sends a webhook.test event to a webhook right away, once, and stores the
+// delivery in its log
+func (d *Dispatcher) Test(webhook *database.Webhook) (*database.WebhookDelivery, error) {
+	delivery, err := newDelivery(webhook, Event{
+		ID:        uuid.NewString(),
+		Type:      EventTest,
+		CompanyID: webhook.CompanyID,
+		Time:      d.now(),
+		Data:      map[string]any{"webhook_id": webhook.ID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Webhook = webhook
+	d.attempt(delivery, false)
+
+	delivery.Webhook = nil
+	if err := d.store.Create([]database.WebhookDelivery{*delivery}); err != nil {
+		return delivery, err
+	}
+	return delivery, nil
+}
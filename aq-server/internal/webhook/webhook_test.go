@@ -0,0 +1,313 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"aq-server/internal/database"
+
+	"github.com/pion/logging"
+)
+
+// memoryStore keeps webhooks and deliveries in memory
+type memoryStore struct {
+	mu         sync.Mutex
+	webhooks   []database.Webhook
+	deliveries []*database.WebhookDelivery
+}
+
+func (m *memoryStore) store() Store {
+	return Store{
+		Webhooks: func(companyID string) ([]database.Webhook, error) {
+			webhooks := []database.Webhook{}
+			for _, webhook := range m.webhooks {
+				if webhook.CompanyID == companyID && webhook.IsActive {
+					webhooks = append(webhooks, webhook)
+				}
+			}
+			return webhooks, nil
+		},
+		Create: func(deliveries []database.WebhookDelivery) error {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			for i := range deliveries {
+				delivery := deliveries[i]
+				m.deliveries = append(m.deliveries, &delivery)
+			}
+			return nil
+		},
+		Due: func(now time.Time, limit int) ([]database.WebhookDelivery, error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			due := []database.WebhookDelivery{}
+			for _, delivery := range m.deliveries {
+				if delivery.Status == database.DeliveryPending && !delivery.NextAttemptAt.After(now) && len(due) < limit {
+					d := *delivery
+					for i := range m.webhooks {
+						if m.webhooks[i].ID == d.WebhookID {
+							d.Webhook = &m.webhooks[i]
+						}
+					}
+					due = append(due, d)
+				}
+			}
+			return due, nil
+		},
+		Update: func(delivery *database.WebhookDelivery) error {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			for i, stored := range m.deliveries {
+				if stored.ID == delivery.ID {
+					updated := *delivery
+					updated.Webhook = nil
+					m.deliveries[i] = &updated
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// receiver records the requests it gets and answers them with status
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+// newTestDispatcher returns a dispatcher delivering to webhooks at the URL of a
+// receiver, with a clock that only moves when advanced
+func newTestDispatcher(t *testing.T, rc *receiver, webhooks ...database.Webhook) (*Dispatcher, *memoryStore, func(time.Duration)) {
+	server := httptest.NewServer(rc)
+	t.Cleanup(server.Close)
+
+	for i := range webhooks {
+		webhooks[i].URL = server.URL
+	}
+	memory := &memoryStore{webhooks: webhooks}
+
+	d := NewDispatcher(memory.store(), server.Client(), logging.NewDefaultLoggerFactory().NewLogger("webhook-test"))
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+
+	return d, memory, func(step time.Duration) { now = now.Add(step) }
+}
+
+// drain stores the queued events
+func drain(d *Dispatcher) {
+	for {
+		select {
+		case e := <-d.events:
+			d.enqueue(e)
+		default:
+			return
+		}
+	}
+}
+
+func TestDispatcherDelivers(t *testing.T) {
+	rc := &receiver{status: http.StatusOK}
+	d, memory, _ := newTestDispatcher(t, rc,
+		database.Webhook{ID: "all", CompanyID: "acme", Secret: "whsec_all", IsActive: true},
+		database.Webhook{ID: "rooms", CompanyID: "acme", Secret: "whsec_rooms", Events: "room.started,room.finished", IsActive: true},
+		database.Webhook{ID: "inactive", CompanyID: "acme", Secret: "whsec_inactive", IsActive: false},
+		database.Webhook{ID: "globex", CompanyID: "globex", Secret: "whsec_globex", IsActive: true},
+	)
+
+	d.Notify("acme", EventParticipantJoined, map[string]any{"room_id": "lobby", "participant": "alice"})
+	d.Notify("", EventParticipantJoined, nil) // peers without a company aren't notified
+	drain(d)
+
+	if len(memory.deliveries) != 1 || memory.deliveries[0].WebhookID != "all" {
+		t.Fatalf("Expected one delivery to the webhook subscribed to everything, got %+v", memory.deliveries)
+	}
+
+	d.deliverDue()
+
+	if len(rc.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(rc.requests))
+	}
+	request, body := rc.requests[0], rc.bodies[0]
+
+	timestamp, err := strconv.ParseInt(request.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("Expected a timestamp, got %q", request.Header.Get(HeaderTimestamp))
+	}
+	if signature := request.Header.Get(HeaderSignature); signature != Sign("whsec_all", timestamp, body) {
+		t.Errorf("Expected the body to be signed with the webhook's secret, got %s", signature)
+	}
+	if event := request.Header.Get(HeaderEvent); event != EventParticipantJoined {
+		t.Errorf("Expected a %s event, got %s", EventParticipantJoined, event)
+	}
+
+	payload := Payload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("Expected a JSON payload, got %s", body)
+	}
+	if payload.Type != EventParticipantJoined || payload.Data["participant"] != "alice" {
+		t.Errorf("Expected alice joining, got %+v", payload)
+	}
+
+	delivery := memory.deliveries[0]
+	if delivery.Status != database.DeliveryDelivered || delivery.Attempts != 1 || delivery.ResponseStatus != http.StatusOK {
+		t.Errorf("Expected the delivery to succeed on the first attempt, got %+v", delivery)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	rc := &receiver{status: http.StatusServiceUnavailable}
+	d, memory, advance := newTestDispatcher(t, rc,
+		database.Webhook{ID: "hook", CompanyID: "acme", Secret: "whsec_hook", IsActive: true},
+	)
+	d.MaxAttempts = 3
+
+	d.Notify("acme", EventRoomStarted, map[string]any{"room_id": "lobby"})
+	drain(d)
+
+	d.deliverDue()
+	delivery := memory.deliveries[0]
+	if delivery.Status != database.DeliveryPending || delivery.Attempts != 1 || delivery.ResponseStatus != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the delivery to be retried, got %+v", delivery)
+	}
+	if wait := delivery.NextAttemptAt.Sub(d.now()); wait != d.MinBackoff {
+		t.Errorf("Expected to wait %v before the second attempt, got %v", d.MinBackoff, wait)
+	}
+
+	// Nothing is attempted before the backoff passed
+	d.deliverDue()
+	if len(rc.requests) != 1 {
+		t.Errorf("Expected no attempt during the backoff, got %d requests", len(rc.requests))
+	}
+
+	advance(d.MinBackoff)
+	d.deliverDue()
+	delivery = memory.deliveries[0]
+	if wait := delivery.NextAttemptAt.Sub(d.now()); wait != 2*d.MinBackoff {
+		t.Errorf("Expected the backoff to double, got %v", wait)
+	}
+
+	advance(2 * d.MinBackoff)
+	d.deliverDue()
+	delivery = memory.deliveries[0]
+	if delivery.Status != database.DeliveryFailed || delivery.Attempts != 3 || delivery.LastError == "" {
+		t.Errorf("Expected the delivery to fail after 3 attempts, got %+v", delivery)
+	}
+
+	// A receiver that recovers gets deliveries of new events
+	rc.status = http.StatusNoContent
+	d.Notify("acme", EventRoomFinished, nil)
+	drain(d)
+	d.deliverDue()
+	if delivery := memory.deliveries[1]; delivery.Status != database.DeliveryDelivered {
+		t.Errorf("Expected the next event to be delivered, got %+v", delivery)
+	}
+}
+
+func TestNotifyStoresWhenQueueFull(t *testing.T) {
+	rc := &receiver{status: http.StatusOK}
+	d, memory, _ := newTestDispatcher(t, rc,
+		database.Webhook{ID: "hook", CompanyID: "acme", Secret: "whsec_hook", IsActive: true},
+	)
+
+	for i := 0; i <= queueSize; i++ {
+		d.Notify("acme", EventTrackPublished, nil)
+	}
+	if len(memory.deliveries) != 1 {
+		t.Fatalf("Expected the event that didn't fit the queue to be stored right away, got %d deliveries", len(memory.deliveries))
+	}
+
+	drain(d)
+	if len(memory.deliveries) != queueSize+1 {
+		t.Errorf("Expected every event to be stored, got %d deliveries", len(memory.deliveries))
+	}
+}
+
+func TestDispatcherRun(t *testing.T) {
+	rc := &receiver{status: http.StatusOK}
+	d, memory, _ := newTestDispatcher(t, rc,
+		database.Webhook{ID: "hook", CompanyID: "acme", Secret: "whsec_hook", IsActive: true},
+	)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(10*time.Millisecond, stop)
+	}()
+
+	d.Notify("acme", EventRoomStarted, map[string]any{"room_id": "lobby"})
+	deadline := time.Now().Add(time.Second)
+	for {
+		rc.mu.Lock()
+		requests := len(rc.requests)
+		rc.mu.Unlock()
+		if requests == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the event to be delivered in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Events notified before stopping are stored, to be delivered later
+	d.Notify("acme", EventRoomFinished, nil)
+	close(stop)
+	<-done
+
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	if len(memory.deliveries) != 2 {
+		t.Errorf("Expected both events to be stored, got %d deliveries", len(memory.deliveries))
+	}
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	d := NewDispatcher(Store{}, nil, nil)
+	d.MinBackoff, d.MaxBackoff = time.Second, 5*time.Second
+
+	for attempts, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 20: 5 * time.Second} {
+		if wait := d.backoff(attempts); wait != expected {
+			t.Errorf("Expected to wait %v after %d attempts, got %v", expected, attempts, wait)
+		}
+	}
+}
+
+func TestDispatcherTest(t *testing.T) {
+	rc := &receiver{status: http.StatusInternalServerError}
+	webhook := database.Webhook{ID: "hook", CompanyID: "acme", Secret: "whsec_hook", IsActive: true}
+	d, memory, _ := newTestDispatcher(t, rc, webhook)
+
+	delivery, err := d.Test(&memory.webhooks[0])
+	if err != nil {
+		t.Fatalf("Expected the test delivery to be stored, got %v", err)
+	}
+	if delivery.EventType != EventTest || delivery.Status != database.DeliveryFailed || delivery.ResponseStatus != http.StatusInternalServerError {
+		t.Errorf("Expected a failed test delivery without retries, got %+v", delivery)
+	}
+	if len(memory.deliveries) != 1 || len(rc.requests) != 1 {
+		t.Errorf("Expected one logged delivery and one request, got %d and %d", len(memory.deliveries), len(rc.requests))
+	}
+}
+
+func TestNilDispatcher(t *testing.T) {
+	var d *Dispatcher
+	d.Notify("acme", EventRoomStarted, nil)
+}
@@ -2,6 +2,8 @@
 -- AQ Server Database Schema - UUID Best Practices
 -- ============================================================================
 -- Drop all tables if they exist
+DROP TABLE IF EXISTS webhook_deliveries CASCADE;
+DROP TABLE IF EXISTS webhooks CASCADE;
 DROP TABLE IF EXISTS rate_limit_tracker CASCADE;
 DROP TABLE IF EXISTS audit_logs CASCADE;
 DROP TABLE IF EXISTS analytics CASCADE;
@@ -21,7 +23,7 @@ CREATE TABLE companies (
   id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
   company_id VARCHAR(50) UNIQUE NOT NULL,
   name VARCHAR(255) NOT NULL,
-  api_key VARCHAR(255) UNIQUE NOT NULL,
+  api_key VARCHAR(255) UNIQUE, -- legacy plaintext key, moved to api_keys
   secret_key VARCHAR(255) NOT NULL,
   tier VARCHAR(50) DEFAULT 'free' CHECK (tier IN ('free', 'pro', 'enterprise')),
   is_active BOOLEAN DEFAULT TRUE,
@@ -36,17 +38,20 @@ CREATE INDEX idx_companies_is_active ON companies(is_active);
 CREATE INDEX idx_companies_created_at ON companies(created_at DESC);
 
 -- ============================================================================
--- 2. API_KEYS TABLE - For rate limiting
+-- 2. API_KEYS TABLE - Hashed API keys, several per company
 -- ============================================================================
 CREATE TABLE api_keys (
   id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
   company_id UUID NOT NULL REFERENCES companies(id) ON DELETE CASCADE,
   api_key_hash VARCHAR(255) UNIQUE NOT NULL,
+  prefix VARCHAR(20),
   name VARCHAR(100),
   is_active BOOLEAN DEFAULT TRUE,
-  rate_limit_per_minute INT DEFAULT 60,
+  rate_limit_per_minute INT DEFAULT 0, -- 0 uses the server's default limit
   created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  last_used_at TIMESTAMP WITH TIME ZONE,
+  revoked_at TIMESTAMP WITH TIME ZONE
 );
 
 CREATE INDEX idx_api_keys_company_id ON api_keys(company_id);
@@ -67,6 +72,7 @@ CREATE TABLE tokens (
   expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
   is_used BOOLEAN DEFAULT FALSE,
   used_at TIMESTAMP WITH TIME ZONE,
+  single_use BOOLEAN DEFAULT FALSE,
   revoked BOOLEAN DEFAULT FALSE
 );
 
@@ -98,7 +104,7 @@ CREATE INDEX idx_rooms_room_id ON rooms(room_id);
 CREATE INDEX idx_rooms_created_at ON rooms(created_at DESC);
 
 -- ============================================================================
--- 5. SESSIONS TABLE - Active user sessions
+-- 5. SESSIONS TABLE - Participants' stays in rooms
 -- ============================================================================
 CREATE TABLE sessions (
   id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -108,7 +114,9 @@ CREATE TABLE sessions (
   token_id UUID REFERENCES tokens(id) ON DELETE SET NULL,
   connected_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
   disconnected_at TIMESTAMP WITH TIME ZONE,
+  duration_seconds INTEGER,
   peer_address VARCHAR(100),
+  user_agent VARCHAR(512),
   metadata JSONB DEFAULT '{}'::jsonb
 );
 
@@ -119,17 +127,19 @@ CREATE INDEX idx_sessions_active ON sessions(company_id, room_id) WHERE disconne
 CREATE INDEX idx_sessions_connected_at ON sessions(connected_at DESC);
 
 -- ============================================================================
--- 6. ANALYTICS TABLE - Usage analytics
+-- 6. ANALYTICS TABLE - Daily usage per room
 -- ============================================================================
 CREATE TABLE analytics (
   id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
   company_id UUID NOT NULL REFERENCES companies(id) ON DELETE CASCADE,
-  metric_type VARCHAR(50) NOT NULL,
+  room_id VARCHAR(255) NOT NULL,
+  metric_type VARCHAR(50) NOT NULL, -- participant_seconds, published_track_seconds, forwarded_bytes
   metric_date DATE NOT NULL,
-  value INT DEFAULT 0,
+  value BIGINT DEFAULT 0,
   metadata JSONB DEFAULT '{}'::jsonb,
   created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-  UNIQUE(company_id, metric_type, metric_date)
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE(company_id, room_id, metric_type, metric_date)
 );
 
 CREATE INDEX idx_analytics_company_id ON analytics(company_id);
@@ -153,7 +163,7 @@ CREATE TABLE audit_logs (
   created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE INDEX idx_audit_logs_company_id ON audit_logs(company_id);
+CREATE INDEX idx_audit_logs_company_id ON audit_logs(company_id, created_at DESC);
 CREATE INDEX idx_audit_logs_event_type ON audit_logs(event_type);
 CREATE INDEX idx_audit_logs_created_at ON audit_logs(created_at DESC);
 
@@ -163,18 +173,75 @@ CREATE INDEX idx_audit_logs_created_at ON audit_logs(created_at DESC);
 CREATE TABLE rate_limit_tracker (
   id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
   company_id UUID NOT NULL REFERENCES companies(id) ON DELETE CASCADE,
-  api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+  credential_type VARCHAR(20) NOT NULL DEFAULT 'api_key', -- api_key or token
+  credential_id VARCHAR(255) NOT NULL DEFAULT '',          -- ID of the API key or room token
   endpoint VARCHAR(100),
   request_count INT DEFAULT 1,
   window_start TIMESTAMP WITH TIME ZONE NOT NULL,
   window_end TIMESTAMP WITH TIME ZONE NOT NULL,
-  UNIQUE(company_id, api_key_id, endpoint, window_start)
+  UNIQUE(company_id, credential_type, credential_id, endpoint, window_start)
 );
 
 CREATE INDEX idx_rate_limit_company_id ON rate_limit_tracker(company_id);
-CREATE INDEX idx_rate_limit_api_key_id ON rate_limit_tracker(api_key_id);
+CREATE INDEX idx_rate_limit_credential ON rate_limit_tracker(credential_type, credential_id);
 CREATE INDEX idx_rate_limit_window ON rate_limit_tracker(window_start, window_end);
 
+-- ============================================================================
+-- 9. SIGNING_KEYS TABLE - Public keys companies sign their own tokens with
+-- ============================================================================
+CREATE TABLE signing_keys (
+  id VARCHAR(64) PRIMARY KEY,
+  company_id UUID NOT NULL REFERENCES companies(id) ON DELETE CASCADE,
+  algorithm VARCHAR(10) NOT NULL,
+  public_key TEXT NOT NULL,
+  active BOOLEAN DEFAULT TRUE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  retired_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_signing_keys_company_id ON signing_keys(company_id);
+CREATE INDEX idx_signing_keys_active ON signing_keys(active);
+
+-- ============================================================================
+-- 10. WEBHOOKS TABLE - Endpoints companies are notified of room events at
+-- ============================================================================
+CREATE TABLE webhooks (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  company_id UUID NOT NULL REFERENCES companies(id) ON DELETE CASCADE,
+  url VARCHAR(2048) NOT NULL,
+  secret VARCHAR(100) NOT NULL,
+  events TEXT DEFAULT '', -- comma-separated event types, empty for all
+  is_active BOOLEAN DEFAULT TRUE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhooks_company_id ON webhooks(company_id);
+
+-- ============================================================================
+-- 11. WEBHOOK_DELIVERIES TABLE - Retry queue and delivery log of webhook events
+-- ============================================================================
+CREATE TABLE webhook_deliveries (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+  company_id UUID NOT NULL REFERENCES companies(id) ON DELETE CASCADE,
+  event_id VARCHAR(50) NOT NULL,
+  event_type VARCHAR(50) NOT NULL,
+  payload JSONB NOT NULL,
+  status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'delivered', 'failed')),
+  attempts INTEGER DEFAULT 0,
+  next_attempt_at TIMESTAMP WITH TIME ZONE,
+  response_status INTEGER DEFAULT 0,
+  last_error VARCHAR(1024),
+  duration_ms BIGINT DEFAULT 0,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at DESC);
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+
 -- ============================================================================
 -- TRIGGERS & FUNCTIONS
 -- ============================================================================
@@ -202,6 +269,11 @@ BEFORE UPDATE ON api_keys
 FOR EACH ROW
 EXECUTE FUNCTION update_updated_at_column();
 
+CREATE TRIGGER trigger_webhooks_updated_at
+BEFORE UPDATE ON webhooks
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
+
 -- ============================================================================
 -- SAMPLE DATA
 -- ============================================================================